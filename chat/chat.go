@@ -11,8 +11,6 @@ import (
 	"time"
 
 	"y-a-t-s/sockchat/config"
-
-	"github.com/gen2brain/beeep"
 )
 
 type Chat struct {
@@ -21,6 +19,22 @@ type Chat struct {
 	Errs    chan error
 	Feeder  feeder
 	History chan chan Message
+	// Slash commands added by scripts.
+	Commands *CommandTable
+
+	notifier *notifier
+	scripts  *scriptEngine
+
+	// Feed for the chat logger, if enabled.
+	logFeed *feed
+	// Address metrics are served on, if enabled.
+	metricsAddr string
+	// IRC server, if enabled.
+	irc *ircServer
+
+	reloads    chan cfgReload
+	cfgUpdates chan ConfigUpdate
+	pending    *pendingCfg
 }
 
 func NewChat(ctx context.Context, cfg config.Config) (*Chat, error) {
@@ -34,22 +48,57 @@ func NewChat(ctx context.Context, cfg config.Config) (*Chat, error) {
 		Errs:    s.errLog,
 		History: make(chan chan Message, 1),
 		Feeder:  newFeeder(ctx),
+
+		Commands: newCommandTable(),
+
+		reloads:    make(chan cfgReload),
+		cfgUpdates: make(chan ConfigUpdate, 1),
+		pending:    &pendingCfg{cfg: cfg},
 	}
+	c.notifier = newNotifier(cfg, s.errLog)
+	c.scripts = newScriptEngine(c, cfg)
 
 	return c, nil
 }
 
+// Receives errors the user needs to act on before reconnecting will work,
+// like a TLS pin mismatch or a changed onion address. Only the latest is kept.
+func (c *Chat) Alerts() <-chan error {
+	return c.alerts
+}
+
 func (c *Chat) Reconnect(ctx context.Context) error {
 	return c.sock.connect(ctx)
 }
 
-func (c *Chat) Start(ctx context.Context) {
+// Switch to new Tor circuits and reconnect, which usually gives a new exit IP.
+func (c *Chat) NewIdentity(ctx context.Context) error {
+	// Held so a reload can't replace or stop the proxy while it's used.
+	c.transportMx.Lock()
+	err := errors.New("Not connected through Tor.")
+	if c.proxy.isTor() {
+		err = c.proxy.newnym()
+	}
+	c.transportMx.Unlock()
+	if err != nil {
+		return err
+	}
+	c.infoLog <- "Tor switched to new circuits."
+
+	return c.Reconnect(ctx)
+}
+
+// Run the chat until ctx is done. Returns an error if it couldn't start.
+func (c *Chat) Start(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	var wg sync.WaitGroup
+	var (
+		wg  sync.WaitGroup
+		err error
+	)
 
-	wg.Add(2)
+	wg.Add(3)
 	go func() {
 		defer wg.Done()
 		defer cancel()
@@ -58,11 +107,16 @@ func (c *Chat) Start(ctx context.Context) {
 	go func() {
 		defer wg.Done()
 		defer cancel()
-		c.router(ctx)
+		err = c.router(ctx)
 		c.stop()
 	}()
+	go func() {
+		defer wg.Done()
+		c.scripts.run(ctx)
+	}()
 
 	wg.Wait()
+	return err
 }
 
 func (c *Chat) recordHistory(feed <-chan *Message) chan chan Message {
@@ -119,7 +173,8 @@ func (c *Chat) recordHistory(feed <-chan *Message) chan chan Message {
 	return out
 }
 
-func NewErrLog() (*os.File, error) {
+// suffix is appended to the file name to keep logs of concurrent sessions apart.
+func NewErrLog(suffix string) (*os.File, error) {
 	cfgDir, err := config.ConfigDir()
 	if err != nil {
 		return nil, err
@@ -129,27 +184,104 @@ func NewErrLog() (*os.File, error) {
 		return nil, err
 	}
 
-	logPath := filepath.Join(errLogDir, fmt.Sprintf("%s_err.log", time.Now().Format(_DATE_FMT)))
+	logPath := filepath.Join(errLogDir, fmt.Sprintf("%s%s_err.log", time.Now().Format(_DATE_FMT), suffix))
 	return os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 }
 
-func (c *Chat) router(ctx context.Context) {
+// Start or stop the chat logger.
+// Must only be called from the router.
+func (c *Chat) setLogger(on bool) error {
+	switch {
+	case on && c.logFeed == nil:
+		// A slow disk shouldn't hold up the rest of the client.
+		lf := c.Feeder.Feed(Named("logger"), Backpressure{Overflow: OverflowDropOldest})
+		cfg := c.Config()
+		if err := startLogger(lf.Feed, cfg.ProfileSuffix()); err != nil {
+			lf.Close()
+			return err
+		}
+		c.logFeed = &lf
+	case !on && c.logFeed != nil:
+		c.logFeed.Close()
+		c.logFeed = nil
+	}
+
+	return nil
+}
+
+// Returns an error if it can't start.
+func (c *Chat) router(ctx context.Context) error {
+	cfg := c.Config()
+	errFile, err := NewErrLog(cfg.ProfileSuffix())
+	if err != nil {
+		return fmt.Errorf("Failed to open error log: %w", err)
+	}
+	defer func() {
+		stat, err := errFile.Stat()
+		if err != nil {
+			// Ironic, ain't it?
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		errFile.Close()
+
+		if err == nil && stat.Size() == 0 {
+			os.Remove(errFile.Name())
+		}
+	}()
+
+	// Drained before anything else is set up, since that logs to the same small chans
+	// as the socket, which is already connecting.
+	drainCtx, stopDrain := context.WithCancel(ctx)
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for {
+			select {
+			case <-drainCtx.Done():
+				return
+			case dms := <-c.sock.debug:
+				c.ClientMsg(dms, true)
+			case ms := <-c.sock.infoLog:
+				c.ClientMsg(ms, false)
+			case err := <-c.sock.errLog:
+				fmt.Fprintln(errFile, err)
+			}
+		}
+	}()
+	// Stopped before errFile is closed.
+	defer func() {
+		stopDrain()
+		<-drained
+	}()
+
 	histFeed := make(chan *Message, HIST_LEN)
 	defer close(histFeed)
 	hist := c.recordHistory(histFeed)
 
-	if c.Cfg.Logger {
-		logFeed := c.Feeder.Feed()
-		err := startLogger(logFeed.Feed)
-		if err != nil {
-			panic(err)
-		}
+	if err := c.setLogger(cfg.Logger); err != nil {
+		return fmt.Errorf("Failed to start chat logger: %w", err)
+	}
+	if err := c.setMetrics(cfg); err != nil {
+		c.infoLog <- err.Error()
+		c.errLog <- err
 	}
+	defer c.setMetrics(config.Config{})
+	if err := c.setIRC(cfg); err != nil {
+		c.infoLog <- err.Error()
+		c.errLog <- err
+	}
+	defer c.setIRC(config.Config{})
 
 	var reply struct {
 		ID   uint32
 		Date int64
 	}
+	// Skips msgs from the history sent on join, and ones seen again after reconnecting.
+	userMsgs := struct {
+		ID    uint32
+		Since int64
+	}{Since: time.Now().Unix()}
 
 	msgHandler := func(msg *Message) {
 		if msg == nil {
@@ -168,52 +300,33 @@ func (c *Chat) router(ctx context.Context) {
 				reply.ID = msg.MessageID
 				reply.Date = date
 
-				beeep.Notify(fmt.Sprintf("Reply from @%s", msg.Author.Username), msg.MessageRaw, "")
+				c.notifier.notify(ctx, Notification{
+					Title:     fmt.Sprintf("Reply from @%s", msg.Author.Username),
+					Body:      msg.MessageRaw,
+					Author:    msg.Author.Username,
+					Room:      msg.RoomID,
+					MessageID: msg.MessageID,
+					Time:      time.Unix(date, 0),
+				})
+				c.hooks.fire(ctx, HookEvent{Event: HookMention, Room: uint(msg.RoomID), Message: msg})
 			}
 		}
 
-		c.Feeder.Send(msg)
-		histFeed <- msg
-	}
-
-	errFile, err := NewErrLog()
-	if err != nil {
-		panic(err)
-	}
-	defer func() {
-		stat, err := errFile.Stat()
-		if err != nil {
-			// Ironic, ain't it?
-			fmt.Fprintln(os.Stderr, err)
-		}
-
-		errFile.Close()
-
-		if stat.Size() == 0 {
-			os.Remove(errFile.Name())
+		if !msg.IsEdited() && msg.MessageDate >= userMsgs.Since && msg.MessageID > userMsgs.ID && c.hooks.watching(msg.Author) {
+			userMsgs.ID = msg.MessageID
+			c.hooks.fire(ctx, HookEvent{Event: HookUserMessage, Room: uint(msg.RoomID), Message: msg})
 		}
 
-	}()
+		c.sends.match(msg, c.Users.ClientID())
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case dms := <-c.sock.debug:
-				c.ClientMsg(dms, true)
-			case ms := <-c.sock.infoLog:
-				c.ClientMsg(ms, false)
-			case err := <-c.sock.errLog:
-				fmt.Fprintln(errFile, err)
-			}
-		}
-	}()
+		c.Feeder.Send(msg)
+		histFeed <- msg
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		// Not directly assigned to help sync with new msgs.
 		case hc := <-hist:
 			c.History <- hc
@@ -221,6 +334,10 @@ func (c *Chat) router(ctx context.Context) {
 			if msg != nil {
 				msgHandler(msg)
 			}
+		case ev := <-c.sock.events:
+			c.Feeder.SendEvent(ev)
+		case r := <-c.reloads:
+			c.applyReload(r)
 		}
 	}
 }
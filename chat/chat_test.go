@@ -0,0 +1,78 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+)
+
+// Setting up metrics logs to infoLog, which the socket may have filled up already.
+func TestRouterStartsWithFullLogs(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.NewConfig()
+	cfg.Metrics.Enabled = true
+	cfg.Metrics.Addr = "127.0.0.1:0"
+	c, err := NewChat(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(c.infoLog) < cap(c.infoLog) {
+		c.infoLog <- "Opening socket..."
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.router(ctx)
+	}()
+
+	// Reloads are only taken once the router is running.
+	rctx, rcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer rcancel()
+	c.UpdateConfig(rctx, cfg, []string{"room"})
+	if rctx.Err() != nil {
+		t.Fatal("router got stuck starting up")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A reload may replace and stop the proxy while NewIdentity uses it. Run with -race.
+func TestNewIdentityDuringReload(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := NewChat(ctx, config.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fake control port refuses NEWNYM, so NewIdentity doesn't go on to reconnect.
+	c.proxy = &proxyDialer{system: true, ctl: fakeTorControl(t, nil, false)}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.NewIdentity(ctx)
+	}()
+	if err := c.setTransport(ctx, config.NewConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err == nil {
+		t.Fatal("got no error from NEWNYM on a stopped or refusing control port")
+	}
+	if c.proxy != nil {
+		t.Fatal("reload without a proxy kept the old one")
+	}
+}
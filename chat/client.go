@@ -0,0 +1,303 @@
+// Package chat is a client for KF chat.
+//
+// Other programs can use it through Dial, which doesn't need the TUI:
+//
+//	cl, err := chat.Dial(ctx, chat.Options{Cookies: cookies, Room: 1})
+//	if err != nil {
+//		return err
+//	}
+//	defer cl.Close()
+//
+//	sub := cl.Subscribe(chat.NoClient())
+//	for msg := range sub.C {
+//		fmt.Println(msg.Author.Username, msg.MessageRaw)
+//	}
+package chat
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"y-a-t-s/sockchat/config"
+)
+
+// Settings for Dial. Zero values keep the defaults.
+type Options struct {
+	// Used as the base for the other fields if set. Otherwise, the defaults from config.NewConfig
+	// are used, minus anything that acts outside the program, like the chat logger,
+	// notifications and scripts.
+	Config *config.Config
+
+	Host string
+	Port uint
+	Room uint
+	// Your forum user ID, needed to confirm sent msgs. nil keeps the default, which is unset.
+	UserID  *int
+	Cookies string
+	// Proxy URL, like socks5://127.0.0.1:1080.
+	Proxy    string
+	Tor      bool
+	ReadOnly bool
+}
+
+func (opts Options) config() config.Config {
+	var cfg config.Config
+	if opts.Config != nil {
+		cfg = *opts.Config
+	} else {
+		cfg = config.NewConfig()
+		cfg.Logger = false
+		cfg.Notify.Enabled = false
+		cfg.Scripts.Enabled = false
+	}
+
+	if opts.Host != "" {
+		cfg.Host = opts.Host
+	}
+	if opts.Port != 0 {
+		cfg.Port = opts.Port
+	}
+	if opts.Room != 0 {
+		cfg.Room = opts.Room
+	}
+	if opts.UserID != nil {
+		cfg.UserID = *opts.UserID
+	}
+	if opts.Cookies != "" {
+		cfg.Cookies = opts.Cookies
+	}
+	if opts.Proxy != "" {
+		cfg.Proxy.Enabled = true
+		cfg.Proxy.Addr = opts.Proxy
+	}
+	if opts.Tor {
+		cfg.Tor.Enabled = true
+	}
+	if opts.ReadOnly {
+		cfg.ReadOnly = true
+	}
+
+	return cfg
+}
+
+// A connection to the chat for use by other programs.
+// Reconnects on its own until Close is called.
+type Client struct {
+	chat   *Chat
+	cancel context.CancelFunc
+	// Closed once the client has shut down.
+	done chan struct{}
+	// Why the client couldn't start, if it didn't. Only read once done is closed.
+	err error
+}
+
+// Connect to the chat with opts. ctx only limits how long connecting may take.
+// Failed attempts are retried until ctx is done, unless retrying can't help,
+// like when the server refuses the cookies or a TLS pin doesn't match.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	cfg := opts.config()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	cctx, cancel := context.WithCancel(context.Background())
+	c, err := NewChat(cctx, cfg)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	cl := &Client{
+		chat:   c,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(cl.done)
+		cl.err = c.Start(cctx)
+	}()
+
+	// Most recent reason an attempt failed.
+	var last error
+	for {
+		state, err, changed := c.state.get()
+		if err != nil {
+			last = err
+		}
+		switch {
+		case state == StateConnected:
+			return cl, nil
+		case state == StateStopped || state == StateClosed:
+			cl.Close()
+			return nil, cl.stopErr(last)
+		case err != nil && untrusted(err):
+			cl.Close()
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			cl.Close()
+			if last != nil {
+				return nil, errors.Join(ctx.Err(), last)
+			}
+			return nil, ctx.Err()
+		case <-cl.done:
+			return nil, cl.stopErr(last)
+		case <-changed:
+		}
+	}
+}
+
+// Error for a client that stopped before connecting. Waits for it to finish shutting down.
+func (cl *Client) stopErr(last error) error {
+	<-cl.done
+
+	switch {
+	case cl.err != nil:
+		return cl.err
+	case last != nil:
+		return last
+	default:
+		return errors.New("Client stopped before connecting.")
+	}
+}
+
+// Receives msgs from the chat, including ones from the client itself, like connection info.
+type Subscription struct {
+	C <-chan Message
+
+	feed feed
+}
+
+// Get msgs accepted by all of filters, or all msgs if there are none.
+// Msgs must be read from C promptly, since a full Subscription holds up the rest.
+// Use SubscribeWith to pick what happens instead.
+func (cl *Client) Subscribe(filters ...Filter) *Subscription {
+	return cl.SubscribeWith(filterOpts(filters)...)
+}
+
+// Like Subscribe, with options like Backpressure and Named along with filters.
+func (cl *Client) SubscribeWith(opts ...FeedOption) *Subscription {
+	mf := cl.chat.Feeder.Feed(opts...)
+	return &Subscription{
+		C:    mf.Feed,
+		feed: mf,
+	}
+}
+
+func filterOpts(filters []Filter) []FeedOption {
+	opts := make([]FeedOption, 0, len(filters))
+	for _, f := range filters {
+		// A nil filter accepts everything.
+		if f != nil {
+			opts = append(opts, f)
+		}
+	}
+
+	return opts
+}
+
+// Stop receiving msgs. C is closed soon after.
+func (sub *Subscription) Close() {
+	sub.feed.Close()
+}
+
+// Count of msgs dropped because they weren't read in time.
+func (sub *Subscription) Drops() uint64 {
+	return sub.feed.Drops()
+}
+
+// Receives events of the kinds subscribed to, in the order they happened.
+type EventSubscription struct {
+	C <-chan Event
+
+	feed feed
+}
+
+// Get events of the given kinds on a single chan. Msg events must also be accepted by all of filters.
+func (cl *Client) Events(kinds EventKind, filters ...Filter) *EventSubscription {
+	return cl.EventsWith(kinds, filterOpts(filters)...)
+}
+
+// Like Events, with options like Backpressure and Named along with filters.
+func (cl *Client) EventsWith(kinds EventKind, opts ...FeedOption) *EventSubscription {
+	mf := cl.chat.Feeder.Events(kinds, opts...)
+	return &EventSubscription{
+		C:    mf.Events,
+		feed: mf,
+	}
+}
+
+// Stop receiving events. C is closed soon after.
+func (sub *EventSubscription) Close() {
+	sub.feed.Close()
+}
+
+// Count of events dropped because they weren't read in time.
+func (sub *EventSubscription) Drops() uint64 {
+	return sub.feed.Drops()
+}
+
+// Get the stats of every open subscription, including the client's own.
+func (cl *Client) Feeds() []FeedStats {
+	return cl.chat.Feeder.Stats()
+}
+
+// Send text to the current room and wait for the server to echo it back.
+func (cl *Client) Send(ctx context.Context, text string) (SendResult, error) {
+	return cl.chat.Send(ctx, text)
+}
+
+// Switch to room. Msgs sent after this go to the new room.
+func (cl *Client) Join(ctx context.Context, room uint) error {
+	if room == 0 || room > math.MaxUint16 {
+		return fmt.Errorf("Invalid room ID: %d", room)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-cl.done:
+		return errors.New("Client is closed.")
+	case cl.chat.Out <- fmt.Sprintf("/join %d", room):
+		return nil
+	}
+}
+
+// Get the users seen so far, sorted by ID.
+func (cl *Client) Users() []User {
+	users := make([]User, 0)
+	cl.chat.Users.Range(func(_, v any) bool {
+		users = append(users, *v.(*User))
+		return true
+	})
+	slices.SortFunc(users, func(a, b User) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return users
+}
+
+// Get the connection state, along with the error that caused it, if any.
+func (cl *Client) State() (ConnState, error) {
+	state, err, _ := cl.chat.state.get()
+	return state, err
+}
+
+// Closed once the client has shut down.
+func (cl *Client) Done() <-chan struct{} {
+	return cl.done
+}
+
+// Disconnect and shut down. Msgs that weren't sent yet are saved to the outbox.
+func (cl *Client) Close() error {
+	cl.cancel()
+	<-cl.done
+
+	return nil
+}
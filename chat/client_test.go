@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsConfig(t *testing.T) {
+	zero, id := 0, 160024
+
+	tests := []struct {
+		name string
+		opts Options
+		want int
+	}{
+		{name: "unset", opts: Options{}, want: -1},
+		{name: "set", opts: Options{UserID: &id}, want: id},
+		// 0 is a valid value, not the same as leaving it out.
+		{name: "zero", opts: Options{UserID: &zero}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.config().UserID; got != tt.want {
+				t.Fatalf("got user_id %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// A failed attempt is retried until ctx is done instead of failing Dial right away.
+func TestDialRetriesUntilDeadline(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	// Nothing listens on port 1.
+	opts := Options{Host: "127.0.0.1", Port: 1, Cookies: "xf_user=1; xf_session=1"}
+
+	const timeout = 2 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	cl, err := Dial(ctx, opts)
+	if err == nil {
+		cl.Close()
+		t.Fatal("connected to nothing")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want it to give up at the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout-100*time.Millisecond {
+		t.Fatalf("gave up after %s, before the deadline", elapsed)
+	}
+}
+
+// Errors that keep the client from starting are returned instead of panicking.
+func TestDialStartError(t *testing.T) {
+	cfgHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", cfgHome)
+	t.Setenv("HOME", t.TempDir())
+
+	// A file where the error log dir should be.
+	if err := os.MkdirAll(filepath.Join(cfgHome, "sockchat"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cfgHome, "sockchat", "error_logs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cl, err := Dial(ctx, Options{Host: "127.0.0.1", Port: 1, Cookies: "xf_user=1; xf_session=1"})
+	if err == nil {
+		cl.Close()
+		t.Fatal("got no error")
+	}
+	if !strings.Contains(err.Error(), "Failed to open error log") {
+		t.Fatalf("got %v, want the error log failure", err)
+	}
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Client-side slash command added at runtime, such as by a script.
+type Command struct {
+	Usage string
+	// args is the raw text following the command name.
+	Run func(ctx context.Context, args string) error
+
+	// Whatever registered the command, like a script's file name.
+	Owner string
+}
+
+// Slash commands added by scripts and other code using the chat.
+// The UI checks its own commands first.
+type CommandTable struct {
+	mx   sync.Mutex
+	cmds map[string]Command
+}
+
+func newCommandTable() *CommandTable {
+	return &CommandTable{
+		cmds: make(map[string]Command),
+	}
+}
+
+// Add cmd as /name. Fails if name is already taken by a different owner.
+func (ct *CommandTable) Register(name string, cmd Command) error {
+	ct.mx.Lock()
+	defer ct.mx.Unlock()
+
+	if prev, ok := ct.cmds[name]; ok && prev.Owner != cmd.Owner {
+		return fmt.Errorf("Command /%s is already registered by %s.", name, prev.Owner)
+	}
+	ct.cmds[name] = cmd
+
+	return nil
+}
+
+func (ct *CommandTable) Lookup(name string) (Command, bool) {
+	ct.mx.Lock()
+	defer ct.mx.Unlock()
+
+	cmd, ok := ct.cmds[name]
+	return cmd, ok
+}
+
+// Remove every command registered by owner.
+func (ct *CommandTable) RemoveOwner(owner string) {
+	ct.mx.Lock()
+	defer ct.mx.Unlock()
+
+	for n, cmd := range ct.cmds {
+		if cmd.Owner == owner {
+			delete(ct.cmds, n)
+		}
+	}
+}
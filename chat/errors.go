@@ -1,9 +1,47 @@
 package chat
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 )
 
+// Describes how the client should react to an error sent by the server.
+type ErrPolicy uint8
+
+const (
+	// Reconnect after waiting a bit.
+	PolicyRetry ErrPolicy = iota
+	// Refresh session cookies, then reconnect.
+	PolicyRefresh
+	// Stop trying. Continuing will not help.
+	PolicyStop
+	// Let the user know. The connection itself is fine.
+	PolicyNotify
+)
+
+func (p ErrPolicy) String() string {
+	switch p {
+	case PolicyRetry:
+		return "retry"
+	case PolicyRefresh:
+		return "refresh"
+	case PolicyStop:
+		return "stop"
+	case PolicyNotify:
+		return "notify"
+	default:
+		return "unknown"
+	}
+}
+
+// Implemented by errors classified from plaintext server responses.
+type ServerError interface {
+	error
+	Policy() ErrPolicy
+}
+
 type ErrSessionExpired struct {
 	serverMsg string
 }
@@ -12,6 +50,139 @@ func (err *ErrSessionExpired) Error() string {
 	return err.serverMsg
 }
 
+func (err *ErrSessionExpired) Policy() ErrPolicy {
+	return PolicyRefresh
+}
+
+type ErrAuthFailed struct {
+	serverMsg string
+}
+
+func (err *ErrAuthFailed) Error() string {
+	return fmt.Sprintf("Authentication failed: %s", err.serverMsg)
+}
+
+func (err *ErrAuthFailed) Policy() ErrPolicy {
+	return PolicyStop
+}
+
+type ErrRateLimited struct {
+	serverMsg string
+}
+
+func (err *ErrRateLimited) Error() string {
+	return fmt.Sprintf("Rate limited by server: %s", err.serverMsg)
+}
+
+func (err *ErrRateLimited) Policy() ErrPolicy {
+	return PolicyRetry
+}
+
+type ErrBanned struct {
+	serverMsg string
+}
+
+func (err *ErrBanned) Error() string {
+	return fmt.Sprintf("Banned from chat: %s", err.serverMsg)
+}
+
+func (err *ErrBanned) Policy() ErrPolicy {
+	return PolicyStop
+}
+
+type ErrRoomNotFound struct {
+	serverMsg string
+}
+
+func (err *ErrRoomNotFound) Error() string {
+	return fmt.Sprintf("Room not found: %s", err.serverMsg)
+}
+
+func (err *ErrRoomNotFound) Policy() ErrPolicy {
+	return PolicyNotify
+}
+
+type ErrFlood struct {
+	serverMsg string
+}
+
+func (err *ErrFlood) Error() string {
+	return fmt.Sprintf("Flood detected: %s", err.serverMsg)
+}
+
+func (err *ErrFlood) Policy() ErrPolicy {
+	return PolicyNotify
+}
+
+// Forms of the errors the server sends, matched against the start of the msg.
+// Anchoring keeps info text that happens to mention a word like "banned" from being taken as an error.
+// Join failures are the exception. They're matched anywhere, like the client always did,
+// since the server may put something like "Error:" in front.
+// Order matters. Ban msgs may also say the user cannot join, for example,
+// and join failures may ask the user to log in, which a session refresh fixes.
+var serverErrForms = []struct {
+	re   *regexp.Regexp
+	wrap func(ms string) error
+}{
+	{regexp.MustCompile(`(?i)^(you (are|have been) banned|banned from)\b`), func(ms string) error { return &ErrBanned{ms} }},
+	{regexp.MustCompile(`(?i)cannot join`), func(ms string) error { return &ErrSessionExpired{ms} }},
+	{regexp.MustCompile(`(?i)^(flood (detected|control)|you are (flooding|sending (msgs|messages) too fast))\b`), func(ms string) error { return &ErrFlood{ms} }},
+	{regexp.MustCompile(`(?i)^((you are being )?rate limited|too many (requests|messages)|slow down)\b`), func(ms string) error { return &ErrRateLimited{ms} }},
+	{regexp.MustCompile(`(?i)^(room not found|no such room|room does not exist|invalid room)\b`), func(ms string) error { return &ErrRoomNotFound{ms} }},
+	{regexp.MustCompile(`(?i)^((you are )?not logged in|authentication (failed|required)|unauthori[sz]ed)\b`), func(ms string) error { return &ErrAuthFailed{ms} }},
+}
+
+// Map plaintext sent by the server to a ServerError.
+// Returns nil if the text doesn't look like a known error, in which case it's just info.
+func ClassifyServerMsg(ms string) error {
+	trimmed := strings.TrimSpace(ms)
+	for _, f := range serverErrForms {
+		if f.re.MatchString(trimmed) {
+			return f.wrap(ms)
+		}
+	}
+
+	return nil
+}
+
+// Get the policy of err if it wraps a ServerError.
+// ok is false for any other error.
+func PolicyOf(err error) (p ErrPolicy, ok bool) {
+	var se ServerError
+	if !errors.As(err, &se) {
+		return
+	}
+
+	return se.Policy(), true
+}
+
+type ErrSendTimeout struct {
+	text string
+}
+
+func (e *ErrSendTimeout) Error() string {
+	return fmt.Sprintf("No delivery confirmation for msg: %s", e.text)
+}
+
+// Returned by Send when the socket is down and the msg went to the outbox instead.
+type ErrQueuedOffline struct {
+	text string
+}
+
+func (e *ErrQueuedOffline) Error() string {
+	return fmt.Sprintf("Not connected. Msg saved to outbox: %s", e.text)
+}
+
+// Returned by Send when the msg was sent, but its echo can't be recognized.
+// Happens when user_id isn't set.
+type ErrUnconfirmed struct {
+	text string
+}
+
+func (e *ErrUnconfirmed) Error() string {
+	return fmt.Sprintf("Msg sent, but can't be confirmed without user_id set: %s", e.text)
+}
+
 type errSocketClosed struct {
 	sock *sock
 }
@@ -35,3 +206,39 @@ type errReadTimedOut struct {
 func (e *errReadTimedOut) Error() string {
 	return fmt.Sprintf("Msg read timed out in room %d.", e.room)
 }
+
+// The onion address in the config differs from the one pinned for the host.
+type ErrOnionChanged struct {
+	Host   string
+	Pinned string
+	Onion  string
+}
+
+func (e *ErrOnionChanged) Error() string {
+	return fmt.Sprintf("Onion address for %s changed from %s to %s. "+
+		"Refusing to connect, since config.json may have been tampered with. "+
+		"If you changed it yourself, use /trustonion to accept it.", e.Host, e.Pinned, e.Onion)
+}
+
+// None of the certs in the chain matched tls.pins. The connection may be intercepted.
+type ErrPinMismatch struct {
+	Host string
+	// SPKI hashes of the certs that were presented.
+	Got []string
+}
+
+func (e *ErrPinMismatch) Error() string {
+	return fmt.Sprintf("TLS cert for %s doesn't match any of the pins in tls.pins. "+
+		"Refusing to connect, since the connection may be intercepted. "+
+		"The server presented keys: %s.", e.Host, strings.Join(e.Got, ", "))
+}
+
+// Errors that mean the host can't be trusted. Retrying won't help until the user does something about them.
+func untrusted(err error) bool {
+	var (
+		oc *ErrOnionChanged
+		pm *ErrPinMismatch
+	)
+
+	return errors.As(err, &oc) || errors.As(err, &pm)
+}
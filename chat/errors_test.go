@@ -0,0 +1,130 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"y-a-t-s/sockchat/config"
+)
+
+func TestClassifyServerMsg(t *testing.T) {
+	tests := []struct {
+		ms     string
+		want   error
+		policy ErrPolicy
+	}{
+		// Errors.
+		{"You cannot join this room.", &ErrSessionExpired{}, PolicyRefresh},
+		{"Cannot join room. Please log in again.", &ErrSessionExpired{}, PolicyRefresh},
+		{"  cannot join this room", &ErrSessionExpired{}, PolicyRefresh},
+		{"You are banned from this room.", &ErrBanned{}, PolicyStop},
+		{"You have been banned. You cannot join.", &ErrBanned{}, PolicyStop},
+		{"Banned from chat until tomorrow.", &ErrBanned{}, PolicyStop},
+		{"Flood detected. Wait a moment.", &ErrFlood{}, PolicyNotify},
+		{"You are sending messages too fast.", &ErrFlood{}, PolicyNotify},
+		{"Rate limited. Try again later.", &ErrRateLimited{}, PolicyRetry},
+		{"Too many requests", &ErrRateLimited{}, PolicyRetry},
+		{"Slow down!", &ErrRateLimited{}, PolicyRetry},
+		{"Room not found.", &ErrRoomNotFound{}, PolicyNotify},
+		{"Invalid room ID", &ErrRoomNotFound{}, PolicyNotify},
+		{"You are not logged in.", &ErrAuthFailed{}, PolicyStop},
+		{"Authentication failed", &ErrAuthFailed{}, PolicyStop},
+		{"Unauthorized", &ErrAuthFailed{}, PolicyStop},
+
+		// Info text that only mentions the same words.
+		{"Welcome! Please don't spam or you'll be banned.", nil, 0},
+		{"Too many cooks lol", nil, 0},
+		{"Remember to log in to see old msgs.", nil, 0},
+		{"Someone was banned for flooding the room.", nil, 0},
+		{"Slow downs are expected tonight.", nil, 0},
+		{"", nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ms, func(t *testing.T) {
+			err := ClassifyServerMsg(tt.ms)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("got %T, want nil", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("got nil, want %T", tt.want)
+			}
+			if got, want := fmt.Sprintf("%T", err), fmt.Sprintf("%T", tt.want); got != want {
+				t.Fatalf("got %s, want %s", got, want)
+			}
+			if p, ok := PolicyOf(err); !ok || p != tt.policy {
+				t.Errorf("policy = %s, %v, want %s", p, ok, tt.policy)
+			}
+		})
+	}
+}
+
+// Every frame the old client refreshed the session for, matched with strings.Contains(ms, "cannot join"),
+// must still be a session error, other than ban msgs.
+func TestClassifyServerMsgCannotJoin(t *testing.T) {
+	tests := []struct {
+		ms   string
+		want error
+	}{
+		{"cannot join", &ErrSessionExpired{}},
+		{"You cannot join this room.", &ErrSessionExpired{}},
+		{"Error: You cannot join this room", &ErrSessionExpired{}},
+		{"[error] cannot join room 1", &ErrSessionExpired{}},
+		{"Guests cannot join this chat. Please log in.", &ErrSessionExpired{}},
+		{"You Cannot Join this room.", &ErrSessionExpired{}},
+		{"You have been banned. You cannot join.", &ErrBanned{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ms, func(t *testing.T) {
+			if !strings.Contains(strings.ToLower(tt.ms), "cannot join") {
+				t.Fatal("not a frame the old client matched")
+			}
+			err := ClassifyServerMsg(tt.ms)
+			if got, want := fmt.Sprintf("%T", err), fmt.Sprintf("%T", tt.want); got != want {
+				t.Fatalf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestPolicyOfWrapped(t *testing.T) {
+	err := errors.Join(errors.New("context"), &ErrBanned{"x"})
+	if p, ok := PolicyOf(err); !ok || p != PolicyStop {
+		t.Errorf("got %s, %v", p, ok)
+	}
+	if _, ok := PolicyOf(errors.New("plain")); ok {
+		t.Error("plain error has a policy")
+	}
+}
+
+func TestUntrusted(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"onion changed", &ErrOnionChanged{"kiwifarms.net", "a.onion", "b.onion"}, true},
+		{"pin mismatch", &ErrPinMismatch{Host: "kiwifarms.net"}, true},
+		{"wrapped", fmt.Errorf("Failed to connect: %w", &ErrPinMismatch{Host: "kiwifarms.net"}), true},
+		// A typo in the config is fixed by a reload, so it mustn't stop reconnects.
+		{"config value", &config.ErrConfigValue{Key: "tor.onion_host", Value: "x.onion", Reason: "not a v3 onion address."}, false},
+		{"server error", &ErrBanned{"You are banned."}, false},
+		{"plain", errors.New("connection refused"), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := untrusted(tt.err); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
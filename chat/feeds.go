@@ -2,114 +2,396 @@ package chat
 
 import (
 	"context"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func newFeedChan() chan *Message {
 	return make(chan *Message, HIST_LEN)
 }
 
+// Kinds of Event. Combine them to subscribe to more than 1.
+type EventKind uint8
+
+const (
+	EventMessage EventKind = 1 << iota
+	// A user record was received, such as when someone joins the room.
+	EventUser
+	// The connection state changed.
+	EventConn
+
+	EventAll = EventMessage | EventUser | EventConn
+)
+
+// Something that happened in the chat. Only the fields for Kind are set.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+
+	Message Message
+	User    User
+	State   ConnState
+	// What caused the state change, if anything.
+	Err error
+}
+
+// What the feeder does when a feed's buffer is full.
+type Overflow uint8
+
+const (
+	// Wait for the subscriber. Holds up every other feed until it catches up.
+	OverflowBlock Overflow = iota
+	// Make room by dropping the oldest msg in the buffer.
+	OverflowDropOldest
+	// Drop the msg being sent.
+	OverflowDropNewest
+	// Drop the msg being sent, and close the feed once too many have been dropped.
+	OverflowDisconnect
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDisconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// Changes how a feed is set up. Filters are options too.
+type FeedOption interface {
+	applyFeed(mf *feed)
+}
+
+func (f Filter) applyFeed(mf *feed) {
+	mf.filters = append(mf.filters, f)
+}
+
+// Sets how the feeder handles a subscriber that falls behind. Feeds block by default.
+type Backpressure struct {
+	Overflow Overflow
+	// With OverflowDisconnect, how many msgs may be dropped before the feed is closed.
+	MaxDrops uint64
+}
+
+func (bp Backpressure) applyFeed(mf *feed) {
+	mf.bp = bp
+}
+
+type feedName string
+
+func (n feedName) applyFeed(mf *feed) {
+	mf.name = string(n)
+}
+
+// Name the feed in FeedStats.
+func Named(name string) FeedOption {
+	return feedName(name)
+}
+
+// How a feed is keeping up.
+type FeedStats struct {
+	Name     string
+	Overflow Overflow
+	// Msgs and events waiting to be read.
+	Queued int
+	Cap    int
+	Drops  uint64
+}
+
 type feed struct {
 	// Signals closed feed. Similar to ctx.Done()
-	closed chan struct{}
+	closed    chan struct{}
+	closeOnce *sync.Once
+	// Tells the feeder to drop the feed. Only the feeder closes Feed,
+	// since it's the one sending on it.
+	drop chan<- feed
+	// Closed once the feeder stops.
+	stopped <-chan struct{}
 
+	name    string
+	filters []Filter
+	// All of filters. Applies to msgs only.
+	filter Filter
+	kinds  EventKind
+	bp     Backpressure
+	drops  *atomic.Uint64
+
+	// Set for feeds from Feeder.Feed.
 	Feed chan Message
+	// Set for feeds from Feeder.Events.
+	Events chan Event
 }
 
-func newFeed() feed {
-	return feed{
-		Feed:   make(chan Message, HIST_LEN),
-		closed: make(chan struct{}, 1),
+func (mf *feed) wants(kind EventKind, msg *Message) bool {
+	if mf.kinds&kind == 0 {
+		return false
 	}
+
+	return msg == nil || mf.filter == nil || mf.filter(msg)
 }
 
-func (mc *feed) send(msg *Message) {
+// Send v on ch following the feed's backpressure settings.
+// Returns false if the feed should be disconnected.
+func feedSend[T any](mf *feed, ch chan T, v T) bool {
 	select {
-	case <-mc.closed:
+	case <-mf.closed:
+		return true
 	default:
-		// Not defined as a case to make sure mc.closed is checked first.
-		mc.Feed <- *msg
 	}
+
+	switch mf.bp.Overflow {
+	case OverflowDropOldest:
+		for {
+			select {
+			case ch <- v:
+				return true
+			default:
+			}
+			// The subscriber may have caught up in the meantime, so this can come up empty.
+			select {
+			case <-ch:
+				mf.drops.Add(1)
+			default:
+			}
+		}
+	case OverflowDropNewest, OverflowDisconnect:
+		select {
+		case ch <- v:
+			return true
+		default:
+			n := mf.drops.Add(1)
+			return mf.bp.Overflow != OverflowDisconnect || n <= mf.bp.MaxDrops
+		}
+	default:
+		select {
+		case <-mf.closed:
+		case ch <- v:
+		}
+		return true
+	}
+}
+
+func (mc *feed) send(msg *Message) bool {
+	if mc.Events != nil {
+		return mc.sendEvent(Event{Kind: EventMessage, Time: time.Now(), Message: *msg})
+	}
+
+	return feedSend(mc, mc.Feed, *msg)
 }
 
+func (mc *feed) sendEvent(ev Event) bool {
+	return feedSend(mc, mc.Events, ev)
+}
+
+// Count of msgs and events dropped because the subscriber fell behind.
+func (mf *feed) Drops() uint64 {
+	return mf.drops.Load()
+}
+
+func (mf *feed) stats() FeedStats {
+	st := FeedStats{
+		Name:     mf.name,
+		Overflow: mf.bp.Overflow,
+		Drops:    mf.drops.Load(),
+	}
+	if mf.Events != nil {
+		st.Queued, st.Cap = len(mf.Events), cap(mf.Events)
+	} else {
+		st.Queued, st.Cap = len(mf.Feed), cap(mf.Feed)
+	}
+
+	return st
+}
+
+// Stop receiving msgs. Feed is closed soon after.
 func (mf *feed) Close() {
-	select {
-	case <-mf.closed:
-		return
-	default:
+	first := false
+	mf.closeOnce.Do(func() {
 		close(mf.closed)
+		first = true
+	})
+	if !first {
+		return
+	}
+
+	select {
+	case <-mf.stopped:
+	case mf.drop <- *mf:
+	}
+}
+
+// Closes whichever chan the feed was made with.
+func (mf *feed) closeChan() {
+	if mf.Events != nil {
+		close(mf.Events)
+	} else {
 		close(mf.Feed)
 	}
 }
 
 type feeder struct {
-	in chan *Message
+	in     chan *Message
+	events chan Event
 
-	Feed func() feed
+	// Get msgs accepted by all filters in opts.
+	Feed func(opts ...FeedOption) feed
+	// Get events of the given kinds on a single chan.
+	// Msg events must also be accepted by all filters in opts.
+	Events func(kinds EventKind, opts ...FeedOption) feed
+	// Get the stats of every open feed.
+	Stats func() []FeedStats
+	// Get the room of the last chat msg passed on. False if there wasn't one yet.
+	Room func() (uint16, bool)
 }
 
 func newFeeder(ctx context.Context) feeder {
 	feeds := make([]feed, 0, 4)
 	newFeeds := make(chan feed)
-	closed := make(chan feed, 4)
+	drop := make(chan feed, 4)
+
+	// Copy of feeds for Stats, so it doesn't wait on the feeder,
+	// which may be stuck on a blocked subscriber.
+	var liveMx sync.Mutex
+	var live []feed
+	// Room of the last chat msg plus 1, so 0 means none yet.
+	var room atomic.Uint32
+	publish := func() {
+		liveMx.Lock()
+		defer liveMx.Unlock()
+
+		live = slices.Clone(feeds)
+	}
+
+	add := func(mf feed, opts []FeedOption) feed {
+		mf.closed = make(chan struct{})
+		mf.closeOnce = &sync.Once{}
+		mf.drop = drop
+		mf.stopped = ctx.Done()
+		mf.drops = &atomic.Uint64{}
+		for _, o := range opts {
+			o.applyFeed(&mf)
+		}
+		if len(mf.filters) > 0 {
+			mf.filter = All(mf.filters...)
+		}
+
+		select {
+		case <-ctx.Done():
+			// Nothing left to feed it.
+			mf.closeOnce.Do(func() {
+				close(mf.closed)
+			})
+			mf.closeChan()
+		case newFeeds <- mf:
+		}
+
+		return mf
+	}
 
 	fdr := feeder{
-		in: newFeedChan(),
-		Feed: func() feed {
-			mf := newFeed()
+		in:     newFeedChan(),
+		events: make(chan Event, HIST_LEN),
+		Feed: func(opts ...FeedOption) feed {
+			return add(feed{
+				kinds: EventMessage,
+				Feed:  make(chan Message, HIST_LEN),
+			}, opts)
+		},
+		Events: func(kinds EventKind, opts ...FeedOption) feed {
+			return add(feed{
+				kinds:  kinds,
+				Events: make(chan Event, HIST_LEN),
+			}, opts)
+		},
+		Stats: func() []FeedStats {
+			liveMx.Lock()
+			defer liveMx.Unlock()
 
-			// See if a previously closed feed can be replaced in the slice.
-			select {
-			case cmf := <-closed:
-				// chans are passed by ref, so this is probably ok.
-				cmf.Feed = mf.Feed
-				cmf.closed = mf.closed
-			default:
-				newFeeds <- mf
+			stats := make([]FeedStats, len(live))
+			for i := range live {
+				stats[i] = live[i].stats()
 			}
-
-			return mf
+			return stats
+		},
+		Room: func() (uint16, bool) {
+			r := room.Load()
+			return uint16(r - 1), r != 0
 		},
 	}
 
-	broadcast := func(msg *Message) {
-		if msg == nil {
-			return
+	// Take mf out of the list and close its chan, unless that was already done.
+	remove := func(mf feed) {
+		n := len(feeds)
+		feeds = slices.DeleteFunc(feeds, func(f feed) bool {
+			return f.closed == mf.closed
+		})
+		if len(feeds) < n {
+			mf.closeOnce.Do(func() {
+				close(mf.closed)
+			})
+			mf.closeChan()
+			publish()
 		}
+	}
 
-		for _, mf := range feeds {
-			select {
-			case <-mf.closed:
-				select {
-				case closed <- mf:
-				default:
-				}
-			default:
-				mf.send(msg)
+	// Send to every feed send says it wants to, then drop any that fell too far behind.
+	broadcast := func(send func(mf *feed) bool) {
+		var lagging []feed
+		for i := range feeds {
+			if !send(&feeds[i]) {
+				lagging = append(lagging, feeds[i])
 			}
 		}
+		for _, mf := range lagging {
+			remove(mf)
+		}
 	}
 
-	closeAll := sync.OnceFunc(func() {
+	closeAll := func() {
 		for _, mf := range feeds {
-			mf.Close()
+			mf.closeChan()
 		}
-	})
+		feeds = nil
+		publish()
+	}
 
 	go func() {
 		defer closeAll()
 		for {
 			select {
 			case <-ctx.Done():
-				closeAll()
 				return
 			case mf := <-newFeeds:
 				feeds = append(feeds, mf)
+				publish()
+			case mf := <-drop:
+				remove(mf)
 			case msg, ok := <-fdr.in:
 				if !ok {
 					return
 				}
-				broadcast(msg)
+				if msg == nil {
+					continue
+				}
+				if msg.Author != nil && msg.Author.ID != 0 && !msg.debug {
+					room.Store(uint32(msg.RoomID) + 1)
+				}
+				broadcast(func(mf *feed) bool {
+					return !mf.wants(EventMessage, msg) || mf.send(msg)
+				})
+			case ev := <-fdr.events:
+				broadcast(func(mf *feed) bool {
+					return mf.Events == nil || !mf.wants(ev.Kind, nil) || mf.sendEvent(ev)
+				})
 			}
 		}
 	}()
@@ -120,3 +402,11 @@ func newFeeder(ctx context.Context) feeder {
 func (fdr *feeder) Send(msg *Message) {
 	fdr.in <- msg
 }
+
+// Pass a user or connection event on to feeds that want it.
+func (fdr *feeder) SendEvent(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	fdr.events <- ev
+}
@@ -0,0 +1,145 @@
+package chat
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFeedSendOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		bp   Backpressure
+		// Results of sending 1 to 5 on a chan with room for 2.
+		wantOK    []bool
+		wantQueue []int
+		wantDrops uint64
+	}{
+		{
+			name:      "drop oldest",
+			bp:        Backpressure{Overflow: OverflowDropOldest},
+			wantOK:    []bool{true, true, true, true, true},
+			wantQueue: []int{4, 5},
+			wantDrops: 3,
+		},
+		{
+			name:      "drop newest",
+			bp:        Backpressure{Overflow: OverflowDropNewest},
+			wantOK:    []bool{true, true, true, true, true},
+			wantQueue: []int{1, 2},
+			wantDrops: 3,
+		},
+		{
+			name:      "disconnect",
+			bp:        Backpressure{Overflow: OverflowDisconnect, MaxDrops: 1},
+			wantOK:    []bool{true, true, true, false, false},
+			wantQueue: []int{1, 2},
+			wantDrops: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mf := &feed{closed: make(chan struct{}), drops: &atomic.Uint64{}, bp: tt.bp}
+			ch := make(chan int, 2)
+
+			var ok []bool
+			for v := 1; v <= 5; v++ {
+				ok = append(ok, feedSend(mf, ch, v))
+			}
+			close(ch)
+			var queue []int
+			for v := range ch {
+				queue = append(queue, v)
+			}
+
+			if !slices.Equal(ok, tt.wantOK) {
+				t.Errorf("got results %v, want %v", ok, tt.wantOK)
+			}
+			if !slices.Equal(queue, tt.wantQueue) {
+				t.Errorf("got queue %v, want %v", queue, tt.wantQueue)
+			}
+			if d := mf.Drops(); d != tt.wantDrops {
+				t.Errorf("got %d drops, want %d", d, tt.wantDrops)
+			}
+		})
+	}
+}
+
+// Blocking feeds wait for the subscriber, but not once they're closed.
+func TestFeedSendBlock(t *testing.T) {
+	mf := &feed{closed: make(chan struct{}), drops: &atomic.Uint64{}}
+	ch := make(chan int, 1)
+	feedSend(mf, ch, 1)
+
+	done := make(chan bool, 1)
+	go func() { done <- feedSend(mf, ch, 2) }()
+
+	select {
+	case <-done:
+		t.Fatal("didn't wait for the subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(mf.closed)
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("closed feed asked to be disconnected")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still blocked after the feed was closed")
+	}
+	if d := mf.Drops(); d != 0 {
+		t.Fatalf("got %d drops from a blocking feed", d)
+	}
+}
+
+func TestFilterOpts(t *testing.T) {
+	mf := &feed{}
+	for _, o := range filterOpts([]Filter{nil, Rooms(1), nil}) {
+		o.applyFeed(mf)
+	}
+	if len(mf.filters) != 1 {
+		t.Fatalf("got %d filters, want nil ones left out", len(mf.filters))
+	}
+}
+
+// The room is only taken from chat msgs, since client and debug msgs don't have one.
+func TestFeederRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fdr := newFeeder(ctx)
+
+	if _, ok := fdr.Room(); ok {
+		t.Fatal("got a room before any msgs")
+	}
+
+	tests := []struct {
+		name string
+		msg  *Message
+		want uint16
+	}{
+		{"chat msg", &Message{Author: &User{ID: 7}, RoomID: 2}, 2},
+		{"client msg", &Message{Author: &User{ID: 0}, RoomID: 0}, 2},
+		{"no author", &Message{RoomID: 5}, 2},
+		{"debug msg", &Message{Author: &User{ID: 7}, RoomID: 5, debug: true}, 2},
+		{"moved room", &Message{Author: &User{ID: 8}, RoomID: 3}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Feeds get msgs after the room is stored.
+			mf := fdr.Feed()
+			defer mf.Close()
+			fdr.Send(tt.msg)
+			<-mf.Feed
+
+			if got, ok := fdr.Room(); !ok || got != tt.want {
+				t.Fatalf("got %d, %v, want %d", got, ok, tt.want)
+			}
+		})
+	}
+}
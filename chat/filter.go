@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"regexp"
+	"slices"
+)
+
+// Tells whether a subscriber wants msg. Checked by the feeder for every msg,
+// so filters need to be quick and must not modify msg.
+type Filter func(msg *Message) bool
+
+// Msgs in any of the rooms.
+func Rooms(ids ...uint16) Filter {
+	return func(msg *Message) bool {
+		return slices.Contains(ids, msg.RoomID)
+	}
+}
+
+// Msgs from any of the users.
+func Authors(ids ...uint32) Filter {
+	return func(msg *Message) bool {
+		return msg.Author != nil && slices.Contains(ids, msg.Author.ID)
+	}
+}
+
+// Msgs that mention the client's user.
+func Mentions() Filter {
+	return func(msg *Message) bool {
+		return msg.IsMention
+	}
+}
+
+// Msgs with raw text matching re.
+func Match(re *regexp.Regexp) Filter {
+	return func(msg *Message) bool {
+		return re.MatchString(msg.MessageRaw)
+	}
+}
+
+// Msgs from the chat, leaving out the client's own info and debug msgs.
+func NoClient() Filter {
+	return func(msg *Message) bool {
+		return msg.Author != nil && msg.Author.ID != 0
+	}
+}
+
+// Everything but debug msgs.
+func NoDebug() Filter {
+	return func(msg *Message) bool {
+		return !msg.debug
+	}
+}
+
+// Msgs accepted by all of filters. Accepts everything if there are none.
+func All(filters ...Filter) Filter {
+	return func(msg *Message) bool {
+		for _, f := range filters {
+			if f != nil && !f(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Msgs accepted by any of filters. Accepts nothing if there are none.
+func Any(filters ...Filter) Filter {
+	return func(msg *Message) bool {
+		for _, f := range filters {
+			if f != nil && f(msg) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func Not(f Filter) Filter {
+	return func(msg *Message) bool {
+		return !f(msg)
+	}
+}
@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestFilters(t *testing.T) {
+	msg := &Message{
+		Author:     &User{ID: 7, Username: "bob"},
+		RoomID:     2,
+		MessageRaw: "hello @alice",
+		IsMention:  true,
+	}
+	client := &Message{MessageRaw: "Connected."}
+	debug := &Message{MessageRaw: "Dropped duplicate msg", debug: true}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		msg    *Message
+		want   bool
+	}{
+		{"rooms match", Rooms(1, 2), msg, true},
+		{"rooms miss", Rooms(1, 3), msg, false},
+		{"rooms none", Rooms(), msg, false},
+		{"authors match", Authors(7), msg, true},
+		{"authors miss", Authors(8), msg, false},
+		{"authors client msg", Authors(0), client, false},
+		{"mentions", Mentions(), msg, true},
+		{"mentions miss", Mentions(), client, false},
+		{"match", Match(regexp.MustCompile(`^hello`)), msg, true},
+		{"match miss", Match(regexp.MustCompile(`^bye`)), msg, false},
+		{"no client on chat msg", NoClient(), msg, true},
+		{"no client on client msg", NoClient(), client, false},
+		{"no debug on debug msg", NoDebug(), debug, false},
+		{"no debug on chat msg", NoDebug(), msg, true},
+		{"all empty", All(), msg, true},
+		{"all", All(Rooms(2), Authors(7)), msg, true},
+		{"all one miss", All(Rooms(2), Authors(8)), msg, false},
+		{"all skips nil", All(nil, Rooms(2)), msg, true},
+		{"any empty", Any(), msg, false},
+		{"any", Any(Rooms(3), Authors(7)), msg, true},
+		{"any all miss", Any(Rooms(3), Authors(8)), msg, false},
+		{"any skips nil", Any(nil, Rooms(2)), msg, true},
+		{"not", Not(Rooms(2)), msg, false},
+		{"not miss", Not(Rooms(3)), msg, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter(tt.msg); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Events of kinds a feed didn't ask for are skipped, and filters only apply to msgs.
+func TestFeedWants(t *testing.T) {
+	mf := &feed{kinds: EventMessage | EventConn, filter: Rooms(2)}
+
+	tests := []struct {
+		name string
+		kind EventKind
+		msg  *Message
+		want bool
+	}{
+		{"msg in room", EventMessage, &Message{RoomID: 2}, true},
+		{"msg in other room", EventMessage, &Message{RoomID: 3}, false},
+		{"conn event", EventConn, nil, true},
+		{"user event", EventUser, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mf.wants(tt.kind, tt.msg); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package chat
+
+import "net/http"
+
+// Adds the configured headers to every request, so the HTTP client
+// looks like the same browser as the socket handshake.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the request they're given.
+	req = req.Clone(req.Context())
+	for k, v := range t.header {
+		// Leave headers set for this request alone.
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = v
+		}
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,145 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+)
+
+// Records the request it gets.
+type recordTransport struct {
+	req *http.Request
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.req = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestHeaderTransport(t *testing.T) {
+	header := http.Header{
+		"User-Agent":      {"test/1.0"},
+		"Accept-Language": {"en-GB"},
+	}
+
+	tests := []struct {
+		name string
+		// Headers set on the request itself.
+		reqHeader http.Header
+		want      map[string]string
+	}{
+		{
+			name: "adds headers",
+			want: map[string]string{"User-Agent": "test/1.0", "Accept-Language": "en-GB"},
+		},
+		{
+			name:      "keeps request headers",
+			reqHeader: http.Header{"User-Agent": {"other/2.0"}, "X-Requested-With": {"XMLHttpRequest"}},
+			want:      map[string]string{"User-Agent": "other/2.0", "Accept-Language": "en-GB", "X-Requested-With": "XMLHttpRequest"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recordTransport{}
+			ht := &headerTransport{rec, header}
+
+			req, err := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.reqHeader {
+				req.Header[k] = v
+			}
+			before := req.Header.Clone()
+
+			if _, err = ht.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.want {
+				if got := rec.req.Header.Values(k); len(got) != 1 || got[0] != v {
+					t.Errorf("%s: got %q, want %q", k, got, v)
+				}
+			}
+			if len(req.Header) != len(before) {
+				t.Fatalf("caller's request was changed: %v", req.Header)
+			}
+		})
+	}
+}
+
+// The client libkiwi gets must send the configured headers along with its cookies.
+func TestKFClientHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		browser string
+		ua      string
+		headers []string
+		wantUA  string
+		wantAL  string
+	}{
+		{name: "preset", browser: "chrome", wantAL: "en-US,en;q=0.9"},
+		{name: "user agent", browser: "firefox", ua: "test/1.0", wantUA: "test/1.0", wantAL: "en-US,en;q=0.5"},
+		{name: "headers", browser: "tor-browser", headers: []string{"Accept-Language: en-GB"}, wantAL: "en-GB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(chan http.Header, 1)
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got <- r.Header.Clone()
+			}))
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			port, _ := strconv.Atoi(u.Port())
+
+			cfg := config.NewConfig()
+			cfg.Host = u.Hostname()
+			cfg.Port = uint(port)
+			cfg.Cookies = "xf_user=1"
+			cfg.TLS.CAFile = writePEM(t, srv.Certificate())
+			cfg.HTTP.Browser = tt.browser
+			cfg.HTTP.UserAgent = tt.ua
+			cfg.HTTP.Headers = tt.headers
+
+			s := &sock{}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err = s.buildTransport(ctx, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := s.kf.GetPage(ctx, u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			h := <-got
+			want, _ := cfg.HTTPHeaders()
+			wantUA := tt.wantUA
+			if wantUA == "" {
+				wantUA = want.Get("User-Agent")
+			}
+			if ua := h.Get("User-Agent"); ua != wantUA || ua == "" {
+				t.Errorf("got User-Agent %q, want %q", ua, wantUA)
+			}
+			if al := h.Get("Accept-Language"); al != tt.wantAL {
+				t.Errorf("got Accept-Language %q, want %q", al, tt.wantAL)
+			}
+			if c := h.Get("Cookie"); c != "xf_user=1" {
+				t.Errorf("got Cookie %q, want the configured cookies", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+)
+
+// Hook output past this many bytes is cut off in the client log.
+const _HOOK_OUTPUT_MAX = 2048
+
+// Env for commands run by the client, with extra added.
+// SOCKCHAT_* vars are left out, since they may hold the cookies, proxy password or secrets passphrase.
+func childEnv(extra ...string) []string {
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, config.ENV_PREFIX)
+	})
+
+	return append(env, extra...)
+}
+
+// Collects output from commands, keeping only the first max bytes.
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+	cut bool
+}
+
+func (cb *cappedBuffer) Write(p []byte) (int, error) {
+	if room := cb.max - cb.Len(); len(p) > room {
+		cb.cut = true
+		cb.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+
+	return cb.Buffer.Write(p)
+}
+
+// The output, with "..." added if some was cut off.
+func (cb *cappedBuffer) String() string {
+	out := strings.TrimSpace(cb.Buffer.String())
+	if cb.cut {
+		out += "..."
+	}
+
+	return out
+}
+
+// Names of the events hooks can run on.
+const (
+	HookMention     = "mention"
+	HookUserMessage = "user_message"
+	HookConnect     = "connect"
+	HookDisconnect  = "disconnect"
+	HookRefresh     = "refresh"
+)
+
+// Passed to hook commands as JSON on stdin.
+type HookEvent struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Profile string    `json:"profile"`
+	Room    uint      `json:"room"`
+	// Set for msg events.
+	Message *Message `json:"message,omitempty"`
+	// Set if the event was caused by an error, like a dropped connection.
+	Error string `json:"error,omitempty"`
+}
+
+// Env vars for the common fields of ev, so simple hooks don't need to parse JSON.
+func (ev *HookEvent) env() []string {
+	env := []string{
+		"SOCKCHAT_EVENT=" + ev.Event,
+		"SOCKCHAT_EVENT_PROFILE=" + ev.Profile,
+		"SOCKCHAT_EVENT_ROOM=" + strconv.FormatUint(uint64(ev.Room), 10),
+	}
+	if ev.Error != "" {
+		env = append(env, "SOCKCHAT_EVENT_ERROR="+ev.Error)
+	}
+	if m := ev.Message; m != nil {
+		env = append(env,
+			"SOCKCHAT_EVENT_MESSAGE_ID="+strconv.FormatUint(uint64(m.MessageID), 10),
+			"SOCKCHAT_EVENT_MESSAGE="+m.MessageRaw,
+		)
+		if m.Author != nil {
+			env = append(env,
+				"SOCKCHAT_EVENT_AUTHOR="+m.Author.Username,
+				"SOCKCHAT_EVENT_AUTHOR_ID="+strconv.FormatUint(uint64(m.Author.ID), 10),
+			)
+		}
+	}
+
+	return env
+}
+
+// Runs the commands set in the hooks section of the config.
+type hookRunner struct {
+	mx      sync.Mutex
+	cfg     config.Config
+	running uint
+
+	infoLog chan<- string
+}
+
+func newHookRunner(cfg config.Config, infoLog chan<- string) *hookRunner {
+	return &hookRunner{
+		cfg:     cfg,
+		infoLog: infoLog,
+	}
+}
+
+// Switch to the hooks in cfg, such as after a config reload.
+// Hooks that are already running are left alone.
+func (hr *hookRunner) configure(cfg config.Config) {
+	hr.mx.Lock()
+	defer hr.mx.Unlock()
+
+	hr.cfg = cfg
+}
+
+// Must be called with hr.mx held.
+func (hr *hookRunner) command(event string) []string {
+	h := hr.cfg.Hooks
+	switch event {
+	case HookMention:
+		return strings.Fields(h.OnMention)
+	case HookUserMessage:
+		return strings.Fields(h.OnUserMessage)
+	case HookConnect:
+		return strings.Fields(h.OnConnect)
+	case HookDisconnect:
+		return strings.Fields(h.OnDisconnect)
+	case HookRefresh:
+		return strings.Fields(h.OnRefresh)
+	default:
+		return nil
+	}
+}
+
+// Check whether msgs from author should run the user_message hook.
+func (hr *hookRunner) watching(author *User) bool {
+	if author == nil {
+		return false
+	}
+
+	hr.mx.Lock()
+	defer hr.mx.Unlock()
+
+	if hr.cfg.Hooks.OnUserMessage == "" {
+		return false
+	}
+
+	id := strconv.FormatUint(uint64(author.ID), 10)
+	return slices.ContainsFunc(hr.cfg.Hooks.Users, func(u string) bool {
+		u = strings.TrimPrefix(strings.TrimSpace(u), "@")
+		return u == id || strings.EqualFold(u, author.Username)
+	})
+}
+
+// Run the hook for ev in the background, if one is set.
+// Skipped if too many hooks are already running.
+func (hr *hookRunner) fire(ctx context.Context, ev HookEvent) {
+	hr.mx.Lock()
+	cmd := hr.command(ev.Event)
+	if len(cmd) == 0 {
+		hr.mx.Unlock()
+		return
+	}
+	if hr.running >= hr.cfg.Hooks.MaxConcurrent {
+		max := hr.cfg.Hooks.MaxConcurrent
+		hr.mx.Unlock()
+		hr.log(ctx, fmt.Sprintf("Skipped %s hook. Already running the max of %d.", ev.Event, max))
+		return
+	}
+	hr.running++
+	timeout := time.Duration(hr.cfg.Hooks.Timeout * float64(time.Second))
+	ev.Profile = hr.cfg.Profile
+	hr.mx.Unlock()
+
+	ev.Time = time.Now()
+	// Encoded right away, since msgs get reused once released.
+	in, err := json.Marshal(ev)
+	if err != nil {
+		hr.done()
+		hr.log(ctx, fmt.Sprintf("Failed to encode %s hook event: %s", ev.Event, err))
+		return
+	}
+	env := childEnv(ev.env()...)
+
+	go func() {
+		defer hr.done()
+
+		hctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		out := &cappedBuffer{max: _HOOK_OUTPUT_MAX}
+		c := exec.CommandContext(hctx, cmd[0], cmd[1:]...)
+		c.Stdin = bytes.NewReader(in)
+		c.Stdout, c.Stderr = out, out
+		c.Env = env
+		// Don't wait forever on output from processes the hook left running.
+		c.WaitDelay = time.Second
+
+		err := c.Run()
+		if out.Len() > 0 {
+			hr.log(ctx, fmt.Sprintf("[%s hook] %s", ev.Event, out))
+		}
+
+		switch {
+		case errors.Is(hctx.Err(), context.DeadlineExceeded):
+			hr.log(ctx, fmt.Sprintf("The %s hook timed out after %s.", ev.Event, timeout))
+		case err != nil && hctx.Err() == nil:
+			hr.log(ctx, fmt.Sprintf("The %s hook failed: %s", ev.Event, err))
+		}
+	}()
+}
+
+func (hr *hookRunner) done() {
+	hr.mx.Lock()
+	defer hr.mx.Unlock()
+
+	hr.running--
+}
+
+func (hr *hookRunner) log(ctx context.Context, ms string) {
+	select {
+	case <-ctx.Done():
+	case hr.infoLog <- ms:
+	}
+}
@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChildEnv(t *testing.T) {
+	t.Setenv("SOCKCHAT_PASSPHRASE", "hunter2")
+	t.Setenv("SOCKCHAT_COOKIES", "xf_user=1")
+	t.Setenv("SOCKCHAT_PROXY_PASSWORD", "pass")
+	t.Setenv("HOOK_TEST_KEEP", "1")
+
+	env := childEnv("SOCKCHAT_EVENT=mention")
+
+	var kept, event bool
+	for _, kv := range env {
+		switch {
+		case kv == "HOOK_TEST_KEEP=1":
+			kept = true
+		case kv == "SOCKCHAT_EVENT=mention":
+			event = true
+		case strings.HasPrefix(kv, "SOCKCHAT_"):
+			t.Errorf("leaked %q", kv)
+		}
+	}
+	if !kept {
+		t.Error("dropped unrelated env var")
+	}
+	if !event {
+		t.Error("dropped extra env var")
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"under", []string{"abc"}, "abc"},
+		{"exact", []string{"abcde"}, "abcde"},
+		{"over", []string{"abcdefgh"}, "abcde..."},
+		{"spread", []string{"abc", "def", "ghi"}, "abcde..."},
+		{"trimmed", []string{" ab \n"}, "ab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &cappedBuffer{max: 5}
+			for _, w := range tt.writes {
+				if n, err := cb.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := cb.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
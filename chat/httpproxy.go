@@ -0,0 +1,147 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Max time for the proxy to answer CONNECT, including the TLS handshake for https:// proxies.
+const _PROXY_HANDSHAKE_TIMEOUT = 30 * time.Second
+
+// Lets proxy.FromURL handle http:// and https:// proxy URLs.
+func init() {
+	proxy.RegisterDialerType("http", newHTTPDialer)
+	proxy.RegisterDialerType("https", newHTTPDialer)
+}
+
+// Tunnels conns through an HTTP proxy with the CONNECT method.
+type httpDialer struct {
+	proxyURL *url.URL
+	forward  proxy.Dialer
+}
+
+func newHTTPDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	return &httpDialer{
+		proxyURL: u,
+		forward:  forward,
+	}, nil
+}
+
+func (d *httpDialer) Dial(network string, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d *httpDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	proxyAddr := d.proxyURL.Host
+	if d.proxyURL.Port() == "" {
+		port := "80"
+		if d.proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(d.proxyURL.Hostname(), port)
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if cd, ok := d.forward.(proxy.ContextDialer); ok {
+		conn, err = cd.DialContext(ctx, "tcp", proxyAddr)
+	} else {
+		conn, err = d.forward.Dial("tcp", proxyAddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't hang on a proxy that never answers, even if ctx has no deadline.
+	deadline := time.Now().Add(_PROXY_HANDSHAKE_TIMEOUT)
+	if cd, ok := ctx.Deadline(); ok && cd.Before(deadline) {
+		deadline = cd
+	}
+	conn.SetDeadline(deadline)
+	// Give up right away if ctx is cancelled.
+	raw := conn
+	cancelled := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		raw.SetDeadline(time.Unix(1, 0))
+		close(cancelled)
+	})
+	defer stop()
+
+	if d.proxyURL.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: d.proxyURL.Hostname()})
+		if err = tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := d.proxyURL.User; u != nil {
+		pass, _ := u.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			return nil, fmt.Errorf("Proxy authentication failed: %s", resp.Status)
+		}
+		return nil, fmt.Errorf("Proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+
+	// The callback may already be running, and would undo the reset below.
+	if !stop() {
+		<-cancelled
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	raw.SetDeadline(time.Time{})
+
+	// Keep anything the proxy sent after its response.
+	if br.Buffered() > 0 {
+		return &bufferedConn{conn, br}, nil
+	}
+
+	return conn, nil
+}
+
+// Conn with reads served from a buffer first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,221 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Starts a CONNECT proxy that answers with status, or tunnels to the requested addr if it's 200.
+// If user is set, requests without matching Basic auth get 407.
+// If silent is set, it never answers.
+func startTestProxy(t *testing.T, status int, user, pass string, silent bool) *url.URL {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				if silent {
+					io.Copy(io.Discard, br)
+					return
+				}
+
+				code := status
+				want := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+				if user != "" && req.Header.Get("Proxy-Authorization") != want {
+					code = http.StatusProxyAuthRequired
+				}
+				if code != http.StatusOK {
+					resp := &http.Response{StatusCode: code, ProtoMajor: 1, ProtoMinor: 1}
+					resp.Write(conn)
+					return
+				}
+
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer target.Close()
+
+				// Sent in the same write as the response, to check buffered data isn't lost.
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nhello from proxy\n"))
+				go io.Copy(target, br)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	u := &url.URL{Scheme: "http", Host: ln.Addr().String()}
+	if user != "" {
+		u.User = url.UserPassword(user, pass)
+	}
+	return u
+}
+
+// Starts a server that echoes lines back.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestHTTPDialer(t *testing.T) {
+	echo := startEchoServer(t)
+
+	tests := []struct {
+		name string
+		// Proxy settings.
+		status     int
+		user, pass string
+		// Credentials the client uses, if different.
+		clientUser, clientPass string
+		wantErr                string
+	}{
+		{name: "success", status: http.StatusOK},
+		{name: "basic auth", status: http.StatusOK, user: "bob", pass: "s3cret"},
+		{name: "wrong auth", status: http.StatusOK, user: "bob", pass: "s3cret", clientUser: "bob", clientPass: "nope",
+			wantErr: "Proxy authentication failed"},
+		{name: "bad status", status: http.StatusForbidden, wantErr: "Proxy refused CONNECT"},
+		{name: "bad gateway", status: http.StatusBadGateway, wantErr: "502"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := startTestProxy(t, tt.status, tt.user, tt.pass, false)
+			if tt.clientUser != "" {
+				u.User = url.UserPassword(tt.clientUser, tt.clientPass)
+			}
+
+			d, _ := newHTTPDialer(u, &net.Dialer{})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := d.(*httpDialer).DialContext(ctx, "tcp", echo)
+			if tt.wantErr != "" {
+				if err == nil {
+					conn.Close()
+					t.Fatalf("got no error, want %q", tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %q, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			br := bufio.NewReader(conn)
+			if line, err := br.ReadString('\n'); err != nil || line != "hello from proxy\n" {
+				t.Fatalf("lost data sent with the response: %q, %v", line, err)
+			}
+			conn.Write([]byte("ping\n"))
+			if line, err := br.ReadString('\n'); err != nil || line != "ping\n" {
+				t.Fatalf("got %q, %v from the tunnel", line, err)
+			}
+		})
+	}
+}
+
+func TestHTTPDialerSilentProxy(t *testing.T) {
+	u := startTestProxy(t, http.StatusOK, "", "", true)
+	d, _ := newHTTPDialer(u, &net.Dialer{})
+
+	// Cancelled without a deadline, which the dialer must still notice.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := d.(*httpDialer).DialContext(ctx, "tcp", "127.0.0.1:1")
+		if conn != nil {
+			conn.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("got no error from a proxy that never answered")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dial hung on a silent proxy")
+	}
+}
+
+// A cancel that lands as the dial finishes must not leave an expired deadline on the returned conn.
+func TestHTTPDialerCancelAfterConnect(t *testing.T) {
+	echo := startEchoServer(t)
+	u := startTestProxy(t, http.StatusOK, "", "", false)
+	d, _ := newHTTPDialer(u, &net.Dialer{})
+
+	for i := range 50 {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Duration(i%10)*100*time.Microsecond, cancel)
+
+		conn, err := d.(*httpDialer).DialContext(ctx, "tcp", echo)
+		if err != nil {
+			if ctx.Err() == nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		br := bufio.NewReader(conn)
+		if _, err = br.ReadString('\n'); err != nil {
+			t.Fatalf("conn unusable after a late cancel: %v", err)
+		}
+		conn.Write([]byte("ping\n"))
+		if line, err := br.ReadString('\n'); err != nil || line != "ping\n" {
+			t.Fatalf("got %q, %v from the tunnel after a late cancel", line, err)
+		}
+		conn.Close()
+		cancel()
+	}
+}
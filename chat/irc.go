@@ -0,0 +1,727 @@
+package chat
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+)
+
+const (
+	// Name the IRC server uses for itself.
+	_IRC_SERVER = "sockchat"
+	// Host part of user prefixes, like nick!id@host.
+	_IRC_USER_HOST = "users.sockchat"
+	// Max bytes of text per line sent to clients, leaving room for the prefix in IRC's 512 byte limit.
+	_IRC_MAX_TEXT = 400
+	// Time clients get to log in.
+	_IRC_REG_TIMEOUT   = 30 * time.Second
+	_IRC_WRITE_TIMEOUT = 30 * time.Second
+)
+
+// Channel name for a room, like #1.
+func ircChannel(room uint16) string {
+	return fmt.Sprintf("#%d", room)
+}
+
+// Room for a channel name. Returns false if it isn't one.
+func ircRoom(channel string) (uint16, bool) {
+	id, ok := strings.CutPrefix(channel, "#")
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseUint(id, 10, 16)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return uint16(n), true
+}
+
+type ircMsg struct {
+	cmd    string
+	params []string
+}
+
+// Parse a line from a client, like "PRIVMSG #1 :hello there". Any prefix is ignored.
+func parseIRCMsg(line string) ircMsg {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+
+	var m ircMsg
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		if strings.HasPrefix(line, ":") {
+			m.params = append(m.params, line[1:])
+			break
+		}
+
+		var word string
+		word, line, _ = strings.Cut(line, " ")
+		if word == "" {
+			continue
+		}
+		if m.cmd == "" {
+			m.cmd = strings.ToUpper(word)
+		} else {
+			m.params = append(m.params, word)
+		}
+	}
+
+	return m
+}
+
+// Get param i, or an empty string if there aren't that many.
+func (m ircMsg) param(i int) string {
+	if i < len(m.params) {
+		return m.params[i]
+	}
+	return ""
+}
+
+// Embedded IRC server. Each room is a channel, like #1.
+type ircServer struct {
+	c    *Chat
+	addr string
+	pass string
+	ln   net.Listener
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newIRCServer(c *Chat, cfg config.Config) (*ircServer, error) {
+	ln, err := net.Listen("tcp", cfg.IRC.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &ircServer{
+		c:      c,
+		addr:   cfg.IRC.Addr,
+		pass:   cfg.IRC.Pass,
+		ln:     ln,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		srv.accept()
+	}()
+
+	return srv, nil
+}
+
+func (srv *ircServer) accept() {
+	for {
+		conn, err := srv.ln.Accept()
+		if err != nil {
+			// Closed on purpose otherwise.
+			if srv.ctx.Err() == nil {
+				srv.c.errLog <- fmt.Errorf("IRC server stopped: %w", err)
+			}
+			return
+		}
+
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			newIRCConn(srv, conn).serve()
+		}()
+	}
+}
+
+// Disconnect every client and stop listening.
+func (srv *ircServer) close() {
+	srv.cancel()
+	srv.ln.Close()
+	srv.wg.Wait()
+}
+
+// Start, move or stop the IRC server to match cfg.
+// Must only be called from the router.
+func (c *Chat) setIRC(cfg config.Config) error {
+	if c.irc != nil {
+		if cfg.IRC.Enabled && c.irc.addr == cfg.IRC.Addr && c.irc.pass == cfg.IRC.Pass {
+			return nil
+		}
+		c.irc.close()
+		c.irc = nil
+	}
+	if !cfg.IRC.Enabled {
+		return nil
+	}
+
+	srv, err := newIRCServer(c, cfg)
+	if err != nil {
+		return fmt.Errorf("Failed to start IRC server on %s: %w", cfg.IRC.Addr, err)
+	}
+	c.irc = srv
+	c.infoLog <- fmt.Sprintf("IRC server listening on %s", cfg.IRC.Addr)
+
+	return nil
+}
+
+// A client connected to the IRC server.
+// Everything but reading lines happens in serve, so no locking is needed.
+type ircConn struct {
+	srv  *ircServer
+	c    *Chat
+	conn net.Conn
+	w    *bufio.Writer
+
+	nick   string
+	user   string
+	passOK bool
+	// Set while CAP negotiation holds up registration.
+	capping    bool
+	registered bool
+	// Joined channel, if any.
+	channel string
+	// Set once the client parts, so it isn't moved to the chat's room until it joins again.
+	parted bool
+
+	// Nicks by user ID, for users the client knows are in the channel.
+	nicks map[uint32]string
+	// User IDs by lowercase nick, for mentions and collisions.
+	ids map[string]uint32
+
+	// Only msgs after these are relayed, like with scripts.
+	since  int64
+	lastID uint32
+	// Edit dates of relayed edits, to skip repeats.
+	edits map[uint32]int64
+}
+
+func newIRCConn(srv *ircServer, conn net.Conn) *ircConn {
+	return &ircConn{
+		srv:   srv,
+		c:     srv.c,
+		conn:  conn,
+		w:     bufio.NewWriter(conn),
+		nicks: make(map[uint32]string),
+		ids:   make(map[string]uint32),
+		edits: make(map[uint32]int64),
+	}
+}
+
+func (ic *ircConn) serve() {
+	ctx := ic.srv.ctx
+	defer ic.conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		ic.conn.Close()
+	})
+	defer stop()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+
+		sc := bufio.NewScanner(ic.conn)
+		sc.Buffer(make([]byte, 0, 4096), 8192)
+		for sc.Scan() {
+			select {
+			case <-ctx.Done():
+				return
+			case lines <- sc.Text():
+			}
+		}
+	}()
+
+	ic.conn.SetReadDeadline(time.Now().Add(_IRC_REG_TIMEOUT))
+
+	var events <-chan Event
+	var found <-chan struct{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+
+			wasRegistered := ic.registered
+			if !ic.handle(ctx, parseIRCMsg(line)) {
+				ic.flush()
+				return
+			}
+			if ic.registered && !wasRegistered {
+				ic.conn.SetReadDeadline(time.Time{})
+
+				mf := ic.c.Feeder.Events(EventMessage|EventUser|EventConn,
+					Named("irc"), NoDebug(), Backpressure{Overflow: OverflowDropOldest})
+				defer mf.Close()
+				events = mf.Events
+				found = ic.c.Users.ClientFound()
+
+				ic.welcome()
+			}
+		case <-found:
+			found = nil
+			ic.setNick(ic.c.Users.ClientName())
+		case ev, ok := <-events:
+			if !ok {
+				ic.send("ERROR :Chat closed")
+				ic.flush()
+				return
+			}
+			ic.relay(ev)
+		}
+
+		if err := ic.flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Queue a line for the client. Sent on the next flush.
+func (ic *ircConn) send(format string, args ...any) {
+	fmt.Fprintf(ic.w, format, args...)
+	ic.w.WriteString("\r\n")
+}
+
+// Send a numeric reply, like 001.
+func (ic *ircConn) reply(code string, params ...string) {
+	nick := ic.nick
+	if nick == "" {
+		nick = "*"
+	}
+	ic.send(":%s %s %s %s", _IRC_SERVER, code, nick, strings.Join(params, " "))
+}
+
+func (ic *ircConn) notice(target, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		for _, chunk := range splitBytes(line, _IRC_MAX_TEXT) {
+			ic.send(":%s NOTICE %s :%s", _IRC_SERVER, target, chunk)
+		}
+	}
+}
+
+func (ic *ircConn) flush() error {
+	ic.conn.SetWriteDeadline(time.Now().Add(_IRC_WRITE_TIMEOUT))
+	return ic.w.Flush()
+}
+
+func (ic *ircConn) prefix() string {
+	return fmt.Sprintf("%s!%s@%s", ic.nick, ic.user, _IRC_USER_HOST)
+}
+
+// Handle a msg from the client. Returns false if the connection should be closed.
+func (ic *ircConn) handle(ctx context.Context, m ircMsg) bool {
+	switch m.cmd {
+	case "":
+		return true
+	case "PING":
+		ic.send(":%s PONG %s :%s", _IRC_SERVER, _IRC_SERVER, m.param(0))
+		return true
+	case "PONG":
+		return true
+	case "QUIT":
+		ic.send("ERROR :Closing link")
+		return false
+	}
+
+	if !ic.registered {
+		return ic.register(m)
+	}
+
+	switch m.cmd {
+	case "CAP":
+		// Nothing to negotiate.
+		if strings.ToUpper(m.param(0)) == "LS" {
+			ic.send(":%s CAP %s LS :", _IRC_SERVER, ic.nick)
+		}
+	case "PASS", "USER":
+		ic.reply("462", ":You may not reregister")
+	case "NICK":
+		if ic.c.Users.ClientName() != "" {
+			ic.reply("432", m.param(0), ":Nick is set by your chat username")
+			break
+		}
+		ic.setNick(m.param(0))
+	case "JOIN":
+		for _, ch := range strings.Split(m.param(0), ",") {
+			room, ok := ircRoom(ch)
+			if !ok {
+				ic.reply("403", ch, ":No such room")
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return false
+			case ic.c.Out <- fmt.Sprintf("/join %d", room):
+			}
+			ic.parted = false
+			ic.join(ircChannel(room))
+		}
+	case "PART":
+		for _, ch := range strings.Split(m.param(0), ",") {
+			if ch != ic.channel {
+				ic.reply("442", ch, ":You're not on that channel")
+				continue
+			}
+			ic.send(":%s PART %s", ic.prefix(), ch)
+			ic.channel = ""
+			ic.parted = true
+		}
+	case "PRIVMSG":
+		return ic.privmsg(ctx, m.param(0), m.param(1))
+	case "NOTICE":
+		// Clients must not get automatic replies to notices.
+	case "NAMES":
+		ic.names(m.param(0))
+	case "WHO":
+		ic.who(m.param(0))
+	case "WHOIS":
+		ic.whois(m.param(len(m.params) - 1))
+	case "TOPIC":
+		ic.reply("331", m.param(0), ":No topic is set")
+	case "MODE":
+		switch target := m.param(0); {
+		case strings.HasPrefix(target, "#") && m.param(1) == "b":
+			ic.reply("368", target, ":End of channel ban list")
+		case strings.HasPrefix(target, "#"):
+			ic.reply("324", target, "+nt")
+		default:
+			ic.reply("221", "+i")
+		}
+	case "LIST":
+		if ic.channel != "" {
+			ic.reply("322", ic.channel, strconv.Itoa(len(ic.nicks)), ":")
+		}
+		ic.reply("323", ":End of LIST")
+	default:
+		ic.reply("421", m.cmd, ":Unknown command")
+	}
+
+	return true
+}
+
+// Handle msgs from a client that hasn't logged in yet.
+func (ic *ircConn) register(m ircMsg) bool {
+	switch m.cmd {
+	case "CAP":
+		switch strings.ToUpper(m.param(0)) {
+		case "LS":
+			ic.capping = true
+			ic.send(":%s CAP * LS :", _IRC_SERVER)
+		case "REQ":
+			ic.send(":%s CAP * NAK :%s", _IRC_SERVER, m.param(1))
+		case "END":
+			ic.capping = false
+		}
+	case "PASS":
+		ic.passOK = subtle.ConstantTimeCompare([]byte(m.param(0)), []byte(ic.srv.pass)) == 1
+	case "NICK":
+		if m.param(0) == "" {
+			ic.reply("431", ":No nickname given")
+			break
+		}
+		ic.nick = m.param(0)
+	case "USER":
+		if m.param(0) == "" {
+			ic.reply("461", "USER", ":Not enough parameters")
+			break
+		}
+		ic.user = m.param(0)
+	default:
+		ic.reply("451", ":You have not registered")
+	}
+
+	if ic.nick == "" || ic.user == "" || ic.capping {
+		return true
+	}
+	if !ic.passOK {
+		ic.reply("464", ":Password incorrect")
+		ic.send("ERROR :Closing link")
+		return false
+	}
+
+	ic.registered = true
+	return true
+}
+
+func (ic *ircConn) welcome() {
+	ic.since = time.Now().Unix()
+
+	ic.reply("001", fmt.Sprintf(":Welcome to the chat, %s", ic.nick))
+	ic.reply("002", fmt.Sprintf(":Your host is %s", _IRC_SERVER))
+	ic.reply("003", ":This server was created just now")
+	ic.reply("004", _IRC_SERVER, "sockchat", "i", "nt")
+	ic.reply("005", "CHANTYPES=#", "CASEMAPPING=ascii", "NETWORK=sockchat", ":are supported by this server")
+	ic.reply("422", ":MOTD File is missing")
+
+	ic.setNick(ic.c.Users.ClientName())
+	// Otherwise the channel is joined with the first msg.
+	if room, ok := ic.c.Feeder.Room(); ok {
+		ic.join(ircChannel(room))
+	}
+}
+
+// Change the client's nick to the chat username, if it's known.
+func (ic *ircConn) setNick(name string) {
+	if name == "" {
+		return
+	}
+
+	nick := ircNick(&User{ID: ic.c.Users.ClientID(), Username: name})
+	if nick != ic.nick {
+		ic.send(":%s NICK :%s", ic.prefix(), nick)
+		ic.nick = nick
+	}
+	ic.nicks[ic.c.Users.ClientID()] = nick
+	ic.ids[strings.ToLower(nick)] = ic.c.Users.ClientID()
+}
+
+// Move the client to channel, leaving the one it was in.
+func (ic *ircConn) join(channel string) {
+	if channel == ic.channel {
+		return
+	}
+	if ic.channel != "" {
+		ic.send(":%s PART %s :Switched rooms", ic.prefix(), ic.channel)
+	}
+
+	ic.channel = channel
+	ic.send(":%s JOIN %s", ic.prefix(), channel)
+	ic.reply("331", channel, ":No topic is set")
+	ic.names(channel)
+}
+
+// Get the nick for u, making one up if it's new. Nicks stay the same for the whole connection,
+// and ones that collide get the user ID added.
+func (ic *ircConn) nickFor(u *User) (string, bool) {
+	if nick, ok := ic.nicks[u.ID]; ok {
+		return nick, false
+	}
+
+	nick := ircNick(u)
+	if id, ok := ic.ids[strings.ToLower(nick)]; ok && id != u.ID {
+		nick = fmt.Sprintf("%s|%d", nick, u.ID)
+	}
+	ic.nicks[u.ID] = nick
+	ic.ids[strings.ToLower(nick)] = u.ID
+
+	return nick, true
+}
+
+// Every user seen so far, sorted by ID.
+func (ic *ircConn) users() []*User {
+	users := make([]*User, 0)
+	ic.c.Users.Range(func(_, v any) bool {
+		users = append(users, v.(*User))
+		return true
+	})
+	slices.SortFunc(users, func(a, b *User) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return users
+}
+
+func (ic *ircConn) names(channel string) {
+	if channel == ic.channel && channel != "" {
+		line := ""
+		flush := func() {
+			if line != "" {
+				ic.reply("353", "=", channel, ":"+strings.TrimSpace(line))
+				line = ""
+			}
+		}
+
+		for _, u := range ic.users() {
+			nick, _ := ic.nickFor(u)
+			if len(line)+len(nick) > _IRC_MAX_TEXT {
+				flush()
+			}
+			line += " " + nick
+		}
+		if _, ok := ic.nicks[ic.c.Users.ClientID()]; !ok {
+			line += " " + ic.nick
+		}
+		flush()
+	}
+	ic.reply("366", cmp.Or(channel, "*"), ":End of NAMES list")
+}
+
+func (ic *ircConn) who(channel string) {
+	if channel == ic.channel && channel != "" {
+		for _, u := range ic.users() {
+			nick, _ := ic.nickFor(u)
+			ic.reply("352", channel, strconv.Itoa(int(u.ID)), _IRC_USER_HOST, _IRC_SERVER, nick, "H", ":0 "+u.Username)
+		}
+	}
+	ic.reply("315", cmp.Or(channel, "*"), ":End of WHO list")
+}
+
+func (ic *ircConn) whois(nick string) {
+	if id, ok := ic.ids[strings.ToLower(nick)]; ok {
+		if u := ic.c.Users.Query(id); u != nil {
+			ic.reply("311", nick, strconv.Itoa(int(u.ID)), _IRC_USER_HOST, "*", ":"+u.Username)
+		}
+	} else {
+		ic.reply("401", nick, ":No such nick")
+	}
+	ic.reply("318", nick, ":End of WHOIS list")
+}
+
+// Pass a msg from the client on to the chat.
+func (ic *ircConn) privmsg(ctx context.Context, target, text string) bool {
+	if !strings.HasPrefix(target, "#") {
+		ic.reply("401", target, ":Private msgs aren't supported")
+		return true
+	}
+	if target != ic.channel {
+		ic.reply("404", target, ":Join the channel to switch to its room first")
+		return true
+	}
+
+	// CTCP, like ACTION for /me.
+	if ctcp, ok := strings.CutPrefix(text, "\x01"); ok {
+		ctcp = strings.TrimSuffix(ctcp, "\x01")
+		action, ok := strings.CutPrefix(ctcp, "ACTION ")
+		if !ok {
+			return true
+		}
+		text = fmt.Sprintf("[i]%s[/i]", action)
+	}
+
+	text = ic.mentions(stripIRC(text))
+	if strings.TrimSpace(text) == "" {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case ic.c.Out <- text:
+		return true
+	}
+}
+
+// Turn "nick: " at the start of text, as IRC clients add for replies, into an @ mention.
+func (ic *ircConn) mentions(text string) string {
+	nick, rest, ok := strings.Cut(text, " ")
+	if !ok || !(strings.HasSuffix(nick, ":") || strings.HasSuffix(nick, ",")) {
+		return text
+	}
+
+	id, ok := ic.ids[strings.ToLower(nick[:len(nick)-1])]
+	if !ok {
+		return text
+	}
+	u := ic.c.Users.Query(id)
+	if u == nil {
+		return text
+	}
+
+	return fmt.Sprintf("@%s, %s", u.Username, rest)
+}
+
+// Send an event from the feeder to the client.
+func (ic *ircConn) relay(ev Event) {
+	switch ev.Kind {
+	case EventMessage:
+		ic.relayMsg(&ev.Message)
+	case EventUser:
+		if ic.channel == "" || ev.User.ID == ic.c.Users.ClientID() {
+			return
+		}
+		ic.userJoined(&ev.User)
+	case EventConn:
+		text := fmt.Sprintf("Chat %s.", ev.State)
+		if ev.Err != nil {
+			text = fmt.Sprintf("Chat %s: %s", ev.State, ev.Err)
+		}
+		ic.notice(cmp.Or(ic.channel, ic.nick), text)
+	}
+}
+
+// Show u joining the channel if the client doesn't know about them yet,
+// or changing nick if their username changed.
+func (ic *ircConn) userJoined(u *User) {
+	old, known := ic.nicks[u.ID]
+	if known && old == ircNick(u) {
+		return
+	}
+	if known {
+		delete(ic.nicks, u.ID)
+		delete(ic.ids, strings.ToLower(old))
+	}
+
+	nick, _ := ic.nickFor(u)
+	if known {
+		ic.send(":%s!%d@%s NICK :%s", old, u.ID, _IRC_USER_HOST, nick)
+		return
+	}
+	ic.send(":%s!%d@%s JOIN %s", nick, u.ID, _IRC_USER_HOST, ic.channel)
+}
+
+func (ic *ircConn) relayMsg(msg *Message) {
+	if msg.Author == nil {
+		return
+	}
+	// Client msgs, like connection info.
+	if msg.Author.ID == 0 {
+		ic.notice(cmp.Or(ic.channel, ic.nick), msg.MessageRaw)
+		return
+	}
+
+	// The chat only follows one room at a time, so follow it to wherever it went,
+	// even for msgs that are too old to relay.
+	// Clients that parted stay out until they join again.
+	if ic.parted {
+		return
+	}
+	ic.join(ircChannel(msg.RoomID))
+
+	prefix := ""
+	switch {
+	case msg.IsEdited():
+		if msg.MessageEditDate < ic.since || ic.edits[msg.MessageID] >= msg.MessageEditDate {
+			return
+		}
+		if len(ic.edits) >= HIST_LEN {
+			clear(ic.edits)
+		}
+		ic.edits[msg.MessageID] = msg.MessageEditDate
+		prefix = _IRC_ITALIC + "(edited)" + _IRC_ITALIC + " "
+	case msg.MessageDate < ic.since || msg.MessageID <= ic.lastID:
+		return
+	default:
+		ic.lastID = msg.MessageID
+	}
+
+	// IRC clients show their own msgs when sending them.
+	if msg.Author.ID == ic.c.Users.ClientID() {
+		return
+	}
+
+	ic.userJoined(msg.Author)
+	nick := ic.nicks[msg.Author.ID]
+	for _, line := range strings.Split(bbToIRC(msg.MessageRaw), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		for _, chunk := range splitBytes(prefix+line, _IRC_MAX_TEXT) {
+			ic.send(":%s!%d@%s PRIVMSG %s :%s", nick, msg.Author.ID, _IRC_USER_HOST, ic.channel, chunk)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package chat
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBBToIRC(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "hello", "hello"},
+		{"bold", "[b]hi[/b]", "\x02hi\x02"},
+		{"upper case tags", "[I]hi[/I]", "\x1Dhi\x1D"},
+		{"nested", "[b][u]hi[/u][/b]", "\x02\x1Fhi\x1F\x02"},
+		{"strike", "[s]no[/s]", "\x1Eno\x1E"},
+		{"named color", "[color=red]hi[/color]", "\x0304hi\x03\x0F"},
+		{"hex color", "[color=#0000fc]hi[/color]", "\x0312hi\x03\x0F"},
+		{"quoted color", `[color="green"]hi[/color]`, "\x0303hi\x03\x0F"},
+		// Without the reset the 5 would turn into a color.
+		{"digit after color", "[color=red]hi[/color]5", "\x0304hi\x03\x0F5"},
+		{"unknown color", "[color=nope]hi[/color]", "hi\x03\x0F"},
+		{"link", "[url=https://example.com]site[/url]", "site (https://example.com)"},
+		{"bare link", "[url]https://example.com[/url]", "https://example.com"},
+		{"unmatched link close", "a[/url]b", "ab"},
+		{"image", "[img]https://example.com/a.png[/img]", "https://example.com/a.png"},
+		{"dropped tags", "[quote][code]x[/code][/quote]", "x"},
+		{"unknown tag", "[foo]x[/foo]", "[foo]x[/foo]"},
+		{"not a tag", "a [1] b", "a [1] b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bbToIRC(tt.in); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIRCColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"red", 4, true},
+		{" Teal ", 10, true},
+		{"#ff0000", 4, true},
+		{"#fff", 0, true},
+		{"#000000", 1, true},
+		{"#72ff72", 3, true},
+		{"#12345", 0, false},
+		{"#zzzzzz", 0, false},
+		{"rebeccapurple", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := ircColor(tt.in)
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("got %d, %v, want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseIRCMsg(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		cmd    string
+		params []string
+	}{
+		{"empty", "", "", nil},
+		{"no params", "QUIT", "QUIT", nil},
+		{"trailing", "PRIVMSG #1 :hello there", "PRIVMSG", []string{"#1", "hello there"}},
+		{"lower case cmd", "privmsg #1 :hi", "PRIVMSG", []string{"#1", "hi"}},
+		{"prefix", ":bob!bob@host PRIVMSG #1 :hi", "PRIVMSG", []string{"#1", "hi"}},
+		{"line ending", "NICK bob\r\n", "NICK", []string{"bob"}},
+		{"extra spaces", "USER  bob 0   * :Bob Smith", "USER", []string{"bob", "0", "*", "Bob Smith"}},
+		{"empty trailing", "PING :", "PING", []string{""}},
+		{"colon in trailing", "PRIVMSG #1 ::) hi", "PRIVMSG", []string{"#1", ":) hi"}},
+		{"list param", "JOIN #1,#2", "JOIN", []string{"#1,#2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := parseIRCMsg(tt.line)
+			if m.cmd != tt.cmd || !slices.Equal(m.params, tt.params) {
+				t.Fatalf("got %q %q, want %q %q", m.cmd, m.params, tt.cmd, tt.params)
+			}
+		})
+	}
+}
+
+func TestIRCRoom(t *testing.T) {
+	tests := []struct {
+		channel string
+		want    uint16
+		ok      bool
+	}{
+		{"#1", 1, true},
+		{"#65535", 65535, true},
+		{"#0", 0, false},
+		{"#65536", 0, false},
+		{"#general", 0, false},
+		{"1", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			got, ok := ircRoom(tt.channel)
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("got %d, %v, want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestIRCNick(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		want     string
+	}{
+		{"plain", "bob", "bob"},
+		{"special chars", "[bob]_^{|}", "[bob]_^{|}"},
+		{"space", "John Doe", "John_Doe"},
+		{"leading digit", "2cool", "_2cool"},
+		{"leading dash", "-x-", "_-x-"},
+		{"digits after letter", "bob42", "bob42"},
+		{"non-ascii", "Jörg", "J_rg"},
+		{"all invalid", "日本", "user5"},
+		{"only underscores", "__", "user5"},
+		{"empty", "", "user5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ircNick(&User{ID: 5, Username: tt.username}); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want []string
+	}{
+		{"short", "abc", 5, []string{"abc"}},
+		{"exact", "abcde", 5, []string{"abcde"}},
+		{"split", "abcdefg", 3, []string{"abc", "def", "g"}},
+		// é is 2 bytes, so it goes to the next chunk.
+		{"multibyte", "abé", 3, []string{"ab", "é"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitBytes(tt.s, tt.n); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package chat
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// IRC formatting codes.
+const (
+	_IRC_BOLD      = "\x02"
+	_IRC_COLOR     = "\x03"
+	_IRC_RESET     = "\x0F"
+	_IRC_ITALIC    = "\x1D"
+	_IRC_STRIKE    = "\x1E"
+	_IRC_UNDERLINE = "\x1F"
+)
+
+// RGB values of the 16 standard IRC colors, by color number.
+var ircPalette = [16][3]int{
+	{255, 255, 255}, {0, 0, 0}, {0, 0, 127}, {0, 147, 0},
+	{255, 0, 0}, {127, 0, 0}, {156, 0, 156}, {252, 127, 0},
+	{255, 255, 0}, {0, 252, 0}, {0, 147, 147}, {0, 255, 255},
+	{0, 0, 252}, {255, 0, 255}, {127, 127, 127}, {210, 210, 210},
+}
+
+var ircColorNames = map[string]int{
+	"white": 0, "black": 1, "navy": 2, "blue": 12, "green": 3, "red": 4,
+	"brown": 5, "maroon": 5, "purple": 6, "orange": 7, "yellow": 8, "lime": 9,
+	"teal": 10, "cyan": 11, "aqua": 11, "pink": 13, "magenta": 13, "fuchsia": 13,
+	"gray": 14, "grey": 14, "silver": 15, "lightgray": 15, "lightgrey": 15,
+}
+
+// Get the IRC color number closest to a BBCode color, like red or #72ff72.
+func ircColor(color string) (int, bool) {
+	color = strings.ToLower(strings.TrimSpace(color))
+	if n, ok := ircColorNames[color]; ok {
+		return n, true
+	}
+
+	hex, ok := strings.CutPrefix(color, "#")
+	if !ok {
+		return 0, false
+	}
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return 0, false
+	}
+	r, g, b := int(v>>16), int(v>>8&0xff), int(v&0xff)
+
+	// Differences in hue count double, so light colors don't all end up gray.
+	dist := func(c [3]int) int {
+		dr, dg, db := r-c[0], g-c[1], b-c[2]
+		avg := (dr + dg + db) / 3
+		cr, cg, cb := dr-avg, dg-avg, db-avg
+		return dr*dr + dg*dg + db*db + 2*(cr*cr+cg*cg+cb*cb)
+	}
+
+	best, bestDist := 0, -1
+	for i, c := range ircPalette {
+		if d := dist(c); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+
+	return best, true
+}
+
+var bbTagRE = regexp.MustCompile(`\[(/?[a-zA-Z*]+)(?:="?([^"\]]*)"?)?\]`)
+
+// Convert BBCode in a msg to IRC formatting codes. Links are kept as plain URLs after their text.
+// Unknown tags are left as they are.
+func bbToIRC(msg string) string {
+	// Targets of [url] tags waiting for their closing tag.
+	var links []string
+
+	return bbTagRE.ReplaceAllStringFunc(msg, func(tag string) string {
+		subs := bbTagRE.FindStringSubmatch(tag)
+		param := subs[2]
+
+		switch strings.ToLower(subs[1]) {
+		case "b", "/b":
+			return _IRC_BOLD
+		case "i", "/i":
+			return _IRC_ITALIC
+		case "u", "/u":
+			return _IRC_UNDERLINE
+		case "s", "/s":
+			return _IRC_STRIKE
+		case "color":
+			if n, ok := ircColor(param); ok {
+				return fmt.Sprintf("%s%02d", _IRC_COLOR, n)
+			}
+			return ""
+		case "/color":
+			// A bare color code followed by a digit would be read as a new color.
+			return _IRC_COLOR + _IRC_RESET
+		case "url":
+			links = append(links, param)
+			return ""
+		case "/url":
+			if len(links) == 0 {
+				return ""
+			}
+			link := links[len(links)-1]
+			links = links[:len(links)-1]
+			if link == "" {
+				return ""
+			}
+			return fmt.Sprintf(" (%s)", link)
+		case "img", "/img", "quote", "/quote", "code", "/code", "plain", "/plain", "spoiler", "/spoiler":
+			return ""
+		default:
+			return tag
+		}
+	})
+}
+
+var ircCodeRE = regexp.MustCompile("\x03(\\d{1,2}(,\\d{1,2})?)?|[\x02\x0F\x11\x16\x1D\x1E\x1F]")
+
+// Remove IRC formatting codes from text sent by a client.
+func stripIRC(text string) string {
+	return ircCodeRE.ReplaceAllString(text, "")
+}
+
+// Make a valid IRC nick from a username, which may have spaces and other chars IRC doesn't allow.
+func ircNick(u *User) string {
+	var sb strings.Builder
+	for _, r := range u.Username {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', strings.ContainsRune("[]\\`_^{|}", r):
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9', r == '-':
+			if sb.Len() == 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+
+	if strings.Trim(sb.String(), "_") == "" {
+		return fmt.Sprintf("user%d", u.ID)
+	}
+	return sb.String()
+}
+
+// Split s into chunks of at most n bytes without breaking up chars.
+func splitBytes(s string, n int) []string {
+	var chunks []string
+	for len(s) > n {
+		i := n
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		if i == 0 {
+			i = n
+		}
+		chunks = append(chunks, s[:i])
+		s = s[i:]
+	}
+
+	return append(chunks, s)
+}
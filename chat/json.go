@@ -35,6 +35,7 @@ func (s *sock) ParseResponse(ctx context.Context, b []byte) (ServerResponse, err
 	var sr ServerResponse
 	err := json.Unmarshal(b, &sr)
 	if err != nil {
+		s.metrics.parseErrors.Add(1)
 		return sr, err
 	}
 
@@ -42,10 +43,12 @@ func (s *sock) ParseResponse(ctx context.Context, b []byte) (ServerResponse, err
 		msgs, errs := s.ParseMessages(ctx, sr)
 		go func() {
 			for err := range errs {
+				s.metrics.parseErrors.Add(1)
 				s.errLog <- err
 			}
 		}()
 		for msg := range msgs {
+			s.metrics.msgReceived(msg.RoomID)
 			s.messages <- msg
 		}
 	}()
@@ -54,11 +57,12 @@ func (s *sock) ParseResponse(ctx context.Context, b []byte) (ServerResponse, err
 		users, errs := s.ParseUserRecords(ctx, sr)
 		go func() {
 			for err := range errs {
+				s.metrics.parseErrors.Add(1)
 				s.errLog <- err
 			}
 		}()
 		for user := range users {
-			s.Users.AddUser(user)
+			s.event(Event{Kind: EventUser, User: *s.Users.AddUser(user)})
 			user.Release()
 		}
 	}()
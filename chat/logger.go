@@ -32,7 +32,8 @@ func newLogDir(baseDir string) (string, error) {
 	return outDir, nil
 }
 
-func startLogger(in <-chan Message) error {
+// suffix is appended to the log file name to keep concurrent sessions apart.
+func startLogger(in <-chan Message, suffix string) error {
 	cfgDir, err := os.UserConfigDir()
 	if err != nil {
 		return err
@@ -43,7 +44,7 @@ func startLogger(in <-chan Message) error {
 		return err
 	}
 
-	lf, err := openLog(filepath.Join(logDir, fmt.Sprintf("%s.log", time.Now().Format(_DATE_FMT))))
+	lf, err := openLog(filepath.Join(logDir, fmt.Sprintf("%s%s.log", time.Now().Format(_DATE_FMT), suffix)))
 	if err != nil {
 		return err
 	}
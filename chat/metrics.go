@@ -0,0 +1,268 @@
+package chat
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+)
+
+// Counters for the metrics endpoint. Gauges like the queue depth are read when scraped instead.
+type metrics struct {
+	mx       sync.Mutex
+	received map[uint16]uint64
+
+	parseErrors  atomic.Uint64
+	reconnects   atomic.Uint64
+	outgoing     atomic.Uint64
+	refreshes    atomic.Uint64
+	refreshFails atomic.Uint64
+	// How long the last Tor start took. 0 if Tor wasn't used.
+	torBootstrap atomic.Int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		received: make(map[uint16]uint64),
+	}
+}
+
+func (m *metrics) msgReceived(room uint16) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.received[room]++
+}
+
+func (m *metrics) refreshed(err error) {
+	if err != nil {
+		m.refreshFails.Add(1)
+		return
+	}
+	m.refreshes.Add(1)
+}
+
+type metricSample struct {
+	// Pairs of label names and values.
+	labels []string
+	value  float64
+}
+
+type metricFamily struct {
+	name string
+	typ  string
+	help string
+	// Called for every chat on the endpoint.
+	samples func(c *Chat) []metricSample
+}
+
+func counter(v uint64, labels ...string) []metricSample {
+	return []metricSample{{labels, float64(v)}}
+}
+
+var metricFamilies = []metricFamily{
+	{"sockchat_messages_received_total", "counter", "Chat msgs received, by room.", func(c *Chat) []metricSample {
+		m := c.metrics
+		m.mx.Lock()
+		defer m.mx.Unlock()
+
+		rooms := make([]uint16, 0, len(m.received))
+		for r := range m.received {
+			rooms = append(rooms, r)
+		}
+		slices.Sort(rooms)
+
+		samples := make([]metricSample, len(rooms))
+		for i, r := range rooms {
+			samples[i] = metricSample{[]string{"room", strconv.Itoa(int(r))}, float64(m.received[r])}
+		}
+		return samples
+	}},
+	{"sockchat_parse_errors_total", "counter", "Responses and msgs from the server that failed to parse.", func(c *Chat) []metricSample {
+		return counter(c.metrics.parseErrors.Load())
+	}},
+	{"sockchat_reconnect_attempts_total", "counter", "Attempts at opening the socket.", func(c *Chat) []metricSample {
+		return counter(c.metrics.reconnects.Load())
+	}},
+	{"sockchat_connection_state", "gauge", "1 for the current connection state.", func(c *Chat) []metricSample {
+		cur, _, _ := c.state.get()
+		samples := make([]metricSample, 0, StateClosed+1)
+		for st := StateDisconnected; st <= StateClosed; st++ {
+			v := 0.0
+			if st == cur {
+				v = 1
+			}
+			samples = append(samples, metricSample{[]string{"state", st.String()}, v})
+		}
+		return samples
+	}},
+	{"sockchat_outgoing_messages_total", "counter", "Msgs queued to be sent.", func(c *Chat) []metricSample {
+		return counter(c.metrics.outgoing.Load())
+	}},
+	{"sockchat_send_queue_depth", "gauge", "Msgs waiting to be written to the socket.", func(c *Chat) []metricSample {
+		return counter(uint64(c.Queue.Len()))
+	}},
+	{"sockchat_feed_queued", "gauge", "Msgs waiting to be read by each feed subscriber.", func(c *Chat) []metricSample {
+		return feedSamples(c, func(st FeedStats) float64 { return float64(st.Queued) })
+	}},
+	{"sockchat_feed_capacity", "gauge", "Buffer size of each feed subscriber.", func(c *Chat) []metricSample {
+		return feedSamples(c, func(st FeedStats) float64 { return float64(st.Cap) })
+	}},
+	{"sockchat_feed_dropped_total", "counter", "Msgs dropped because a feed subscriber fell behind.", func(c *Chat) []metricSample {
+		return feedSamples(c, func(st FeedStats) float64 { return float64(st.Drops) })
+	}},
+	{"sockchat_session_refreshes_total", "counter", "Session token refreshes, by result.", func(c *Chat) []metricSample {
+		return append(counter(c.metrics.refreshes.Load(), "result", "ok"),
+			counter(c.metrics.refreshFails.Load(), "result", "error")...)
+	}},
+	{"sockchat_tor_bootstrap_seconds", "gauge", "How long the last Tor start took.", func(c *Chat) []metricSample {
+		d := time.Duration(c.metrics.torBootstrap.Load())
+		if d == 0 {
+			return nil
+		}
+		return []metricSample{{nil, d.Seconds()}}
+	}},
+}
+
+// Unnamed feeds are labeled by position.
+func feedSamples(c *Chat, value func(st FeedStats) float64) []metricSample {
+	stats := c.Feeder.Stats()
+	samples := make([]metricSample, len(stats))
+	for i, st := range stats {
+		name := st.Name
+		if name == "" {
+			name = fmt.Sprintf("feed%d", i)
+		}
+		samples[i] = metricSample{[]string{"feed", name}, value(st)}
+	}
+
+	return samples
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Serves metrics for every chat using the same address, labeled by profile.
+type metricsServer struct {
+	srv   *http.Server
+	chats []*Chat
+	// Profiles of chats, by index. Kept here since Cfg may change during a reload.
+	profiles []string
+}
+
+// Servers by address, shared by profiles running at once.
+var metricsServers = struct {
+	mx     sync.Mutex
+	byAddr map[string]*metricsServer
+}{byAddr: make(map[string]*metricsServer)}
+
+func registerMetrics(addr string, c *Chat, profile string) error {
+	metricsServers.mx.Lock()
+	defer metricsServers.mx.Unlock()
+
+	ms, ok := metricsServers.byAddr[addr]
+	if !ok {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		ms = &metricsServer{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", ms.serveHTTP)
+		ms.srv = &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go ms.srv.Serve(ln)
+
+		metricsServers.byAddr[addr] = ms
+	}
+	ms.chats = append(ms.chats, c)
+	ms.profiles = append(ms.profiles, profile)
+
+	return nil
+}
+
+// Stop serving metrics for c. The server is shut down once no chats are left on it.
+func unregisterMetrics(addr string, c *Chat) {
+	metricsServers.mx.Lock()
+	defer metricsServers.mx.Unlock()
+
+	ms, ok := metricsServers.byAddr[addr]
+	if !ok {
+		return
+	}
+
+	if i := slices.Index(ms.chats, c); i >= 0 {
+		ms.chats = slices.Delete(ms.chats, i, i+1)
+		ms.profiles = slices.Delete(ms.profiles, i, i+1)
+	}
+	if len(ms.chats) == 0 {
+		ms.srv.Close()
+		delete(metricsServers.byAddr, addr)
+	}
+}
+
+func (ms *metricsServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	metricsServers.mx.Lock()
+	chats, profiles := slices.Clone(ms.chats), slices.Clone(ms.profiles)
+	metricsServers.mx.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	for _, mf := range metricFamilies {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", mf.name, mf.help, mf.name, mf.typ)
+		for i, c := range chats {
+			for _, s := range mf.samples(c) {
+				labels := append([]string{"profile", profiles[i]}, s.labels...)
+				pairs := make([]string, 0, len(labels)/2)
+				for j := 0; j+1 < len(labels); j += 2 {
+					pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[j], labelEscaper.Replace(labels[j+1])))
+				}
+				fmt.Fprintf(bw, "%s{%s} %s\n", mf.name, strings.Join(pairs, ","), strconv.FormatFloat(s.value, 'g', -1, 64))
+			}
+		}
+	}
+}
+
+// Start, move or stop the metrics endpoint to match cfg.
+// Must only be called from the router.
+func (c *Chat) setMetrics(cfg config.Config) error {
+	addr := ""
+	if cfg.Metrics.Enabled {
+		addr = cfg.Metrics.Addr
+	}
+	if addr == c.metricsAddr {
+		return nil
+	}
+
+	if c.metricsAddr != "" {
+		unregisterMetrics(c.metricsAddr, c)
+		c.metricsAddr = ""
+	}
+	if addr == "" {
+		return nil
+	}
+
+	profile := cfg.Profile
+	if profile == "" {
+		profile = config.DEFAULT_PROFILE
+	}
+	if err := registerMetrics(addr, c, profile); err != nil {
+		return fmt.Errorf("Failed to serve metrics on %s: %w", addr, err)
+	}
+	c.metricsAddr = addr
+	c.infoLog <- fmt.Sprintf("Serving metrics on http://%s/metrics", addr)
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package chat
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+)
+
+// Get an address nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return ln.Addr().String()
+}
+
+func scrape(t *testing.T, addr string) (string, error) {
+	t.Helper()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + addr + "/metrics")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	return string(b), err
+}
+
+func TestMetricsServer(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	chats := make([]*Chat, 2)
+	for i := range chats {
+		c, err := NewChat(ctx, config.NewConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		chats[i] = c
+	}
+	chats[0].metrics.msgReceived(5)
+	chats[0].metrics.msgReceived(5)
+	chats[1].metrics.msgReceived(7)
+
+	addr := freeAddr(t)
+	profiles := []string{"default", "q\"b\\s\nn"}
+	for i, c := range chats {
+		if err := registerMetrics(addr, c, profiles[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, c := range chats {
+			unregisterMetrics(addr, c)
+		}
+	})
+
+	metricsServers.mx.Lock()
+	servers := len(metricsServers.byAddr)
+	metricsServers.mx.Unlock()
+	if servers != 1 {
+		t.Fatalf("got %d servers for one address", servers)
+	}
+
+	body, err := scrape(t, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{`sockchat_messages_received_total{profile="default",room="5"} 2`, true},
+		{`sockchat_messages_received_total{profile="q\"b\\s\nn",room="7"} 1`, true},
+		{`sockchat_parse_errors_total{profile="default"} 0`, true},
+		{`sockchat_parse_errors_total{profile="q\"b\\s\nn"} 0`, true},
+		{`sockchat_session_refreshes_total{profile="default",result="ok"} 0`, true},
+		{`sockchat_connection_state{profile="default",state="` + StateDisconnected.String() + `"} 1`, true},
+		// Rooms must stay with their own profile.
+		{`sockchat_messages_received_total{profile="default",room="7"} 1`, false},
+		// Tor wasn't used.
+		{`sockchat_tor_bootstrap_seconds{`, false},
+	}
+	lines := strings.Split(body, "\n")
+	for _, tt := range tests {
+		got := false
+		for _, l := range lines {
+			if strings.HasPrefix(l, tt.line) {
+				got = true
+			}
+		}
+		if got != tt.want {
+			t.Errorf("got line %s: %v, want %v", tt.line, got, tt.want)
+		}
+	}
+	if n := strings.Count(body, "# TYPE sockchat_parse_errors_total counter\n"); n != 1 {
+		t.Errorf("got %d TYPE lines for one family", n)
+	}
+
+	// The server stays up while a chat is still on it.
+	unregisterMetrics(addr, chats[0])
+	body, err = scrape(t, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(body, `profile="default"`) {
+		t.Fatal("unregistered chat is still scraped")
+	}
+
+	unregisterMetrics(addr, chats[1])
+	metricsServers.mx.Lock()
+	_, ok := metricsServers.byAddr[addr]
+	metricsServers.mx.Unlock()
+	if ok {
+		t.Fatal("server is still listed with no chats on it")
+	}
+	if _, err = scrape(t, addr); err == nil {
+		t.Fatal("server still answers with no chats on it")
+	}
+
+	// The address can be used again.
+	if err = registerMetrics(addr, chats[0], "default"); err != nil {
+		t.Fatal(err)
+	}
+	unregisterMetrics(addr, chats[0])
+}
@@ -17,7 +17,7 @@ type Message struct {
 	IsMention bool `json:"-"`
 	debug     bool `json:"-"`
 
-	pool *ChatPool
+	pool *chatPool
 }
 
 func (msg *Message) IsEdited() bool {
@@ -0,0 +1,278 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+
+	"github.com/gen2brain/beeep"
+)
+
+// How long exec and webhook backends get before they're given up on.
+const _NOTIFY_TIMEOUT = 10 * time.Second
+
+// Something worth telling the user about, like a mention.
+type Notification struct {
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	Author    string    `json:"author"`
+	Room      uint16    `json:"room"`
+	MessageID uint32    `json:"message_id"`
+	Time      time.Time `json:"time"`
+}
+
+// Shows notifications somewhere, like the desktop or the terminal.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Writes raw escape sequences to the terminal.
+// Set by the UI with SetTerminal, since it knows when writing won't garble the screen.
+type TermWriter func(seq string) error
+
+func stdoutWriter(seq string) error {
+	_, err := os.Stdout.WriteString(seq)
+	return err
+}
+
+// Desktop notifications through the OS.
+type beeepNotifier struct{}
+
+func (beeepNotifier) Notify(ctx context.Context, n Notification) error {
+	return beeep.Notify(n.Title, n.Body, "")
+}
+
+// Escape sequences for the terminal, like a bell or an OSC notification.
+type termNotifier struct {
+	term func() TermWriter
+	seq  func(n Notification) string
+}
+
+func (tn termNotifier) Notify(ctx context.Context, n Notification) error {
+	return tn.term()(tn.seq(n))
+}
+
+// Strip anything that could end an escape sequence early.
+// OSC 777 also uses semicolons as separators.
+func oscText(s string, semicolons bool) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20, r == 0x7f, r >= 0x80 && r < 0xa0:
+			return ' '
+		case r == ';' && !semicolons:
+			return ','
+		}
+		return r
+	}, s)
+}
+
+// Runs a command with the title and body added as args.
+type execNotifier struct {
+	cmd []string
+}
+
+func (en execNotifier) Notify(ctx context.Context, n Notification) error {
+	ctx, cancel := context.WithTimeout(ctx, _NOTIFY_TIMEOUT)
+	defer cancel()
+
+	args := append(slices.Clone(en.cmd[1:]), n.Title, n.Body)
+	out := &cappedBuffer{max: _HOOK_OUTPUT_MAX}
+	c := exec.CommandContext(ctx, en.cmd[0], args...)
+	c.Stdout, c.Stderr = out, out
+	c.Env = childEnv()
+	c.WaitDelay = time.Second
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("Notify command failed: %w: %s", err, out)
+	}
+
+	return nil
+}
+
+// POSTs the notification as JSON.
+// Connects directly, not through the proxy, since it's meant for local services.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (wn webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := wn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Notify webhook returned %s.", resp.Status)
+	}
+
+	return nil
+}
+
+// Sends notifications to the configured backends, unless throttling,
+// quiet hours, room settings or focus say otherwise.
+type notifier struct {
+	mx       sync.Mutex
+	cfg      config.Config
+	backends []Notifier
+	limiter  *tokenBucket
+	// Quiet hours as times since midnight. Disabled if equal.
+	quietFrom, quietTo time.Duration
+
+	// Whether the room is on screen in a focused terminal.
+	focused atomic.Bool
+	term    atomic.Pointer[TermWriter]
+
+	errLog chan<- error
+}
+
+func newNotifier(cfg config.Config, errLog chan<- error) *notifier {
+	nf := &notifier{
+		limiter: newTokenBucket(float64(cfg.Notify.PerMinute)/60, cfg.Notify.PerMinute),
+		errLog:  errLog,
+	}
+	nf.setTerminal(stdoutWriter)
+	nf.configure(cfg)
+
+	return nf
+}
+
+// Set up backends and limits from the notify section of cfg.
+// Invalid values are already caught by config validation, so they're just skipped here.
+func (nf *notifier) configure(cfg config.Config) {
+	term := func() TermWriter {
+		return *nf.term.Load()
+	}
+
+	backends := make([]Notifier, 0, len(cfg.Notify.Backends))
+	for _, b := range cfg.Notify.Backends {
+		switch b {
+		case "beeep":
+			backends = append(backends, beeepNotifier{})
+		case "bell":
+			backends = append(backends, termNotifier{term, func(Notification) string {
+				return "\a"
+			}})
+		case "osc9":
+			backends = append(backends, termNotifier{term, func(n Notification) string {
+				return fmt.Sprintf("\x1b]9;%s: %s\x07", oscText(n.Title, true), oscText(n.Body, true))
+			}})
+		case "osc777":
+			backends = append(backends, termNotifier{term, func(n Notification) string {
+				return fmt.Sprintf("\x1b]777;notify;%s;%s\x07", oscText(n.Title, false), oscText(n.Body, false))
+			}})
+		case "exec":
+			if cmd := strings.Fields(cfg.Notify.Command); len(cmd) > 0 {
+				backends = append(backends, execNotifier{cmd})
+			}
+		case "webhook":
+			backends = append(backends, webhookNotifier{cfg.Notify.Webhook, &http.Client{Timeout: _NOTIFY_TIMEOUT}})
+		}
+	}
+
+	var from, to time.Duration
+	if cfg.Notify.QuietHours != "" {
+		from, to, _ = config.ParseQuietHours(cfg.Notify.QuietHours)
+	}
+
+	nf.mx.Lock()
+	defer nf.mx.Unlock()
+
+	nf.cfg = cfg
+	nf.backends = backends
+	nf.quietFrom, nf.quietTo = from, to
+	nf.limiter.set(float64(cfg.Notify.PerMinute)/60, cfg.Notify.PerMinute)
+}
+
+func (nf *notifier) setTerminal(w TermWriter) {
+	nf.term.Store(&w)
+}
+
+// Check if t falls in the quiet hours. Must be called with nf.mx held.
+func (nf *notifier) quiet(t time.Time) bool {
+	if nf.quietFrom == nf.quietTo {
+		return false
+	}
+
+	y, m, d := t.Date()
+	now := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if nf.quietFrom < nf.quietTo {
+		return now >= nf.quietFrom && now < nf.quietTo
+	}
+	// Wraps past midnight.
+	return now >= nf.quietFrom || now < nf.quietTo
+}
+
+// Get the backends to send n to. Returns nil if it should be skipped.
+func (nf *notifier) route(n Notification) []Notifier {
+	nf.mx.Lock()
+	defer nf.mx.Unlock()
+
+	cfg := nf.cfg.Notify
+	switch {
+	case !cfg.Enabled:
+		return nil
+	case len(cfg.Rooms) > 0 && !slices.Contains(cfg.Rooms, uint(n.Room)):
+		return nil
+	case cfg.SuppressFocused && nf.focused.Load():
+		return nil
+	case nf.quiet(time.Now()):
+		return nil
+	}
+
+	// Checked last so skipped notifications don't use up tokens.
+	if _, ok := nf.limiter.take(); !ok {
+		return nil
+	}
+
+	return nf.backends
+}
+
+// Send n to every backend in the background.
+func (nf *notifier) notify(ctx context.Context, n Notification) {
+	for _, b := range nf.route(n) {
+		go func() {
+			if err := b.Notify(ctx, n); err != nil && !errors.Is(err, context.Canceled) {
+				select {
+				case <-ctx.Done():
+				case nf.errLog <- err:
+				}
+			}
+		}()
+	}
+}
+
+// Set whether the room is on screen in a focused terminal.
+// Notifications are skipped while it is, if notify.suppress_when_focused is enabled.
+func (c *Chat) SetFocused(focused bool) {
+	c.notifier.focused.Store(focused)
+}
+
+// Set how escape sequences for the bell and OSC backends are written to the terminal.
+// Defaults to writing to stdout.
+func (c *Chat) SetTerminal(w TermWriter) {
+	c.notifier.setTerminal(w)
+}
@@ -0,0 +1,151 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+)
+
+func TestNotifierQuiet(t *testing.T) {
+	at := func(h, m int) time.Time {
+		// Not UTC, to check the time of day is taken in t's zone.
+		return time.Date(2024, 3, 1, h, m, 0, 0, time.FixedZone("UTC+5", 5*60*60))
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Duration
+		t        time.Time
+		want     bool
+	}{
+		{"same day before", 9 * time.Hour, 17 * time.Hour, at(8, 59), false},
+		{"same day start", 9 * time.Hour, 17 * time.Hour, at(9, 0), true},
+		{"same day inside", 9 * time.Hour, 17 * time.Hour, at(12, 30), true},
+		{"same day end", 9 * time.Hour, 17 * time.Hour, at(17, 0), false},
+		{"same day after", 9 * time.Hour, 17 * time.Hour, at(23, 0), false},
+		{"midnight before", 23 * time.Hour, 7 * time.Hour, at(22, 59), false},
+		{"midnight start", 23 * time.Hour, 7 * time.Hour, at(23, 0), true},
+		{"midnight at midnight", 23 * time.Hour, 7 * time.Hour, at(0, 0), true},
+		{"midnight morning", 23 * time.Hour, 7 * time.Hour, at(6, 59), true},
+		{"midnight end", 23 * time.Hour, 7 * time.Hour, at(7, 0), false},
+		{"midnight afternoon", 23 * time.Hour, 7 * time.Hour, at(12, 0), false},
+		{"from equals to", 8 * time.Hour, 8 * time.Hour, at(8, 0), false},
+		{"disabled", 0, 0, at(0, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nf := &notifier{quietFrom: tt.from, quietTo: tt.to}
+			if got := nf.quiet(tt.t); got != tt.want {
+				t.Fatalf("quiet(%s) = %v, want %v", tt.t.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+// Records notifications on a chan.
+type fakeNotifier struct {
+	got chan Notification
+	err error
+}
+
+func (fn *fakeNotifier) Notify(ctx context.Context, n Notification) error {
+	fn.got <- n
+	return fn.err
+}
+
+func TestNotifierRoute(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(cfg *config.Config)
+		// Whether the room is on screen.
+		focused bool
+		// Rooms of the notifications sent in a row.
+		rooms []uint16
+		want  int
+	}{
+		{name: "sends", rooms: []uint16{1, 2, 3}, want: 3},
+		{name: "disabled", edit: func(cfg *config.Config) { cfg.Notify.Enabled = false }, rooms: []uint16{1}, want: 0},
+		{name: "per minute limit", edit: func(cfg *config.Config) { cfg.Notify.PerMinute = 2 }, rooms: []uint16{1, 1, 1, 1}, want: 2},
+		{name: "no limit", edit: func(cfg *config.Config) { cfg.Notify.PerMinute = 0 }, rooms: []uint16{1, 1, 1, 1, 1, 1}, want: 6},
+		{name: "room filter", edit: func(cfg *config.Config) { cfg.Notify.Rooms = []uint{1, 3} }, rooms: []uint16{1, 2, 3, 4}, want: 2},
+		// Skipped notifications must not use up the limit.
+		{name: "room filter with limit", edit: func(cfg *config.Config) {
+			cfg.Notify.Rooms = []uint{1}
+			cfg.Notify.PerMinute = 1
+		}, rooms: []uint16{2, 2, 1}, want: 1},
+		{name: "focused", edit: func(cfg *config.Config) { cfg.Notify.SuppressFocused = true }, focused: true, rooms: []uint16{1}, want: 0},
+		{name: "focused without suppress", focused: true, rooms: []uint16{1}, want: 1},
+		{name: "suppress while unfocused", edit: func(cfg *config.Config) { cfg.Notify.SuppressFocused = true }, rooms: []uint16{1}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.Notify.Enabled = true
+			cfg.Notify.Backends = nil
+			cfg.Notify.QuietHours = ""
+			cfg.Notify.PerMinute = 10
+			cfg.Notify.SuppressFocused = false
+			if tt.edit != nil {
+				tt.edit(&cfg)
+			}
+
+			errLog := make(chan error, len(tt.rooms))
+			nf := newNotifier(cfg, errLog)
+			fn := &fakeNotifier{got: make(chan Notification, len(tt.rooms))}
+			nf.backends = []Notifier{fn}
+			nf.focused.Store(tt.focused)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			for i, r := range tt.rooms {
+				nf.notify(ctx, Notification{Room: r, MessageID: uint32(i)})
+			}
+
+			for range tt.want {
+				select {
+				case n := <-fn.got:
+					if len(cfg.Notify.Rooms) > 0 && !slices.Contains(cfg.Notify.Rooms, uint(n.Room)) {
+						t.Fatalf("got a notification for room %d", n.Room)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("got fewer than %d notifications", tt.want)
+				}
+			}
+			select {
+			case n := <-fn.got:
+				t.Fatalf("got more than %d notifications: %+v", tt.want, n)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestNotifierBackendError(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Notify.Enabled = true
+	cfg.Notify.QuietHours = ""
+
+	errLog := make(chan error, 1)
+	nf := newNotifier(cfg, errLog)
+	fn := &fakeNotifier{got: make(chan Notification, 1), err: errors.New("Backend failed.")}
+	nf.backends = []Notifier{fn}
+
+	nf.notify(context.Background(), Notification{Title: "Mention"})
+	if n := <-fn.got; n.Title != "Mention" {
+		t.Fatalf("got %+v", n)
+	}
+	select {
+	case err := <-errLog:
+		if err != fn.err {
+			t.Fatalf("got %v, want %v", err, fn.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend error wasn't logged")
+	}
+}
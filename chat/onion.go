@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"y-a-t-s/sockchat/config"
+)
+
+const _ONION_PINS_FILE = "onion_pins.json"
+
+// Guards the pins file, which is shared by all profiles.
+var pinsMx sync.Mutex
+
+func pinsPath() (string, error) {
+	cfgDir, err := config.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cfgDir, _ONION_PINS_FILE), nil
+}
+
+// Onion addresses previously trusted, keyed by clearnet host.
+// Must be called with pinsMx held.
+func loadPins() (map[string]string, error) {
+	pins := make(map[string]string, 1)
+
+	path, err := pinsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return pins, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, &pins); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %w", path, err)
+	}
+
+	return pins, nil
+}
+
+// Trust onion as the address for host from now on.
+func pinOnion(host string, onion string) error {
+	pinsMx.Lock()
+	defer pinsMx.Unlock()
+
+	pins, err := loadPins()
+	if err != nil {
+		return err
+	}
+	pins[host] = onion
+
+	path, err := pinsPath()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(pins, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0600)
+}
+
+// Normalize an onion address from the config for comparison.
+func onionHost(addr string) (string, error) {
+	u, err := parseHost(addr)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(u.Hostname()), nil
+}
+
+// Make sure the onion address in cfg can be trusted before connecting to it.
+// The first address used for a host is pinned. The default host starts out pinned to the built-in address.
+// A different address than the pinned one is refused until the user trusts it.
+func verifyOnion(cfg config.Config, info chan<- string) error {
+	if err := config.ValidateOnion(cfg.Tor.Onion); err != nil {
+		return &config.ErrConfigValue{Key: "tor.onion_host", Value: cfg.Tor.Onion, Reason: err.Error()}
+	}
+
+	onion, err := onionHost(cfg.Tor.Onion)
+	if err != nil {
+		return err
+	}
+	host, err := onionHost(cfg.Host)
+	if err != nil {
+		return err
+	}
+
+	pinsMx.Lock()
+	pins, err := loadPins()
+	pinsMx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	pinned, ok := pins[host]
+	if !ok {
+		// The address built into the client is known-good for the default host.
+		def := config.NewConfig()
+		defHost, _ := onionHost(def.Host)
+		if host == defHost {
+			pinned, err = onionHost(def.Tor.Onion)
+			ok = err == nil
+		}
+	}
+
+	switch {
+	case !ok:
+		info <- fmt.Sprintf("Pinned onion address for %s: %s\nYou'll be warned if it changes.", host, onion)
+		return pinOnion(host, onion)
+	case pinned != onion:
+		return &ErrOnionChanged{host, pinned, onion}
+	}
+
+	return nil
+}
+
+// Accept the onion address in the config as the new pin, after ErrOnionChanged.
+// Wakes the reconnect loop so it tries again right away.
+func (c *Chat) TrustOnion() error {
+	cfg := c.Config()
+	onion, err := onionHost(cfg.Tor.Onion)
+	if err != nil {
+		return err
+	}
+	host, err := onionHost(cfg.Host)
+	if err != nil {
+		return err
+	}
+
+	if err = pinOnion(host, onion); err != nil {
+		return err
+	}
+	c.infoLog <- fmt.Sprintf("Now trusting %s for %s.", onion, host)
+	c.wakeReconnect()
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+
+	"y-a-t-s/sockchat/config"
+)
+
+func TestVerifyOnion(t *testing.T) {
+	def := config.NewConfig()
+	// Valid addresses other than the built-in one.
+	const (
+		other   = "2gzyxa5ihm7nsggfxnu52rck2vv4rvmdlkiu3zzui5du4xyclen53wid.onion"
+		another = "p53lf57qovyuvwsc6xnrppyply3vtqm7l6pcobkmyqsiofyeznfu5uqd.onion"
+	)
+
+	tests := []struct {
+		name string
+		// Onion addresses used in order, for the host.
+		host   string
+		onions []string
+		// Set if the last one must be refused for not matching the pin.
+		wantChanged bool
+		// Set if the last one must be refused for not being a valid address.
+		wantInvalid bool
+	}{
+		{name: "default", host: def.Host, onions: []string{def.Tor.Onion}},
+		{name: "default changed", host: def.Host, onions: []string{other}, wantChanged: true},
+		{name: "first use pins", host: "example.com", onions: []string{other, other}},
+		{name: "pinned changed", host: "example.com", onions: []string{other, another}, wantChanged: true},
+		{name: "invalid", host: "example.com", onions: []string{"example.onion"}, wantInvalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("HOME", t.TempDir())
+
+			info := make(chan string, len(tt.onions))
+			var err error
+			for _, o := range tt.onions {
+				cfg := config.NewConfig()
+				cfg.Host = tt.host
+				cfg.Tor.Onion = o
+				if err = verifyOnion(cfg, info); err != nil {
+					break
+				}
+			}
+
+			var changed *ErrOnionChanged
+			var cv *config.ErrConfigValue
+			switch {
+			case tt.wantChanged:
+				if !errors.As(err, &changed) {
+					t.Fatalf("got %v, want ErrOnionChanged", err)
+				}
+			case tt.wantInvalid:
+				if !errors.As(err, &cv) {
+					t.Fatalf("got %v, want ErrConfigValue", err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+)
+
+type OutboxMsg struct {
+	ID     uint      `json:"id"`
+	Text   string    `json:"text"`
+	Queued time.Time `json:"queued"`
+}
+
+// On-disk store for msgs composed while the socket is down.
+// Msgs are flushed to the send queue in order after reconnecting,
+// and stay in the file until they're written to the socket.
+type outbox struct {
+	mx   sync.Mutex
+	path string
+	msgs []OutboxMsg
+	// Msgs flushed to the send queue that weren't written yet.
+	// Saved ahead of msgs, so they're sent again after a crash.
+	sending []OutboxMsg
+	nextID  uint
+
+	updates chan int
+}
+
+func outboxPath(cfg config.Config) (string, error) {
+	cfgDir, err := config.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cfgDir, fmt.Sprintf("outbox%s.json", cfg.ProfileSuffix())), nil
+}
+
+// Load the outbox left over from previous sessions, if any.
+// Each profile has its own outbox.
+func loadOutbox(cfg config.Config) (*outbox, error) {
+	path, err := outboxPath(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ob := &outbox{
+		path:    path,
+		msgs:    make([]OutboxMsg, 0, 8),
+		nextID:  1,
+		updates: make(chan int, 1),
+	}
+
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return ob, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, &ob.msgs); err != nil {
+		return nil, err
+	}
+	for _, m := range ob.msgs {
+		if m.ID >= ob.nextID {
+			ob.nextID = m.ID + 1
+		}
+	}
+	sendLatest(ob.updates, len(ob.msgs))
+
+	return ob, nil
+}
+
+// Must be called with ob.mx held.
+func (ob *outbox) save() error {
+	defer sendLatest(ob.updates, len(ob.msgs))
+
+	all := append(slices.Clone(ob.sending), ob.msgs...)
+	if len(all) == 0 {
+		err := os.Remove(ob.path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.MarshalIndent(all, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// Msgs may be private, so keep the file readable by the user only.
+	return os.WriteFile(ob.path, b, 0600)
+}
+
+func (ob *outbox) add(text string) error {
+	ob.mx.Lock()
+	defer ob.mx.Unlock()
+
+	ob.msgs = append(ob.msgs, OutboxMsg{
+		ID:     ob.nextID,
+		Text:   text,
+		Queued: time.Now(),
+	})
+	ob.nextID++
+
+	return ob.save()
+}
+
+// Get a copy of the msgs currently in the outbox.
+func (ob *outbox) List() []OutboxMsg {
+	ob.mx.Lock()
+	defer ob.mx.Unlock()
+
+	return append([]OutboxMsg(nil), ob.msgs...)
+}
+
+func (ob *outbox) Edit(id uint, text string) error {
+	ob.mx.Lock()
+	defer ob.mx.Unlock()
+
+	for i := range ob.msgs {
+		if ob.msgs[i].ID == id {
+			ob.msgs[i].Text = text
+			return ob.save()
+		}
+	}
+
+	return fmt.Errorf("No msg with ID %d in outbox.", id)
+}
+
+func (ob *outbox) Cancel(id uint) error {
+	ob.mx.Lock()
+	defer ob.mx.Unlock()
+
+	for i := range ob.msgs {
+		if ob.msgs[i].ID == id {
+			ob.msgs = append(ob.msgs[:i], ob.msgs[i+1:]...)
+			return ob.save()
+		}
+	}
+
+	return fmt.Errorf("No msg with ID %d in outbox.", id)
+}
+
+func (ob *outbox) Clear() error {
+	ob.mx.Lock()
+	defer ob.mx.Unlock()
+
+	ob.msgs = ob.msgs[:0]
+	return ob.save()
+}
+
+// Remove and return all msgs in the order they were queued, to be sent.
+// They're kept in the file until sent is called for each.
+func (ob *outbox) drain() ([]OutboxMsg, error) {
+	ob.mx.Lock()
+	defer ob.mx.Unlock()
+
+	msgs := ob.msgs
+	ob.msgs = make([]OutboxMsg, 0, 8)
+	ob.sending = append(ob.sending, msgs...)
+
+	return msgs, ob.save()
+}
+
+// Drop the first msg being sent with the given text, once it's written to the socket.
+// Msgs typed again while the outbox is flushed may take an identical one's place,
+// which is fine since the text was still sent.
+func (ob *outbox) sent(text string) error {
+	ob.mx.Lock()
+	defer ob.mx.Unlock()
+
+	i := slices.IndexFunc(ob.sending, func(m OutboxMsg) bool {
+		return m.Text == text
+	})
+	if i < 0 {
+		return nil
+	}
+	ob.sending = slices.Delete(ob.sending, i, i+1)
+
+	return ob.save()
+}
+
+// Put unsent msgs left in the send queue back in the outbox, ahead of anything added since.
+// Ones that came from the outbox keep their ID and time.
+func (ob *outbox) requeue(texts []string) error {
+	ob.mx.Lock()
+	defer ob.mx.Unlock()
+
+	msgs := make([]OutboxMsg, 0, len(texts)+len(ob.msgs))
+	for _, text := range texts {
+		i := slices.IndexFunc(ob.sending, func(m OutboxMsg) bool {
+			return m.Text == text
+		})
+		if i >= 0 {
+			msgs = append(msgs, ob.sending[i])
+			ob.sending = slices.Delete(ob.sending, i, i+1)
+			continue
+		}
+
+		msgs = append(msgs, OutboxMsg{
+			ID:     ob.nextID,
+			Text:   text,
+			Queued: time.Now(),
+		})
+		ob.nextID++
+	}
+	// Anything else never made it out either.
+	ob.msgs = append(append(ob.sending, msgs...), ob.msgs...)
+	ob.sending = nil
+
+	return ob.save()
+}
+
+// Receives the number of msgs in the outbox whenever it changes.
+func (ob *outbox) Updates() <-chan int {
+	return ob.updates
+}
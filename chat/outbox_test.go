@@ -0,0 +1,164 @@
+package chat
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"y-a-t-s/sockchat/config"
+)
+
+func TestFormatOutgoing(t *testing.T) {
+	green := "[color=" + GREEN + "]"
+
+	tests := []struct {
+		in, want string
+	}{
+		{"hello", "hello"},
+		{"  hello  ", "hello"},
+		{">implying", green + ">implying"},
+		{"> spaced", "> spaced"},
+		{"not >green", "not >green"},
+		// Already formatted text must not be formatted again.
+		{green + ">implying", green + ">implying"},
+	}
+
+	for _, tt := range tests {
+		if got := formatOutgoing(tt.in); got != tt.want {
+			t.Errorf("formatOutgoing(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Outbox msgs are stored as typed and only formatted when written.
+func TestFlushOutboxKeepsRawText(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	ob, err := loadOutbox(config.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{">implying", "plain"}
+	for _, m := range want {
+		if err = ob.add(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &sock{
+		Outbox:  ob,
+		Queue:   newOutQueue(0),
+		errLog:  make(chan error, 1),
+		infoLog: make(chan string, 1),
+	}
+	s.flushOutbox()
+
+	if got := s.Queue.drain(); !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if n := len(ob.List()); n != 0 {
+		t.Fatalf("%d msgs left in the outbox", n)
+	}
+}
+
+// Flushed msgs stay in the file until they're written, so a crash doesn't lose them.
+func TestOutboxKeepsUnsentMsgs(t *testing.T) {
+	tests := []struct {
+		name string
+		// Called after a and b are added and flushed.
+		after func(ob *outbox) error
+		want  []string
+	}{
+		{
+			name:  "nothing written",
+			after: func(ob *outbox) error { return nil },
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "one written",
+			after: func(ob *outbox) error { return ob.sent("a") },
+			want:  []string{"b"},
+		},
+		{
+			name: "all written",
+			after: func(ob *outbox) error {
+				return errors.Join(ob.sent("a"), ob.sent("b"))
+			},
+		},
+		{
+			name:  "written msg not from the outbox",
+			after: func(ob *outbox) error { return ob.sent("c") },
+			want:  []string{"a", "b"},
+		},
+		{
+			name: "stopped with msgs queued",
+			after: func(ob *outbox) error {
+				return errors.Join(ob.sent("a"), ob.add("added while closed"), ob.requeue([]string{"b", "typed"}))
+			},
+			want: []string{"b", "typed", "added while closed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("HOME", t.TempDir())
+
+			ob, err := loadOutbox(config.NewConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = errors.Join(ob.add("a"), ob.add("b")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = ob.drain(); err != nil {
+				t.Fatal(err)
+			}
+			if err = tt.after(ob); err != nil {
+				t.Fatal(err)
+			}
+
+			// Loaded again, like after a restart.
+			loaded, err := loadOutbox(config.NewConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range loaded.List() {
+				got = append(got, m.Text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Msgs put back from the queue keep the ID they had in the outbox.
+func TestOutboxRequeueKeepsIDs(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	ob, err := loadOutbox(config.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = errors.Join(ob.add("a"), ob.add("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ob.drain(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ob.requeue([]string{"typed", "a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []uint
+	for _, m := range ob.List() {
+		got = append(got, m.ID)
+	}
+	if want := []uint{3, 1, 2}; !slices.Equal(got, want) {
+		t.Fatalf("got IDs %v, want %v", got, want)
+	}
+}
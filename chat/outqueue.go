@@ -0,0 +1,268 @@
+package chat
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+)
+
+// Token bucket used to pace outgoing msgs.
+type tokenBucket struct {
+	mx sync.Mutex
+
+	rate   float64 // Tokens added per second.
+	burst  float64 // Max tokens held at once.
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst uint) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Block until a token is available, then take it.
+// A nil bucket or one with a non-positive rate never blocks.
+func (tb *tokenBucket) wait(ctx context.Context) error {
+	if tb == nil {
+		return ctx.Err()
+	}
+
+	for {
+		d, ok := tb.take()
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// Take a token if one is available.
+// Otherwise, returns the time until the next whole token is.
+func (tb *tokenBucket) take() (time.Duration, bool) {
+	tb.mx.Lock()
+	defer tb.mx.Unlock()
+
+	if tb.rate <= 0 {
+		return 0, true
+	}
+
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0, true
+	}
+
+	return time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second)), false
+}
+
+// Change the rate and burst size, such as after a config reload.
+// A non-positive rate disables limiting.
+func (tb *tokenBucket) set(rate float64, burst uint) {
+	tb.mx.Lock()
+	defer tb.mx.Unlock()
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	tb.rate = rate
+	tb.burst = float64(burst)
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+// FIFO of msgs waiting to be written to the socket.
+type outQueue struct {
+	mx   sync.Mutex
+	msgs []string
+
+	// Time each msg text was last queued. Used for coalescing duplicates.
+	seen   map[string]time.Time
+	window time.Duration
+
+	// Signals new msgs in the queue.
+	ready chan struct{}
+	// Receives the queue length whenever it changes. Only the latest value is kept.
+	updates chan int
+}
+
+func newOutQueue(window time.Duration) *outQueue {
+	return &outQueue{
+		msgs:    make([]string, 0, 8),
+		seen:    make(map[string]time.Time, 8),
+		window:  window,
+		ready:   make(chan struct{}, 1),
+		updates: make(chan int, 1),
+	}
+}
+
+// Send n to a chan holding only the latest value, replacing any stale one.
+func sendLatest(ch chan int, n int) {
+	for {
+		select {
+		case ch <- n:
+			return
+		default:
+		}
+
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// Must be called with q.mx held.
+func (q *outQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	sendLatest(q.updates, len(q.msgs))
+}
+
+// Change how long identical msgs are coalesced for. 0 disables coalescing.
+func (q *outQueue) setWindow(window time.Duration) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.window = window
+}
+
+// Add msg to the back of the queue.
+// Returns false if an identical msg was queued within the coalescing window.
+func (q *outQueue) push(msg string) bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	now := time.Now()
+	if q.window > 0 {
+		for k, t := range q.seen {
+			if now.Sub(t) > q.window {
+				delete(q.seen, k)
+			}
+		}
+		if _, ok := q.seen[msg]; ok {
+			return false
+		}
+		q.seen[msg] = now
+	}
+
+	q.msgs = append(q.msgs, msg)
+	q.notify()
+
+	return true
+}
+
+// Add msg to the front of the queue, skipping the line.
+// Used for /join msgs, which must go out before anything meant for the room.
+func (q *outQueue) pushFront(msg string) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.msgs = append([]string{msg}, q.msgs...)
+	q.notify()
+}
+
+// Add msgs to the back of the queue without coalescing.
+// Used when flushing the outbox, since those msgs were deliberately written by the user.
+func (q *outQueue) append(msgs ...string) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.msgs = append(q.msgs, msgs...)
+	q.notify()
+}
+
+// Remove and return everything left in the queue.
+func (q *outQueue) drain() []string {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	msgs := q.msgs
+	q.msgs = make([]string, 0, 8)
+	q.notify()
+
+	return msgs
+}
+
+func (q *outQueue) peek() (string, bool) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if len(q.msgs) == 0 {
+		return "", false
+	}
+	return q.msgs[0], true
+}
+
+func (q *outQueue) pop() {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if len(q.msgs) == 0 {
+		return
+	}
+	q.msgs = q.msgs[1:]
+	q.notify()
+}
+
+// Number of msgs waiting to be sent.
+func (q *outQueue) Len() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	return len(q.msgs)
+}
+
+// Receives the number of pending msgs whenever it changes.
+func (q *outQueue) Updates() <-chan int {
+	return q.updates
+}
+
+// Rate of the limiter for cfg. 0 if limiting is disabled.
+func limiterRate(cfg config.Config) float64 {
+	if !cfg.RateLimit.Enabled {
+		return 0
+	}
+
+	return cfg.RateLimit.PerSecond
+}
+
+// A disabled limiter is still returned so it can be enabled later on config reload.
+func newLimiter(cfg config.Config) *tokenBucket {
+	return newTokenBucket(limiterRate(cfg), cfg.RateLimit.Burst)
+}
+
+// Window for coalescing identical outgoing msgs. 0 if rate limiting is disabled.
+func coalesceWindow(cfg config.Config) time.Duration {
+	if !cfg.RateLimit.Enabled {
+		return 0
+	}
+
+	return time.Duration(cfg.RateLimit.CoalesceWindow * float64(time.Second))
+}
@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOutQueueCoalesce(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		push   []string
+		want   []string
+	}{
+		{"disabled", 0, []string{"a", "a", "b"}, []string{"a", "a", "b"}},
+		{"duplicates", time.Minute, []string{"a", "a", "b", "a"}, []string{"a", "b"}},
+		{"distinct", time.Minute, []string{"a", "b", "c"}, []string{"a", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newOutQueue(tt.window)
+			for _, msg := range tt.push {
+				q.push(msg)
+			}
+			if got := q.drain(); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutQueueOrder(t *testing.T) {
+	q := newOutQueue(0)
+	q.push("hello")
+	q.pushFront("/join 2")
+	q.append("from outbox")
+
+	if msg, ok := q.peek(); !ok || msg != "/join 2" {
+		t.Fatalf("peek = %q, %v, want the join first", msg, ok)
+	}
+	// Peeking leaves the msg queued until it's popped.
+	if q.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", q.Len())
+	}
+	q.pop()
+	if got, want := q.drain(), []string{"hello", "from outbox"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	tb := newTokenBucket(1, 2)
+	for i := 0; i < 2; i++ {
+		if _, ok := tb.take(); !ok {
+			t.Fatalf("take %d failed within the burst", i)
+		}
+	}
+	if d, ok := tb.take(); ok || d <= 0 || d > time.Second {
+		t.Errorf("take past the burst = %s, %v", d, ok)
+	}
+
+	tb.set(0, 1)
+	if _, ok := tb.take(); !ok {
+		t.Error("disabled bucket blocked")
+	}
+}
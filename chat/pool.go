@@ -4,13 +4,13 @@ import (
 	"sync"
 )
 
-type ChatPool struct {
+type chatPool struct {
 	msg  sync.Pool
 	user sync.Pool
 }
 
-func newChatPool() *ChatPool {
-	return &ChatPool{
+func newChatPool() *chatPool {
+	return &chatPool{
 		msg: sync.Pool{
 			New: func() any {
 				return new(Message)
@@ -24,7 +24,7 @@ func newChatPool() *ChatPool {
 	}
 }
 
-func (p *ChatPool) NewMsg() *Message {
+func (p *chatPool) NewMsg() *Message {
 	msg := p.msg.Get().(*Message)
 	// Remove author pointer to prevent clearing underlying User record.
 	msg.Author = nil
@@ -36,7 +36,7 @@ func (p *ChatPool) NewMsg() *Message {
 	return msg
 }
 
-func (p *ChatPool) NewUser() *User {
+func (p *chatPool) NewUser() *User {
 	u := p.user.Get().(*User)
 	*u = User{
 		pool: p,
@@ -44,7 +44,7 @@ func (p *ChatPool) NewUser() *User {
 	return u
 }
 
-func (p *ChatPool) Release(cd any) {
+func (p *chatPool) Release(cd any) {
 	switch cd.(type) {
 	case *Message:
 		p.msg.Put(cd)
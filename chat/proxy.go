@@ -1,25 +1,44 @@
 package chat
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
+	"net/textproto"
 	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 	"y-a-t-s/sockchat/config"
 
+	"github.com/cretz/bine/control"
 	"github.com/cretz/bine/tor"
 	"golang.org/x/net/proxy"
 )
 
-type socksProxy struct {
+// Timeout for reaching a system Tor daemon before falling back to the embedded one.
+const _TOR_DIAL_TIMEOUT = 5 * time.Second
+
+// Routes conns through a SOCKS5 or HTTP CONNECT proxy, or Tor.
+type proxyDialer struct {
 	proxy.ContextDialer
 
 	url *url.URL
 	tor *tor.Tor
+	// Set when using a Tor daemon that's already running instead of the embedded one.
+	system bool
+	// Control conn to the system Tor daemon, if one is configured.
+	ctl *control.Conn
 }
 
 // user and pass may be left empty if credentials are supplied in addr.
+// Schemes can be socks5, socks5h, http or https. Defaults to socks5 if there isn't one.
 func parseProxyAddr(addr string, user string, pass string) (*url.URL, error) {
 	// Fallback to socks5 if no protocol is given.
 	if !strings.Contains(addr, "://") {
@@ -42,7 +61,7 @@ func parseProxyAddr(addr string, user string, pass string) (*url.URL, error) {
 	return u, nil
 }
 
-func newSocksDialer(cfg config.Config) (p *socksProxy, err error) {
+func newProxyDialer(cfg config.Config) (p *proxyDialer, err error) {
 	u, err := parseProxyAddr(cfg.Proxy.Addr, cfg.Proxy.User, cfg.Proxy.Pass)
 	if err != nil {
 		return
@@ -53,33 +72,298 @@ func newSocksDialer(cfg config.Config) (p *socksProxy, err error) {
 		return
 	}
 
-	p = &socksProxy{
+	p = &proxyDialer{
 		ContextDialer: d.(proxy.ContextDialer),
 		url:           u,
 	}
 	return
 }
 
-func startTor(ctx context.Context) (p *socksProxy, err error) {
-	log.Println("Connecting to Tor network...")
+// Connect through Tor.
+// A running Tor daemon is used if one is configured, with an embedded Tor as the fallback.
+// Progress is reported to info.
+func startTor(ctx context.Context, cfg config.Config, info chan<- string) (*proxyDialer, error) {
+	if cfg.Tor.SocksAddr != "" || cfg.Tor.ControlAddr != "" {
+		p, err := systemTor(ctx, cfg)
+		if err == nil {
+			info <- fmt.Sprintf("Using system Tor at %s.", p.url.Host)
+			return p, nil
+		}
+
+		info <- fmt.Sprintf("Failed to use system Tor: %s\nFalling back to embedded Tor.", err)
+	}
+
+	return embeddedTor(ctx, cfg, info)
+}
+
+// Use the SOCKS port of a running Tor daemon, found through its control port if needed.
+func systemTor(ctx context.Context, cfg config.Config) (p *proxyDialer, err error) {
+	var ctl *control.Conn
+	if cfg.Tor.ControlAddr != "" {
+		ctl, err = dialControl(ctx, cfg.Tor.ControlAddr, cfg.Tor.ControlPass)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				ctl.Close()
+			}
+		}()
+	}
+
+	addr := cfg.Tor.SocksAddr
+	if addr == "" {
+		addr, err = socksListener(ctl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Make sure it's actually there before committing to it.
+	nd := &net.Dialer{Timeout: _TOR_DIAL_TIMEOUT}
+	conn, err := nd.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+
+	// Tor isolates circuits by SOCKS credentials, so profiles don't share an exit.
+	auth := &proxy.Auth{User: "sockchat", Password: cfg.Profile}
+	d, err := proxy.SOCKS5("tcp", addr, auth, &net.Dialer{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyDialer{
+		ContextDialer: d.(proxy.ContextDialer),
+		url:           &url.URL{Scheme: "socks5", Host: addr},
+		system:        true,
+		ctl:           ctl,
+	}, nil
+}
+
+// Open an authenticated conn to a Tor control port.
+// addr may be a unix socket path prefixed with "unix:".
+func dialControl(ctx context.Context, addr string, pass string) (*control.Conn, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+
+	nd := &net.Dialer{Timeout: _TOR_DIAL_TIMEOUT}
+	conn, err := nd.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctl := control.NewConn(textproto.NewConn(conn))
+	if err = ctl.Authenticate(pass); err != nil {
+		ctl.Close()
+		return nil, fmt.Errorf("Tor control port authentication failed: %w", err)
+	}
+
+	return ctl, nil
+}
+
+// Ask Tor which address its SOCKS port listens on.
+func socksListener(ctl *control.Conn) (string, error) {
+	info, err := ctl.GetInfo("net/listeners/socks")
+	if err != nil {
+		return "", err
+	}
+
+	// Value is a space-separated list of quoted addresses.
+	for _, kv := range info {
+		for _, a := range strings.Fields(kv.Val) {
+			if uq, err := strconv.Unquote(a); err == nil {
+				a = uq
+			}
+			// Unix sockets aren't supported by the SOCKS dialer.
+			if !strings.HasPrefix(a, "unix:") {
+				return a, nil
+			}
+		}
+	}
+
+	return "", errors.New("Tor has no TCP SOCKS port open.")
+}
+
+// Build torrc options for the embedded Tor as command-line args.
+func torArgs(cfg config.Config) []string {
+	args := make([]string, 0, 8)
+	add := func(line string) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			return
+		}
+
+		k, v, _ := strings.Cut(line, " ")
+		args = append(args, "--"+k, strings.TrimSpace(v))
+	}
+
+	if len(cfg.Tor.Bridges) > 0 {
+		add("UseBridges 1")
+
+		transports := make([]string, 0, 1)
+		for _, b := range cfg.Tor.Bridges {
+			add("Bridge " + b)
+			if t := config.BridgeTransport(b); t != "" && !slices.Contains(transports, t) {
+				transports = append(transports, t)
+			}
+		}
+
+		if len(transports) > 0 && cfg.Tor.TransportPlugin != "" {
+			add(fmt.Sprintf("ClientTransportPlugin %s exec %s", strings.Join(transports, ","), cfg.Tor.TransportPlugin))
+		}
+	}
+
+	for _, l := range cfg.Tor.Torrc {
+		add(l)
+	}
+
+	return args
+}
+
+func embeddedTor(ctx context.Context, cfg config.Config, info chan<- string) (p *proxyDialer, err error) {
+	info <- "Starting Tor..."
 
-	ti, err := tor.Start(ctx, nil)
+	conf := &tor.StartConf{
+		ExtraArgs: torArgs(cfg),
+		// Otherwise, a temp data dir is made in the working dir.
+		TempDataDirBase: os.TempDir(),
+	}
+	if conf.DataDir, err = cfg.TorDataDir(); err != nil {
+		return
+	}
+
+	ti, err := tor.Start(ctx, conf)
 	if err != nil {
 		return
 	}
-	td, err := ti.Dialer(ctx, nil)
+	defer func() {
+		if err != nil {
+			ti.Close()
+		}
+	}()
+
+	if err = ti.EnableNetwork(ctx, false); err != nil {
+		return
+	}
+	if err = waitBootstrap(ctx, ti.Control, info); err != nil {
+		return
+	}
+
+	td, err := ti.Dialer(ctx, &tor.DialConf{SkipEnableNetwork: true})
 	if err != nil {
 		return
 	}
 
-	p = &socksProxy{
+	p = &proxyDialer{
 		ContextDialer: td.Dialer.(proxy.ContextDialer),
 		tor:           ti,
 	}
 	return
 }
 
-func (p *socksProxy) stopTor() {
+var (
+	bootstrapProgressRE = regexp.MustCompile(`PROGRESS=(\d+)`)
+	bootstrapSummaryRE  = regexp.MustCompile(`SUMMARY="([^"]*)"`)
+	bootstrapWarningRE  = regexp.MustCompile(`WARNING="([^"]*)"`)
+)
+
+const (
+	// How often bootstrap progress is checked.
+	_BOOTSTRAP_POLL = 500 * time.Millisecond
+	// How long Tor gets to bootstrap. Slow bridges can take a few minutes.
+	_BOOTSTRAP_TIMEOUT = 5 * time.Minute
+)
+
+// Report Tor's bootstrap progress to info until it's done.
+// Gives up after _BOOTSTRAP_TIMEOUT, closing ctl so a stuck request doesn't hang.
+func waitBootstrap(ctx context.Context, ctl *control.Conn, info chan<- string) error {
+	ctx, cancel := context.WithTimeout(ctx, _BOOTSTRAP_TIMEOUT)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		ctl.Close()
+	})
+	defer stop()
+
+	t := time.NewTicker(_BOOTSTRAP_POLL)
+	defer t.Stop()
+
+	var prevProgress, prevWarning string
+	timedOut := func() error {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("Tor didn't finish bootstrapping within %s (stuck at %s%%): %w",
+			_BOOTSTRAP_TIMEOUT, cmp.Or(prevProgress, "0"), ctx.Err())
+	}
+
+	for {
+		kvs, err := ctl.GetInfo("status/bootstrap-phase")
+		if err != nil {
+			if ctx.Err() != nil {
+				return timedOut()
+			}
+			return err
+		}
+
+		var phase string
+		if len(kvs) > 0 {
+			phase = kvs[0].Val
+		}
+
+		if m := bootstrapWarningRE.FindStringSubmatch(phase); m != nil && m[1] != prevWarning {
+			prevWarning = m[1]
+			info <- fmt.Sprintf("Tor warning: %s", m[1])
+		}
+
+		if m := bootstrapProgressRE.FindStringSubmatch(phase); m != nil && m[1] != prevProgress {
+			prevProgress = m[1]
+
+			summary := ""
+			if sm := bootstrapSummaryRE.FindStringSubmatch(phase); sm != nil {
+				summary = fmt.Sprintf(" (%s)", sm[1])
+			}
+			info <- fmt.Sprintf("Tor bootstrap: %s%%%s", m[1], summary)
+
+			if m[1] == "100" {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return timedOut()
+		case <-t.C:
+		}
+	}
+}
+
+func (p *proxyDialer) isTor() bool {
+	return p != nil && (p.tor != nil || p.system)
+}
+
+// Ask Tor to use new circuits for new conns.
+func (p *proxyDialer) newnym() error {
+	ctl := p.ctl
+	if p.tor != nil {
+		ctl = p.tor.Control
+	}
+	if ctl == nil {
+		return errors.New("No Tor control port available. Set tor.control_address to use a new identity with system Tor.")
+	}
+
+	return ctl.Signal("NEWNYM")
+}
+
+func (p *proxyDialer) stopTor() {
+	if p.ctl != nil {
+		p.ctl.Close()
+		p.ctl = nil
+	}
+
 	if p.tor != nil {
 		log.Println("Stopping Tor.")
 
@@ -0,0 +1,114 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cretz/bine/control"
+)
+
+// Fake Tor control port answering bootstrap-phase requests with phases in order, repeating the last one.
+// If hang is set, it stops answering after the phases.
+func fakeTorControl(t *testing.T, phases []string, hang bool) *control.Conn {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	go func() {
+		br := bufio.NewReader(server)
+		for i := 0; ; i++ {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(line, "GETINFO status/bootstrap-phase") {
+				fmt.Fprint(server, "510 Unrecognized command\r\n")
+				continue
+			}
+			if i >= len(phases) {
+				if hang {
+					continue
+				}
+				i = len(phases) - 1
+			}
+			fmt.Fprintf(server, "250-status/bootstrap-phase=%s\r\n250 OK\r\n", phases[i])
+		}
+	}()
+
+	return control.NewConn(textproto.NewConn(client))
+}
+
+func TestWaitBootstrap(t *testing.T) {
+	const (
+		conn  = `NOTICE BOOTSTRAP PROGRESS=10 TAG=conn_done SUMMARY="Connected to a relay"`
+		warn  = `WARN BOOTSTRAP PROGRESS=10 TAG=conn_done SUMMARY="Connected to a relay" WARNING="Connection refused"`
+		done  = `NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY="Done"`
+		stuck = `NOTICE BOOTSTRAP PROGRESS=50 TAG=loading_descriptors SUMMARY="Loading relay descriptors"`
+	)
+
+	tests := []struct {
+		name     string
+		phases   []string
+		hang     bool
+		wantInfo []string
+		wantErr  error
+	}{
+		{
+			name:     "done",
+			phases:   []string{conn, conn, warn, done},
+			wantInfo: []string{"Tor bootstrap: 10% (Connected to a relay)", "Tor warning: Connection refused", "Tor bootstrap: 100% (Done)"},
+		},
+		{
+			name:     "stuck",
+			phases:   []string{conn, stuck},
+			wantInfo: []string{"Tor bootstrap: 10% (Connected to a relay)", "Tor bootstrap: 50% (Loading relay descriptors)"},
+			wantErr:  context.DeadlineExceeded,
+		},
+		{
+			name:     "control port hangs",
+			phases:   []string{conn},
+			hang:     true,
+			wantInfo: []string{"Tor bootstrap: 10% (Connected to a relay)"},
+			wantErr:  context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctl := fakeTorControl(t, tt.phases, tt.hang)
+			// Long enough for three polls, unless it's meant to time out.
+			timeout := 5 * time.Second
+			if tt.wantErr != nil {
+				timeout = time.Second
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			info := make(chan string, 16)
+			err := waitBootstrap(ctx, ctl, info)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			close(info)
+			var got []string
+			for s := range info {
+				got = append(got, s)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.wantInfo, "\n") {
+				t.Fatalf("got info %q, want %q", got, tt.wantInfo)
+			}
+		})
+	}
+}
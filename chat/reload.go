@@ -0,0 +1,223 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"y-a-t-s/sockchat/config"
+)
+
+// Options that only take effect on a new connection.
+// Entries ending in a dot cover every key in that section.
+var reconnectKeys = []string{"cookies", "host", "port", "user_id", "http.", "proxy.", "tls.", "tor."}
+
+func needsReconnect(key string) bool {
+	for _, rk := range reconnectKeys {
+		if key == rk || (strings.HasSuffix(rk, ".") && strings.HasPrefix(key, rk)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Changes from a config reload.
+type ConfigUpdate struct {
+	// Options applied right away.
+	Applied []string
+	// Options held until ApplyPending is called, since they need a reconnect.
+	// Includes ones left pending from earlier reloads.
+	Pending []string
+}
+
+type cfgReload struct {
+	cfg  config.Config
+	keys []string
+	// Set to apply the pending options instead. Receives the resulting config,
+	// or is closed if nothing was pending.
+	applied chan config.Config
+}
+
+// Reconnect options waiting for confirmation.
+type pendingCfg struct {
+	mx   sync.Mutex
+	cfg  config.Config
+	keys []string
+}
+
+// Apply changes to the options named by keys from cfg, such as after config.json is edited.
+// Safe options take effect immediately. The rest are held until ApplyPending is called.
+// Receive from ConfigUpdates to find out which was which.
+func (c *Chat) UpdateConfig(ctx context.Context, cfg config.Config, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case c.reloads <- cfgReload{cfg: cfg, keys: keys}:
+	}
+}
+
+// Receives whenever a config reload changes anything. Only the latest update is kept.
+func (c *Chat) ConfigUpdates() <-chan ConfigUpdate {
+	return c.cfgUpdates
+}
+
+// Get the keys of options waiting for a reconnect.
+func (c *Chat) Pending() []string {
+	c.pending.mx.Lock()
+	defer c.pending.mx.Unlock()
+
+	return append([]string(nil), c.pending.keys...)
+}
+
+// Apply held options and reconnect with them.
+func (c *Chat) ApplyPending(ctx context.Context) error {
+	// Cfg is changed by the router, which hands back a copy to connect with.
+	applied := make(chan config.Config, 1)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case c.reloads <- cfgReload{applied: applied}:
+	}
+
+	var cfg config.Config
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case got, ok := <-applied:
+		if !ok {
+			return nil
+		}
+		cfg = got
+	}
+
+	c.infoLog <- "Reconnecting with new config..."
+	if err := c.setTransport(ctx, cfg); err != nil {
+		return err
+	}
+
+	return c.Reconnect(ctx)
+}
+
+// Copy pending options into Cfg and send the result on applied.
+// Must only be called from the router.
+func (c *Chat) applyPending(applied chan<- config.Config) {
+	c.pending.mx.Lock()
+	cfg, keys := c.pending.cfg, c.pending.keys
+	c.pending.keys = nil
+	c.pending.mx.Unlock()
+
+	if len(keys) == 0 {
+		close(applied)
+		return
+	}
+
+	prevID := c.Config().UserID
+	cur := c.updateConfig(func(cur *config.Config) {
+		cur.CopyOptions(cfg, keys...)
+	})
+	if cur.UserID != prevID {
+		c.Users.setClient(uint32(cur.UserID))
+	}
+
+	applied <- cur
+}
+
+// Must only be called from the router.
+func (c *Chat) applyReload(r cfgReload) {
+	if r.applied != nil {
+		c.applyPending(r.applied)
+		return
+	}
+
+	applied := make([]string, 0, len(r.keys))
+	pending := make([]string, 0, len(r.keys))
+	for _, k := range r.keys {
+		if needsReconnect(k) {
+			pending = append(pending, k)
+		} else {
+			applied = append(applied, k)
+		}
+	}
+
+	prev := c.Config()
+	cfg := c.updateConfig(func(cfg *config.Config) {
+		cfg.CopyOptions(r.cfg, applied...)
+	})
+
+	for _, k := range applied {
+		switch {
+		case k == "logger":
+			if err := c.setLogger(cfg.Logger); err != nil {
+				c.errLog <- err
+			}
+		case k == "room":
+			// connect joins Cfg.Room on its own.
+			if !c.isClosed() {
+				c.Queue.pushFront(fmt.Sprintf("/join %d", cfg.Room))
+			}
+		case strings.HasPrefix(k, "hooks."):
+			c.hooks.configure(cfg)
+		case strings.HasPrefix(k, "notify."):
+			c.notifier.configure(cfg)
+		case strings.HasPrefix(k, "scripts."):
+			c.scripts.configure(cfg)
+		case strings.HasPrefix(k, "irc."):
+			if err := c.setIRC(cfg); err != nil {
+				c.infoLog <- err.Error()
+				c.errLog <- err
+			}
+		case strings.HasPrefix(k, "metrics."):
+			if err := c.setMetrics(cfg); err != nil {
+				c.infoLog <- err.Error()
+				c.errLog <- err
+			}
+		case strings.HasPrefix(k, "rate_limit."):
+			c.limiter.set(limiterRate(cfg), cfg.RateLimit.Burst)
+			c.Queue.setWindow(coalesceWindow(cfg))
+		}
+	}
+
+	if len(applied) > 0 {
+		c.infoLog <- fmt.Sprintf("Config reloaded. Applied: %s.", strings.Join(applied, ", "))
+	}
+
+	c.pending.mx.Lock()
+	if len(pending) > 0 {
+		c.pending.cfg.CopyOptions(r.cfg, pending...)
+		for _, k := range pending {
+			if !slices.Contains(c.pending.keys, k) {
+				c.pending.keys = append(c.pending.keys, k)
+			}
+		}
+	}
+	// Drop anything that was changed back to its current value.
+	changed := prev.Changed(c.pending.cfg)
+	held := c.pending.keys[:0]
+	for _, k := range c.pending.keys {
+		if slices.Contains(changed, k) {
+			held = append(held, k)
+		}
+	}
+	c.pending.keys = held
+	update := ConfigUpdate{
+		Applied: applied,
+		Pending: append([]string(nil), held...),
+	}
+	c.pending.mx.Unlock()
+
+	if len(update.Pending) > 0 {
+		c.infoLog <- fmt.Sprintf("Changes to %s need a reconnect to apply.", strings.Join(update.Pending, ", "))
+	}
+
+	select {
+	case <-c.cfgUpdates:
+	default:
+	}
+	c.cfgUpdates <- update
+}
@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+)
+
+func TestNeedsReconnect(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"host", true},
+		{"cookies", true},
+		{"tor.enabled", true},
+		{"proxy.address", true},
+		{"room", false},
+		{"read_only", false},
+		{"notify.enabled", false},
+		// Only whole sections match.
+		{"torrent", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := needsReconnect(tt.key); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Reloads change Cfg on the chat router while the sock router reads and changes it for msgs on Out.
+// Run with -race.
+func TestReloadWhileSending(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := NewChat(ctx, config.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var routers sync.WaitGroup
+	routers.Add(2)
+	go func() {
+		defer routers.Done()
+		c.sock.router(ctx)
+	}()
+	go func() {
+		defer routers.Done()
+		if err := c.router(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	const n = 100
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := range n {
+			msg := fmt.Sprintf("msg %d", i)
+			if i%10 == 0 {
+				msg = fmt.Sprintf("/join %d", i/10+1)
+			}
+			c.Out <- msg
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := range n {
+			cfg := config.NewConfig()
+			cfg.Room = uint(i%5 + 1)
+			cfg.ReadOnly = i%2 == 0
+			c.UpdateConfig(ctx, cfg, []string{"room", "read_only"})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range n {
+			if cfg := c.Config(); cfg.Room == 0 {
+				t.Error("got room 0")
+			}
+			c.room()
+		}
+	}()
+	wg.Wait()
+
+	// Wait for a last reload to turn read_only on.
+	cfg := config.NewConfig()
+	cfg.ReadOnly = true
+	c.UpdateConfig(ctx, cfg, []string{"read_only"})
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.Config().ReadOnly {
+		if time.Now().After(deadline) {
+			t.Fatal("last reload wasn't applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	routers.Wait()
+}
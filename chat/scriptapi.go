@@ -0,0 +1,222 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Libs that can't touch the filesystem or run other programs.
+var safeLuaLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+	{lua.CoroutineLibName, lua.OpenCoroutine},
+}
+
+// Base funcs that load code from files.
+var unsafeLuaGlobals = []string{"dofile", "loadfile", "require", "module"}
+
+// The parts of the os lib that only deal with time.
+var safeOSFuncs = []string{"clock", "date", "difftime", "time"}
+
+// Trusted scripts get every lib. The rest are sandboxed.
+func newLuaState(trusted bool) *lua.LState {
+	if trusted {
+		return lua.NewState()
+	}
+
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	open := func(name string, fn lua.LGFunction) {
+		L.Push(L.NewFunction(fn))
+		L.Push(lua.LString(name))
+		L.Call(1, 0)
+	}
+
+	for _, lib := range safeLuaLibs {
+		open(lib.name, lib.open)
+	}
+	for _, g := range unsafeLuaGlobals {
+		L.SetGlobal(g, lua.LNil)
+	}
+
+	open(lua.OsLibName, lua.OpenOs)
+	full := L.GetGlobal(lua.OsLibName).(*lua.LTable)
+	os := L.NewTable()
+	for _, f := range safeOSFuncs {
+		os.RawSetString(f, full.RawGetString(f))
+	}
+	L.SetGlobal(lua.OsLibName, os)
+
+	return L
+}
+
+// Set up the chat table and print for sc.
+func (e *scriptEngine) setAPI(ctx context.Context, sc *script) {
+	L := sc.L
+	// Logs with the ctx of the running callback, so a stuck log can't outlast the timeout.
+	log := func(L *lua.LState, ms string) {
+		e.log(L.Context(), fmt.Sprintf("[%s] %s", sc.name, ms))
+	}
+
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		args := make([]string, 0, L.GetTop())
+		for i := 1; i <= L.GetTop(); i++ {
+			args = append(args, L.ToStringMeta(L.Get(i)).String())
+		}
+		log(L, strings.Join(args, "\t"))
+		return 0
+	}))
+
+	L.SetGlobal("chat", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"on_message": func(L *lua.LState) int {
+			sc.handlers = append(sc.handlers, L.CheckFunction(1))
+			return 0
+		},
+		"send": func(L *lua.LState) int {
+			text := L.CheckString(1)
+			if formatOutgoing(text) == "" {
+				L.Push(lua.LNil)
+				L.Push(lua.LString("Outgoing msg is empty."))
+				return 2
+			}
+
+			// Handed to the router without waiting for the echo, which would block every script.
+			// The callback's ctx only bounds the handoff, so a stuck router can't outlast the timeout.
+			select {
+			case <-L.Context().Done():
+				L.Push(lua.LNil)
+				L.Push(lua.LString(L.Context().Err().Error()))
+				return 2
+			case e.c.Out <- text:
+			}
+			L.Push(lua.LTrue)
+			return 1
+		},
+		"users": func(L *lua.LState) int {
+			users := make([]*User, 0)
+			e.c.Users.Range(func(_, v any) bool {
+				users = append(users, v.(*User))
+				return true
+			})
+			slices.SortFunc(users, func(a, b *User) int {
+				return strings.Compare(strings.ToLower(a.Username), strings.ToLower(b.Username))
+			})
+
+			t := L.NewTable()
+			for _, u := range users {
+				t.Append(luaUser(L, u))
+			}
+			L.Push(t)
+			return 1
+		},
+		"user": func(L *lua.LState) int {
+			var u *User
+			switch v := L.Get(1).(type) {
+			case lua.LNumber:
+				u = e.c.Users.Query(uint32(v))
+			case lua.LString:
+				name := strings.TrimPrefix(string(v), "@")
+				e.c.Users.Range(func(_, uv any) bool {
+					if uu := uv.(*User); strings.EqualFold(uu.Username, name) {
+						u = uu
+						return false
+					}
+					return true
+				})
+			default:
+				L.ArgError(1, "user ID or name expected")
+			}
+
+			if u == nil {
+				L.Push(lua.LNil)
+			} else {
+				L.Push(luaUser(L, u))
+			}
+			return 1
+		},
+		"me": func(L *lua.LState) int {
+			t := L.NewTable()
+			t.RawSetString("id", lua.LNumber(e.c.Users.ClientID()))
+			t.RawSetString("username", lua.LString(e.c.Users.ClientName()))
+			L.Push(t)
+			return 1
+		},
+		"room": func(L *lua.LState) int {
+			L.Push(lua.LNumber(e.c.room()))
+			return 1
+		},
+		"log": func(L *lua.LState) int {
+			log(L, L.CheckString(1))
+			return 0
+		},
+		"command": func(L *lua.LState) int {
+			name := strings.TrimPrefix(L.CheckString(1), "/")
+			usage := L.OptString(2, "")
+			fn := L.CheckFunction(3)
+			if name == "" || strings.ContainsAny(name, " \t\n") {
+				L.ArgError(1, "command names can't be empty or contain spaces")
+			}
+
+			if err := e.addCommand(ctx, sc, name, usage, fn); err != nil {
+				L.RaiseError("%s", err)
+			}
+			return 0
+		},
+		"after": func(L *lua.LState) int {
+			L.Push(lua.LNumber(e.addTimer(ctx, sc, checkDelay(L), false, L.CheckFunction(2))))
+			return 1
+		},
+		"every": func(L *lua.LState) int {
+			L.Push(lua.LNumber(e.addTimer(ctx, sc, checkDelay(L), true, L.CheckFunction(2))))
+			return 1
+		},
+		"cancel": func(L *lua.LState) int {
+			L.Push(lua.LBool(e.cancelTimer(sc, L.CheckInt(1))))
+			return 1
+		},
+	}))
+}
+
+// Get a delay in seconds from the first arg.
+func checkDelay(L *lua.LState) time.Duration {
+	sec := float64(L.CheckNumber(1))
+	if sec <= 0 {
+		L.ArgError(1, "delay must be greater than 0")
+	}
+
+	return time.Duration(sec * float64(time.Second))
+}
+
+func luaUser(L *lua.LState, u *User) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("id", lua.LNumber(u.ID))
+	t.RawSetString("username", lua.LString(u.Username))
+
+	return t
+}
+
+// Convert msg for passing to on_message handlers.
+func (e *scriptEngine) msgTable(sc *script, msg *Message) *lua.LTable {
+	L := sc.L
+	t := L.NewTable()
+	t.RawSetString("id", lua.LNumber(msg.MessageID))
+	t.RawSetString("author", lua.LString(msg.Author.Username))
+	t.RawSetString("author_id", lua.LNumber(msg.Author.ID))
+	t.RawSetString("text", lua.LString(msg.MessageRaw))
+	t.RawSetString("html", lua.LString(msg.Message))
+	t.RawSetString("room", lua.LNumber(msg.RoomID))
+	t.RawSetString("date", lua.LNumber(msg.MessageDate))
+	t.RawSetString("mention", lua.LBool(msg.IsMention))
+	t.RawSetString("own", lua.LBool(msg.Author.ID == e.c.Users.ClientID()))
+
+	return t
+}
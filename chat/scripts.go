@@ -0,0 +1,406 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"y-a-t-s/sockchat/config"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// How often the scripts dir is checked for changes.
+const _SCRIPT_SCAN_INTERVAL = 2 * time.Second
+
+// A loaded .lua file. Only touched from the engine's run loop.
+type script struct {
+	name string
+	// Used to spot changes to the file.
+	mod  time.Time
+	size int64
+
+	// nil if the script failed to load.
+	L        *lua.LState
+	handlers []*lua.LFunction
+	timers   map[int]*time.Timer
+	closed   bool
+}
+
+// Runs Lua scripts from the scripts dir and reloads them when they change.
+// Each script gets its own Lua state, and all of them run on a single goroutine,
+// so scripts never need to worry about locking.
+type scriptEngine struct {
+	c *Chat
+
+	cfg     config.Config
+	scripts map[string]*script
+	// Last error from scanning the dir, so it's only logged once.
+	scanErr string
+
+	// Funcs to run on the run loop, like timer callbacks and commands.
+	calls     chan func()
+	reconfigs chan config.Config
+	// Closed once the run loop exits.
+	stopped chan struct{}
+
+	nextTimer int
+	// Only new msgs are passed to scripts, not history or repeats after reconnecting.
+	lastID uint32
+	since  int64
+}
+
+func newScriptEngine(c *Chat, cfg config.Config) *scriptEngine {
+	return &scriptEngine{
+		c:         c,
+		cfg:       cfg,
+		scripts:   make(map[string]*script),
+		calls:     make(chan func()),
+		reconfigs: make(chan config.Config, 1),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// Switch to the scripts section of cfg, such as after a config reload.
+// Scripts are all reloaded. Must only be called from the router.
+func (e *scriptEngine) configure(cfg config.Config) {
+	select {
+	case <-e.reconfigs:
+	default:
+	}
+	e.reconfigs <- cfg
+}
+
+// Queue f to run on the run loop. Returns false if the engine stopped.
+func (e *scriptEngine) post(f func()) bool {
+	select {
+	case <-e.stopped:
+		return false
+	case e.calls <- f:
+		return true
+	}
+}
+
+func (e *scriptEngine) run(ctx context.Context) {
+	defer close(e.stopped)
+
+	// Client msgs include script output, which would loop.
+	mf := e.c.Feeder.Feed(Named("scripts"), NoClient(), Backpressure{Overflow: OverflowDropOldest})
+	defer mf.Close()
+
+	e.since = time.Now().Unix()
+	defer e.unloadAll()
+
+	scan := time.NewTicker(_SCRIPT_SCAN_INTERVAL)
+	defer scan.Stop()
+	e.scan(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-mf.Feed:
+			if !ok {
+				return
+			}
+			e.dispatch(ctx, &msg)
+		case f := <-e.calls:
+			f()
+		case <-scan.C:
+			e.scan(ctx)
+		case cfg := <-e.reconfigs:
+			e.unloadAll()
+			e.cfg = cfg
+			e.scanErr = ""
+			e.scan(ctx)
+		}
+	}
+}
+
+func (e *scriptEngine) log(ctx context.Context, ms string) {
+	select {
+	case <-ctx.Done():
+	case e.c.infoLog <- ms:
+	}
+}
+
+// Load new and changed scripts, and unload removed ones.
+func (e *scriptEngine) scan(ctx context.Context) {
+	if !e.cfg.Scripts.Enabled {
+		return
+	}
+
+	files, err := e.files()
+	if err != nil {
+		if err.Error() != e.scanErr {
+			e.scanErr = err.Error()
+			e.log(ctx, fmt.Sprintf("Failed to read scripts: %s", err))
+		}
+		return
+	}
+	e.scanErr = ""
+
+	for name, sc := range e.scripts {
+		if _, ok := files[name]; !ok {
+			e.unload(sc)
+			delete(e.scripts, name)
+			e.log(ctx, fmt.Sprintf("Unloaded script %s.", name))
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		fi := files[name]
+		prev, loaded := e.scripts[name]
+		if loaded && prev.mod.Equal(fi.ModTime()) && prev.size == fi.Size() {
+			continue
+		}
+		if loaded {
+			e.unload(prev)
+		}
+
+		sc, err := e.load(ctx, name)
+		sc.mod, sc.size = fi.ModTime(), fi.Size()
+		e.scripts[name] = sc
+
+		switch {
+		case err != nil:
+			e.log(ctx, fmt.Sprintf("Failed to load script %s: %s", name, err))
+		case loaded:
+			e.log(ctx, fmt.Sprintf("Reloaded script %s.", name))
+		default:
+			e.log(ctx, fmt.Sprintf("Loaded script %s.", name))
+		}
+	}
+}
+
+// Get the .lua files in the scripts dir by name.
+func (e *scriptEngine) files() (map[string]os.FileInfo, error) {
+	dir, err := e.cfg.ScriptsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]os.FileInfo, len(entries))
+	for _, de := range entries {
+		if !de.Type().IsRegular() || filepath.Ext(de.Name()) != ".lua" {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			// Removed since ReadDir.
+			continue
+		}
+		files[de.Name()] = fi
+	}
+
+	return files, nil
+}
+
+// Always returns a script, so failed ones aren't retried until they change.
+func (e *scriptEngine) load(ctx context.Context, name string) (*script, error) {
+	sc := &script{
+		name:   name,
+		timers: make(map[int]*time.Timer),
+	}
+
+	dir, err := e.cfg.ScriptsDir()
+	if err != nil {
+		return sc, err
+	}
+
+	sc.L = newLuaState(slices.Contains(e.cfg.Scripts.Trusted, name))
+	e.setAPI(ctx, sc)
+
+	err = e.protect(ctx, sc, func() error {
+		return sc.L.DoFile(filepath.Join(dir, name))
+	})
+	if err != nil {
+		e.unload(sc)
+		sc.L = nil
+	}
+
+	return sc, err
+}
+
+// Close the script's state and drop anything it registered.
+func (e *scriptEngine) unload(sc *script) {
+	sc.closed = true
+	for _, t := range sc.timers {
+		t.Stop()
+	}
+	clear(sc.timers)
+	e.c.Commands.RemoveOwner(sc.name)
+
+	if sc.L != nil {
+		sc.L.Close()
+	}
+}
+
+func (e *scriptEngine) unloadAll() {
+	for name, sc := range e.scripts {
+		e.unload(sc)
+		delete(e.scripts, name)
+	}
+}
+
+// Run f with the script's state limited to scripts.timeout.
+func (e *scriptEngine) protect(ctx context.Context, sc *script, f func() error) error {
+	timeout := time.Duration(e.cfg.Scripts.Timeout * float64(time.Second))
+	sctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	sc.L.SetContext(sctx)
+	defer sc.L.RemoveContext()
+
+	err := f()
+	if err != nil && errors.Is(sctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("Timed out after %s.", timeout)
+	}
+
+	return err
+}
+
+// Call fn from sc. Results are left on the stack.
+func (e *scriptEngine) call(ctx context.Context, sc *script, fn *lua.LFunction, nret int, args ...lua.LValue) error {
+	return e.protect(ctx, sc, func() error {
+		return sc.L.CallByParam(lua.P{Fn: fn, NRet: nret, Protect: true}, args...)
+	})
+}
+
+// Pass msg to the on_message handlers of every script.
+func (e *scriptEngine) dispatch(ctx context.Context, msg *Message) {
+	if msg.IsEdited() || msg.MessageDate < e.since || msg.MessageID <= e.lastID {
+		return
+	}
+	e.lastID = msg.MessageID
+
+	for _, name := range e.sortedNames() {
+		sc := e.scripts[name]
+		if sc.L == nil {
+			continue
+		}
+
+		for _, fn := range sc.handlers {
+			if sc.closed {
+				break
+			}
+			if err := e.call(ctx, sc, fn, 0, e.msgTable(sc, msg)); err != nil {
+				e.log(ctx, fmt.Sprintf("[%s] on_message failed: %s", sc.name, err))
+			}
+		}
+	}
+}
+
+func (e *scriptEngine) sortedNames() []string {
+	names := make([]string, 0, len(e.scripts))
+	for name := range e.scripts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Call fn after delay, and again every delay after that if repeat is set.
+// Returns an ID for cancelling it.
+func (e *scriptEngine) addTimer(ctx context.Context, sc *script, delay time.Duration, repeat bool, fn *lua.LFunction) int {
+	e.nextTimer++
+	id := e.nextTimer
+
+	sc.timers[id] = time.AfterFunc(delay, func() {
+		e.post(func() {
+			t, ok := sc.timers[id]
+			if sc.closed || !ok {
+				return
+			}
+
+			err := e.call(ctx, sc, fn, 0)
+			switch {
+			case sc.closed:
+			case err != nil:
+				delete(sc.timers, id)
+				e.log(ctx, fmt.Sprintf("[%s] Timer %d failed and was stopped: %s", sc.name, id, err))
+			case repeat:
+				t.Reset(delay)
+			default:
+				delete(sc.timers, id)
+			}
+		})
+	})
+
+	return id
+}
+
+func (e *scriptEngine) cancelTimer(sc *script, id int) bool {
+	t, ok := sc.timers[id]
+	if !ok {
+		return false
+	}
+
+	t.Stop()
+	delete(sc.timers, id)
+	return true
+}
+
+// Add a slash command that calls fn from sc on the run loop.
+func (e *scriptEngine) addCommand(ctx context.Context, sc *script, name, usage string, fn *lua.LFunction) error {
+	if usage == "" {
+		usage = "/" + name
+	}
+
+	return e.c.Commands.Register(name, Command{
+		Usage: usage,
+		Owner: sc.name,
+		Run: func(cctx context.Context, args string) error {
+			res := make(chan error, 1)
+			ok := e.post(func() {
+				if sc.closed {
+					res <- fmt.Errorf("Script %s was unloaded.", sc.name)
+					return
+				}
+				res <- e.runCommand(ctx, sc, fn, args)
+			})
+			if !ok {
+				return errors.New("Scripts aren't running.")
+			}
+
+			select {
+			case <-cctx.Done():
+				return cctx.Err()
+			case err := <-res:
+				return err
+			}
+		},
+	})
+}
+
+// Commands fail if fn raises an error or returns an error string.
+func (e *scriptEngine) runCommand(ctx context.Context, sc *script, fn *lua.LFunction, args string) error {
+	if err := e.call(ctx, sc, fn, 1, lua.LString(args)); err != nil {
+		return fmt.Errorf("[%s] %w", sc.name, err)
+	}
+
+	ret := sc.L.Get(-1)
+	sc.L.Pop(1)
+	if s, ok := ret.(lua.LString); ok && strings.TrimSpace(string(s)) != "" {
+		return errors.New(string(s))
+	}
+
+	return nil
+}
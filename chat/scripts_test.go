@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"y-a-t-s/sockchat/config"
+)
+
+func TestScriptSend(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		// Size of Out. 0 means nothing reads it, so sends block.
+		outCap  int
+		wantOut []string
+		// Values of the globals ok and err after loading.
+		wantOK  bool
+		wantErr string
+		// Set if the script is stopped by the timeout instead.
+		wantTimeout bool
+	}{
+		{name: "queued", src: `ok, err = chat.send("hi")`, outCap: 4, wantOut: []string{"hi"}, wantOK: true},
+		{name: "raw text", src: `ok, err = chat.send(">implying")`, outCap: 4, wantOut: []string{">implying"}, wantOK: true},
+		{name: "empty", src: `ok, err = chat.send("   ")`, outCap: 4, wantErr: "Outgoing msg is empty."},
+		{name: "stuck router", src: `ok, err = chat.send("hi")`, wantTimeout: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "test.lua"), []byte(tt.src), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := config.NewConfig()
+			cfg.Scripts.Dir = dir
+			cfg.Scripts.Timeout = 0.2
+
+			c := &Chat{
+				sock: &sock{
+					Out:     make(chan string, tt.outCap),
+					infoLog: make(chan string, 8),
+				},
+				Commands: newCommandTable(),
+			}
+			e := newScriptEngine(c, cfg)
+
+			done := make(chan *script, 1)
+			go func() {
+				sc, err := e.load(context.Background(), "test.lua")
+				if (err != nil) != tt.wantTimeout {
+					t.Errorf("got load error %v, want timeout %v", err, tt.wantTimeout)
+				}
+				done <- sc
+			}()
+
+			var sc *script
+			select {
+			case sc = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("chat.send blocked past the timeout")
+			}
+			if sc.L == nil {
+				return
+			}
+			defer e.unload(sc)
+
+			close(c.Out)
+			var got []string
+			for msg := range c.Out {
+				got = append(got, msg)
+			}
+			if !slices.Equal(got, tt.wantOut) {
+				t.Errorf("sent %q, want %q", got, tt.wantOut)
+			}
+
+			if ok := lua.LVAsBool(sc.L.GetGlobal("ok")); ok != tt.wantOK {
+				t.Errorf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if errStr := lua.LVAsString(sc.L.GetGlobal("err")); errStr != tt.wantErr {
+				t.Errorf("got err %q, want %q", errStr, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScriptsOffByDefault(t *testing.T) {
+	if config.NewConfig().Scripts.Enabled {
+		t.Fatal("scripts are enabled by default")
+	}
+}
@@ -0,0 +1,280 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long Send waits for the server to echo a msg back.
+const _SEND_TIMEOUT = 30 * time.Second
+
+type SendStatus uint8
+
+const (
+	SendPending SendStatus = iota
+	SendSent
+	SendFailed
+	// Saved to the outbox while disconnected.
+	SendQueued
+)
+
+func (st SendStatus) String() string {
+	switch st {
+	case SendPending:
+		return "pending"
+	case SendSent:
+		return "sent"
+	case SendFailed:
+		return "failed"
+	case SendQueued:
+		return "queued"
+	default:
+		return "unknown"
+	}
+}
+
+// Delivery confirmation for a msg sent with Send.
+type SendResult struct {
+	MessageID uint32
+	RoomID    uint16
+	// Time between queueing the msg and receiving the echo.
+	Latency time.Duration
+}
+
+type pendingSend struct {
+	// As given to Send, and as formatted for the echo.
+	raw  string
+	text string
+	// Room the router queued it for. Only set once routed.
+	room   uint
+	routed bool
+	done   chan SendResult
+	err    chan error
+	sent   time.Time
+	// Sends coalesced into this one, resolved along with it.
+	dups []*pendingSend
+}
+
+// Send a result without blocking. Each pending send gets at most 1.
+func (ps *pendingSend) resolve(res SendResult, err error) {
+	if err != nil {
+		select {
+		case ps.err <- err:
+		default:
+		}
+		return
+	}
+
+	select {
+	case ps.done <- res:
+	default:
+	}
+}
+
+// What the router did with a msg from Send.
+type routeResult uint8
+
+const (
+	routeQueued routeResult = iota
+	// Dropped as a duplicate of a msg queued just before it.
+	routeCoalesced
+	routeOutbox
+	routeReadOnly
+)
+
+// Number of confirmed sends kept for resolving late duplicates.
+const _RECENT_SENDS = 8
+
+// Tracks msgs sent with Send until the server echoes them back.
+type sendTracker struct {
+	mx      sync.Mutex
+	pending []*pendingSend
+	// Latest confirmed sends, oldest first.
+	recent []confirmedSend
+}
+
+type confirmedSend struct {
+	room uint
+	text string
+	res  SendResult
+}
+
+func newSendTracker() *sendTracker {
+	return &sendTracker{
+		pending: make([]*pendingSend, 0, 8),
+	}
+}
+
+func (st *sendTracker) add(raw string) *pendingSend {
+	ps := &pendingSend{
+		raw:  strings.TrimSpace(raw),
+		text: formatOutgoing(raw),
+		done: make(chan SendResult, 1),
+		err:  make(chan error, 1),
+		sent: time.Now(),
+	}
+
+	st.mx.Lock()
+	defer st.mx.Unlock()
+	st.pending = append(st.pending, ps)
+
+	return ps
+}
+
+func (st *sendTracker) remove(ps *pendingSend) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	st.pending = slices.DeleteFunc(st.pending, func(p *pendingSend) bool { return p == ps })
+}
+
+// Record what the router did with raw, which it just read from Out.
+// Must only be called from the router, which owns the room.
+// canConfirm is false if the client's user ID isn't known, so echoes can't be told apart from other users' msgs.
+func (st *sendTracker) routed(raw string, room uint, how routeResult, canConfirm bool) {
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	// Msgs from Send reach the router in the order they were added.
+	i := slices.IndexFunc(st.pending, func(p *pendingSend) bool { return !p.routed && p.raw == raw })
+	if i < 0 {
+		// Written to Out directly.
+		return
+	}
+	ps := st.pending[i]
+	ps.routed, ps.room = true, room
+
+	switch how {
+	case routeOutbox:
+		st.pending = slices.Delete(st.pending, i, i+1)
+		ps.resolve(SendResult{}, &ErrQueuedOffline{raw})
+	case routeReadOnly:
+		st.pending = slices.Delete(st.pending, i, i+1)
+		ps.resolve(SendResult{}, errors.New("Can't send msgs in read-only mode."))
+	case routeCoalesced:
+		st.pending = slices.Delete(st.pending, i, i+1)
+		st.coalesce(ps)
+	case routeQueued:
+		if !canConfirm {
+			st.pending = slices.Delete(st.pending, i, i+1)
+			ps.resolve(SendResult{}, &ErrUnconfirmed{raw})
+		}
+	}
+}
+
+// Resolve ps along with the msg it was coalesced into.
+// Must be called with st.mx held.
+func (st *sendTracker) coalesce(ps *pendingSend) {
+	for _, p := range st.pending {
+		if p.routed && p.room == ps.room && p.text == ps.text {
+			p.dups = append(p.dups, ps)
+			return
+		}
+	}
+
+	// The original was already confirmed.
+	for i := len(st.recent) - 1; i >= 0; i-- {
+		if cs := st.recent[i]; cs.room == ps.room && cs.text == ps.text {
+			ps.resolve(cs.res, nil)
+			return
+		}
+	}
+
+	// The original didn't come from Send, so there's no way to confirm it.
+	ps.resolve(SendResult{}, &ErrUnconfirmed{ps.raw})
+}
+
+// Resolve the oldest pending send that msg is an echo of.
+// Only msgs by the client count, so clientID must be known.
+func (st *sendTracker) match(msg *Message, clientID uint32) {
+	if msg == nil || msg.Author == nil || msg.IsEdited() || msg.Author.ID != clientID {
+		return
+	}
+
+	st.mx.Lock()
+	defer st.mx.Unlock()
+
+	for i, p := range st.pending {
+		if !p.routed || uint(msg.RoomID) != p.room || msg.MessageRaw != p.text {
+			continue
+		}
+
+		res := SendResult{
+			MessageID: msg.MessageID,
+			RoomID:    msg.RoomID,
+			Latency:   time.Since(p.sent),
+		}
+		p.resolve(res, nil)
+		for _, d := range p.dups {
+			d.resolve(SendResult{MessageID: res.MessageID, RoomID: res.RoomID, Latency: time.Since(d.sent)}, nil)
+		}
+		st.pending = slices.Delete(st.pending, i, i+1)
+
+		st.recent = append(st.recent, confirmedSend{p.room, p.text, res})
+		if len(st.recent) > _RECENT_SENDS {
+			st.recent = st.recent[1:]
+		}
+		return
+	}
+}
+
+type SendReply struct {
+	SendResult
+	Err error
+}
+
+// Send text to the current room and wait for the server to echo it back.
+// Returns an *ErrSendTimeout if no echo arrives in time.
+func (c *Chat) Send(ctx context.Context, text string) (SendResult, error) {
+	r := <-c.SendAsync(ctx, text)
+	return r.SendResult, r.Err
+}
+
+// Queue text to be sent before returning, then wait for the echo in the background.
+// Msgs queued by successive calls go out in the order of the calls.
+// The returned chan receives exactly 1 reply.
+func (c *Chat) SendAsync(ctx context.Context, text string) <-chan SendReply {
+	reply := make(chan SendReply, 1)
+
+	if formatOutgoing(text) == "" {
+		reply <- SendReply{Err: errors.New("Outgoing msg is empty.")}
+		return reply
+	}
+
+	// The router decides where the msg goes and reports back through ps.
+	ps := c.sends.add(text)
+
+	select {
+	case <-ctx.Done():
+		c.sends.remove(ps)
+		reply <- SendReply{Err: ctx.Err()}
+		return reply
+	case c.Out <- text:
+	}
+
+	go func() {
+		defer c.sends.remove(ps)
+
+		ctx, cancel := context.WithTimeout(ctx, _SEND_TIMEOUT)
+		defer cancel()
+
+		select {
+		case <-ctx.Done():
+			err := ctx.Err()
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = &ErrSendTimeout{text}
+			}
+			reply <- SendReply{Err: err}
+		case err := <-ps.err:
+			reply <- SendReply{Err: err}
+		case res := <-ps.done:
+			reply <- SendReply{SendResult: res}
+		}
+	}()
+
+	return reply
+}
@@ -0,0 +1,159 @@
+package chat
+
+import (
+	"fmt"
+	"testing"
+)
+
+const testClientID = 42
+
+func echo(author uint32, room uint16, id uint32, text string) *Message {
+	return &Message{
+		Author:     &User{ID: author},
+		RoomID:     room,
+		MessageID:  id,
+		MessageRaw: text,
+	}
+}
+
+// What a pending send ended up with. "pending" if it's still waiting.
+func outcome(ps *pendingSend) string {
+	select {
+	case res := <-ps.done:
+		return fmt.Sprintf("sent %d", res.MessageID)
+	case err := <-ps.err:
+		return fmt.Sprintf("%T", err)
+	default:
+		return "pending"
+	}
+}
+
+func TestSendTracker(t *testing.T) {
+	type route struct {
+		how        routeResult
+		canConfirm bool
+	}
+
+	tests := []struct {
+		name string
+		// Sends, in order, and what the router did with each.
+		sends  []string
+		routes []route
+		// Echoes arriving after routing.
+		echoes []*Message
+		want   []string
+	}{
+		{
+			name:   "confirmed",
+			sends:  []string{"hi"},
+			routes: []route{{routeQueued, true}},
+			echoes: []*Message{echo(testClientID, 1, 7, "hi")},
+			want:   []string{"sent 7"},
+		},
+		{
+			name:   "greentext is matched formatted",
+			sends:  []string{">implying"},
+			routes: []route{{routeQueued, true}},
+			echoes: []*Message{echo(testClientID, 1, 7, formatOutgoing(">implying"))},
+			want:   []string{"sent 7"},
+		},
+		{
+			name:   "other author",
+			sends:  []string{"hi"},
+			routes: []route{{routeQueued, true}},
+			echoes: []*Message{echo(99, 1, 7, "hi")},
+			want:   []string{"pending"},
+		},
+		{
+			name:   "other room",
+			sends:  []string{"hi"},
+			routes: []route{{routeQueued, true}},
+			echoes: []*Message{echo(testClientID, 2, 7, "hi")},
+			want:   []string{"pending"},
+		},
+		{
+			name:   "oldest first",
+			sends:  []string{"hi", "hi"},
+			routes: []route{{routeQueued, true}, {routeQueued, true}},
+			echoes: []*Message{echo(testClientID, 1, 7, "hi")},
+			want:   []string{"sent 7", "pending"},
+		},
+		{
+			name:   "coalesced resolves with the original",
+			sends:  []string{"hi", "hi"},
+			routes: []route{{routeQueued, true}, {routeCoalesced, false}},
+			echoes: []*Message{echo(testClientID, 1, 7, "hi")},
+			want:   []string{"sent 7", "sent 7"},
+		},
+		{
+			name:   "coalesced waits for the original",
+			sends:  []string{"hi", "hi"},
+			routes: []route{{routeQueued, true}, {routeCoalesced, false}},
+			want:   []string{"pending", "pending"},
+		},
+		{
+			name:   "unknown client",
+			sends:  []string{"hi"},
+			routes: []route{{routeQueued, false}},
+			echoes: []*Message{echo(testClientID, 1, 7, "hi")},
+			want:   []string{"*chat.ErrUnconfirmed"},
+		},
+		{
+			name:   "outbox",
+			sends:  []string{"hi"},
+			routes: []route{{routeOutbox, false}},
+			want:   []string{"*chat.ErrQueuedOffline"},
+		},
+		{
+			name:   "read-only",
+			sends:  []string{"hi"},
+			routes: []route{{routeReadOnly, false}},
+			want:   []string{"*errors.errorString"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newSendTracker()
+			sends := make([]*pendingSend, len(tt.sends))
+			for i, text := range tt.sends {
+				sends[i] = st.add(text)
+				st.routed(text, 1, tt.routes[i].how, tt.routes[i].canConfirm)
+			}
+			for _, msg := range tt.echoes {
+				st.match(msg, testClientID)
+			}
+
+			for i, ps := range sends {
+				if got := outcome(ps); got != tt.want[i] {
+					t.Errorf("send %d: got %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// A duplicate coalesced after its original was confirmed gets the original's result.
+func TestSendTrackerLateDuplicate(t *testing.T) {
+	st := newSendTracker()
+
+	first := st.add("hi")
+	st.routed("hi", 1, routeQueued, true)
+	st.match(echo(testClientID, 1, 7, "hi"), testClientID)
+
+	dup := st.add("hi")
+	st.routed("hi", 1, routeCoalesced, false)
+
+	for _, ps := range []*pendingSend{first, dup} {
+		if got := outcome(ps); got != "sent 7" {
+			t.Errorf("got %s, want sent 7", got)
+		}
+	}
+
+	// Not from Send, so there's no result to share.
+	orphan := st.add("bye")
+	st.routed("bye", 1, routeCoalesced, false)
+	if got := outcome(orphan); got != "*chat.ErrUnconfirmed" {
+		t.Errorf("got %s, want *chat.ErrUnconfirmed", got)
+	}
+}
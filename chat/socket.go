@@ -3,16 +3,18 @@ package chat
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"y-a-t-s/sockchat/config"
@@ -27,32 +29,90 @@ const (
 	GREEN = "#72ff72"
 	// Max chat history length.
 	HIST_LEN = 512
-	// User-Agent string for headers.
-	USER_AGENT = "Mozilla/5.0 (Windows NT 6.1; rv:60.0) Gecko/20100101 Firefox/60.0"
+
+	// Deprecated: The User-Agent is set by the http.browser and http.user_agent options.
+	// This is the default browser preset's.
+	USER_AGENT = config.DEFAULT_USER_AGENT
 )
 
 type sock struct {
+	// Guards Conn and closed, which are replaced on every connect.
+	connMx sync.Mutex
+	// Held while connecting, so only one connection is opened at a time.
+	dialMx sync.Mutex
 	*websocket.Conn
 	closed chan struct{}
 
 	Users *userTable
-	pool  *ChatPool
+	pool  *chatPool
 
 	debug   chan string
 	errLog  chan error
 	infoLog chan string
+	// Wakes the reconnect loop when it's waiting on the user.
+	wake chan struct{}
+	// Errors the user needs to act on, like a TLS pin mismatch.
+	alerts chan error
 
 	chatJson chan []byte
 	messages chan *Message
 	Out      chan string
-
-	proxy *socksProxy
-	host  *url.URL
-
+	// Msgs from Out waiting to be written, kept as typed.
+	Queue   *outQueue
+	limiter *tokenBucket
+	// Msgs composed while disconnected.
+	Outbox *outbox
+	// Set once a /join is written, until the server answers it.
+	joining atomic.Bool
+	// Msgs from Send waiting for their echo.
+	sends *sendTracker
+	hooks *hookRunner
+	state *connState
+	// Counters for the metrics endpoint.
+	metrics *metrics
+	// User and connection events for the feeder.
+	events chan Event
+
+	// Guards proxy, host, tls, header and kf while they're being replaced.
+	transportMx sync.Mutex
+	proxy       *proxyDialer
+	host        *url.URL
+	tls         *tls.Config
+	// Sent with the handshake and by kf's client.
+	header http.Header
+
+	// Guards Cfg, which reloads, joins and session refreshes change while the chat runs.
+	cfgMx sync.RWMutex
+	// Only safe to use directly before the chat starts. Use Config after that.
 	Cfg config.Config
 	kf  *libkiwi.KF
 }
 
+// Get a copy of the current config.
+func (s *sock) Config() config.Config {
+	s.cfgMx.RLock()
+	defer s.cfgMx.RUnlock()
+
+	return s.Cfg
+}
+
+// Get the room the chat is in, or is about to join.
+func (s *sock) room() uint {
+	s.cfgMx.RLock()
+	defer s.cfgMx.RUnlock()
+
+	return s.Cfg.Room
+}
+
+// Change the config with f. Returns a copy of the result.
+func (s *sock) updateConfig(f func(cfg *config.Config)) config.Config {
+	s.cfgMx.Lock()
+	defer s.cfgMx.Unlock()
+
+	f(&s.Cfg)
+	return s.Cfg
+}
+
 // Split the protocol part from addresses in the config, if present.
 func parseHost(addr string) (*url.URL, error) {
 	if !strings.Contains(addr, "://") {
@@ -81,95 +141,174 @@ func newSocket(ctx context.Context, cfg config.Config) (*sock, error) {
 		debug:    make(chan string, 8),
 		errLog:   make(chan error, 8),
 		infoLog:  make(chan string, 8),
+		wake:     make(chan struct{}, 1),
+		alerts:   make(chan error, 1),
 		chatJson: make(chan []byte, 64),
 		messages: make(chan *Message, HIST_LEN),
 		Out:      make(chan string, 8),
+		limiter:  newLimiter(cfg),
+		state:    newConnState(),
+		metrics:  newMetrics(),
+		sends:    newSendTracker(),
+		events:   make(chan Event, HIST_LEN),
 	}
 	close(s.closed)
 
-	err := s.setUrl(cfg.Host, uint16(cfg.Port))
+	s.Queue = newOutQueue(coalesceWindow(cfg))
+	s.hooks = newHookRunner(cfg, s.infoLog)
+
+	var err error
+	s.Outbox, err = loadOutbox(cfg)
 	if err != nil {
 		return nil, err
 	}
-	if strings.HasSuffix(s.host.Hostname(), ".onion") {
+
+	// The transport is set up on the first connect. See ensureTransport.
+	return s, nil
+}
+
+// Set up the host URL, proxy and HTTP client used to connect, based on cfg.
+// Called again when reloading changes any of those values.
+// The current ones are only replaced once the new ones are ready.
+func (s *sock) setTransport(ctx context.Context, cfg config.Config) error {
+	s.transportMx.Lock()
+	defer s.transportMx.Unlock()
+
+	return s.buildTransport(ctx, cfg)
+}
+
+// Set up the transport if it hasn't been yet.
+// Deferred until the first connect, so Tor's progress shows up in the client.
+func (s *sock) ensureTransport(ctx context.Context) error {
+	s.transportMx.Lock()
+	defer s.transportMx.Unlock()
+
+	if s.kf != nil {
+		return nil
+	}
+
+	return s.buildTransport(ctx, s.Config())
+}
+
+// Must be called with s.transportMx held.
+func (s *sock) buildTransport(ctx context.Context, cfg config.Config) error {
+	host, err := wsUrl(cfg.Host, uint16(cfg.Port))
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(host.Hostname(), ".onion") {
 		cfg.Tor.Enabled = true
 		cfg.Tor.Clearnet = false
 	}
 
+	var p *proxyDialer
 	switch {
 	case cfg.Tor.Enabled:
 		// Set socket URL to onion domain if desired.
 		if !cfg.Tor.Clearnet {
-			err = s.setUrl(cfg.Tor.Onion, uint16(cfg.Port))
+			host, err = wsUrl(cfg.Tor.Onion, uint16(cfg.Port))
 			if err != nil {
-				return nil, err
+				return err
 			}
 
-			log.Printf("Connecting to onion service: %s\nMake sure this domain is correct.\n", s.host.Hostname())
-			time.Sleep(3 * time.Second)
+			if err = verifyOnion(cfg, s.infoLog); err != nil {
+				return err
+			}
 		}
 
-		s.proxy, err = startTor(ctx)
+		start := time.Now()
+		p, err = startTor(ctx, cfg, s.infoLog)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		s.metrics.torBootstrap.Store(int64(time.Since(start)))
 	case cfg.Proxy.Enabled:
-		s.proxy, err = newSocksDialer(cfg)
+		p, err = newProxyDialer(cfg)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	hc := http.Client{}
-
-	// Will be default transport if s.proxy is nil.
-	if s.proxy != nil {
-		tr := http.DefaultTransport.(*http.Transport).Clone()
-		tr.DialContext = s.proxy.DialContext
-		hc.Transport = tr
+	tc, err := newTLSConfig(cfg, host.Hostname())
+	if err != nil {
+		if p != nil {
+			p.stopTor()
+		}
+		return err
 	}
-
-	kf, err := libkiwi.NewKF(hc, s.host.Hostname(), cfg.Cookies)
+	header, err := cfg.HTTPHeaders()
 	if err != nil {
-		return nil, err
+		if p != nil {
+			p.stopTor()
+		}
+		return err
 	}
-	s.kf = kf
 
-	return s, nil
-}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tc
+	// Will use the default dialer if p is nil.
+	if p != nil {
+		tr.DialContext = p.DialContext
+	}
+	hc := http.Client{Transport: &headerTransport{tr, header}}
 
-func (s *sock) setUrl(addr string, port uint16) error {
-	host, err := parseHost(addr)
+	kf, err := libkiwi.NewKF(hc, host.Hostname(), cfg.Cookies)
 	if err != nil {
+		if p != nil {
+			p.stopTor()
+		}
 		return err
 	}
 
-	u, err := url.Parse(fmt.Sprintf("wss://%s:%d/chat.ws", host.Hostname(), port))
-	if err != nil {
-		return err
+	// Don't leave a previous Tor instance running.
+	if s.proxy != nil {
+		s.proxy.stopTor()
 	}
-	s.host = u
+	s.host, s.proxy, s.tls, s.header, s.kf = host, p, tc, header, kf
 
 	return nil
 }
 
+// Build the chat socket URL for addr.
+func wsUrl(addr string, port uint16) (*url.URL, error) {
+	host, err := parseHost(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return url.Parse(fmt.Sprintf("wss://%s:%d/chat.ws", host.Hostname(), port))
+}
+
+// Open a new connection, closing the current one if it's open.
 func (s *sock) connect(ctx context.Context) error {
-	select {
-	case <-ctx.Done():
+	return s.dial(ctx, false)
+}
+
+// Open a new connection. If onlyClosed is set, nothing is done if someone else already reconnected.
+func (s *sock) dial(ctx context.Context, onlyClosed bool) error {
+	s.dialMx.Lock()
+	defer s.dialMx.Unlock()
+
+	if ctx.Err() != nil {
 		return ctx.Err()
-	case <-s.closed:
-	default:
+	}
+	if !s.isClosed() {
+		if onlyClosed {
+			return nil
+		}
 		s.disconnect()
-		// return errors.New("Socket already open.")
 	}
 
-	s.infoLog <- "Opening socket..."
+	s.setState(StateConnecting, nil)
+	if err := s.ensureTransport(ctx); err != nil {
+		s.infoLog <- fmt.Sprintf("Failed to set up connection: %s", err)
+		s.alert(err)
+		s.setState(StateDisconnected, err)
+		return err
+	}
 
-	// defined up here to make the redundant slice warning fuck off.
-	var (
-		ua = []string{USER_AGENT}
-		//cookies = []string{s.Cfg.Cookies}
-	)
+	s.infoLog <- "Opening socket..."
 
 	// Create new WebSocket dialer, routing through any applicable proxies.
 	wd := websocket.Dialer{
@@ -177,46 +316,149 @@ func (s *sock) connect(ctx context.Context) error {
 		// Set handshake timeout to 1 min.
 		HandshakeTimeout: time.Minute,
 		Jar:              s.kf.Client.Jar,
+		TLSClientConfig:  s.tls,
 	}
 	if s.proxy != nil {
 		wd.NetDialContext = s.proxy.DialContext
 	}
 
-	conn, _, err := wd.DialContext(ctx, s.host.String(), map[string][]string{
-		//"Cookie":     cookies,
-		"User-Agent": ua,
-	})
+	// Cookies come from the jar.
+	conn, _, err := wd.DialContext(ctx, s.host.String(), s.header.Clone())
 	if err != nil {
+		s.alert(err)
+		s.setState(StateDisconnected, err)
 		return err
 	}
 	conn.EnableWriteCompression(true)
-	// Set s.Conn at the end to avoid early access.
+	// Set s.Conn at the end to avoid early access, and mark as opened.
+	s.connMx.Lock()
 	s.Conn = conn
-
-	// Mark as opened.
 	s.closed = make(chan struct{})
+	s.connMx.Unlock()
 
 	// Send /join message for desired room.
-	s.Out <- fmt.Sprintf("/join %d", s.Cfg.Room)
+	// The outbox is flushed once the server answers it.
+	room := s.room()
+	s.Queue.pushFront(fmt.Sprintf("/join %d", room))
 	s.infoLog <- "Connected."
+	s.setState(StateConnected, nil)
+	s.wakeReconnect()
+	s.hooks.fire(ctx, HookEvent{Event: HookConnect, Room: room})
 
 	return nil
 }
 
+// Pass err on to Alerts if the user needs to act on it.
+func (s *sock) alert(err error) {
+	if !untrusted(err) {
+		return
+	}
+
+	// Only the latest is kept.
+	select {
+	case <-s.alerts:
+	default:
+	}
+	select {
+	case s.alerts <- err:
+	default:
+	}
+}
+
+func (s *sock) setState(state ConnState, err error) {
+	s.state.set(state, err)
+	s.event(Event{Kind: EventConn, State: state, Err: err})
+}
+
+// Pass ev on to the feeder. Dropped if the router is too far behind,
+// since the socket can't wait on subscribers.
+func (s *sock) event(ev Event) {
+	ev.Time = time.Now()
+	select {
+	case s.events <- ev:
+	default:
+	}
+}
+
+func (s *sock) wakeReconnect() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Get the current conn, along with a chan that's closed once it's been disconnected.
+func (s *sock) current() (*websocket.Conn, <-chan struct{}) {
+	s.connMx.Lock()
+	defer s.connMx.Unlock()
+
+	return s.Conn, s.closed
+}
+
+func (s *sock) isClosed() bool {
+	_, closed := s.current()
+	select {
+	case <-closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Move msgs composed while disconnected to the send queue.
+func (s *sock) flushOutbox() {
+	msgs, err := s.Outbox.drain()
+	if err != nil {
+		s.errLog <- err
+	}
+	if len(msgs) == 0 {
+		return
+	}
+
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.Text
+	}
+	s.Queue.append(out...)
+
+	s.infoLog <- fmt.Sprintf("Sending %d msg(s) from outbox.", len(msgs))
+}
+
+// Remove msg from the outbox file if it came from there, now that it's written.
+func (s *sock) outboxSent(msg string) {
+	if err := s.Outbox.sent(msg); err != nil {
+		s.errLog <- err
+	}
+}
+
 func (s *sock) disconnect() {
+	s.connMx.Lock()
 	select {
 	case <-s.closed:
+		s.connMx.Unlock()
+		return
 	default:
-		close(s.closed)
-		if s.Conn == nil {
-			s.Conn.Close()
-			s.Conn = nil
-		}
 	}
+
+	close(s.closed)
+	// Also unblocks the reader, which may be waiting on a msg.
+	if s.Conn != nil {
+		s.Conn.Close()
+		s.Conn = nil
+	}
+	s.connMx.Unlock()
+
+	s.setState(StateDisconnected, nil)
 }
 
 // Tries reconnecting 8 times.
 func (s *sock) reconnect(ctx context.Context) {
+	// Clear any stale wake from an earlier connect.
+	select {
+	case <-s.wake:
+	default:
+	}
+
 	for {
 		for i := 0; i < 8; i++ {
 			select {
@@ -225,29 +467,62 @@ func (s *sock) reconnect(ctx context.Context) {
 			default:
 			}
 
-			err := s.connect(ctx)
+			s.metrics.reconnects.Add(1)
+			err := s.dial(ctx, true)
 			if err == nil {
 				return
 			}
 
 			s.errLog <- err
-			time.Sleep(time.Second * 5)
+			if untrusted(err) {
+				// Retrying won't help until the user does something about it.
+				select {
+				case <-ctx.Done():
+					return
+				case <-s.wake:
+				}
+
+				// May have been connected some other way, like F5.
+				if !s.isClosed() {
+					return
+				}
+				i = -1
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second * 5):
+			}
 		}
 
 		s.infoLog <- "Failed to connect 8 times. Waiting 1 minute."
-		time.Sleep(time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
 	}
 }
 
 func (s *sock) read() ([]byte, error) {
+	conn, closed := s.current()
 	select {
-	case <-s.closed:
+	case <-closed:
 		return nil, &errSocketClosed{}
 	default:
 	}
 
-	_, msg, err := s.ReadMessage()
+	_, msg, err := conn.ReadMessage()
 	if err != nil {
+		// Disconnected on purpose while reading.
+		select {
+		case <-closed:
+			return nil, &errSocketClosed{}
+		default:
+		}
+
 		s.infoLog <- "Failed to read from socket.\n"
 		return nil, err
 		// s.reconnect(ctx)
@@ -262,11 +537,12 @@ func (s *sock) write(msg string) error {
 		return errors.New("Outgoing msg is empty.")
 	}
 
+	conn, closed := s.current()
 	select {
-	case <-s.closed:
+	case <-closed:
 		return &errSocketClosed{}
 	default:
-		return s.WriteMessage(websocket.TextMessage, out)
+		return conn.WriteMessage(websocket.TextMessage, out)
 	}
 }
 
@@ -274,87 +550,247 @@ func (s *sock) msgReader(ctx context.Context) {
 	// Flag for session cookie refresh attempt.
 	var refreshed bool
 	for {
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			return
-		case <-s.closed:
+		}
+		if s.isClosed() {
 			s.reconnect(ctx)
-		default:
 		}
 
 		msg, err := s.read()
 		if err != nil {
+			// Closed on purpose. Reconnected at the top of the loop if nobody else did.
+			if errors.As(err, new(*errSocketClosed)) {
+				continue
+			}
+
 			s.errLog <- err
-			s.reconnect(ctx)
+			// Mark the socket closed first, so the sender stops writing to the dead conn.
+			s.disconnect()
+			s.setState(StateDisconnected, err)
+			s.hooks.fire(ctx, HookEvent{Event: HookDisconnect, Room: s.room(), Error: err.Error()})
 
 			continue
 		}
 
 		// Server sometimes sends plaintext messages to client.
 		// This typically happens when it sends error messages.
-		switch ms := string(msg); {
-		case json.Valid(msg):
+		if json.Valid(msg) {
 			// Reset cookie refresh flag if chat messages were read successfully.
 			refreshed = false
+			// The server only answers a /join with msgs and users if it worked.
+			if s.joining.CompareAndSwap(true, false) {
+				s.flushOutbox()
+			}
 			go s.ParseResponse(ctx, msg)
-		case strings.Contains(ms, "cannot join"):
+			continue
+		}
+
+		ms := string(msg)
+		serr := ClassifyServerMsg(ms)
+		if serr == nil {
+			s.infoLog <- ms
+			continue
+		}
+		s.errLog <- serr
+
+		p, _ := PolicyOf(serr)
+		switch p {
+		case PolicyRefresh:
 			if refreshed {
+				s.errLog <- &ErrAuthFailed{ms}
 				s.infoLog <- "Unable to join chat. Cookies possibly expired. Try providing new ones."
 				// Wait until context close (quit).
 				<-ctx.Done()
+				return
 			}
 
 			s.infoLog <- "Session expired. Refreshing token..."
 			refreshed = true
 
 			_, err := s.kf.RefreshSession(ctx)
+			s.metrics.refreshed(err)
 			if err != nil {
 				s.errLog <- err
+				s.hooks.fire(ctx, HookEvent{Event: HookRefresh, Room: s.room(), Error: err.Error()})
 				continue
 			}
-			s.Cfg.Cookies = s.kf.Client.Jar.(*libkiwi.KiwiJar).CookieString(s.host)
+			s.hooks.fire(ctx, HookEvent{Event: HookRefresh, Room: s.room()})
+			cookies := s.kf.Client.Jar.(*libkiwi.KiwiJar).CookieString(s.host)
+			cfg := s.updateConfig(func(cfg *config.Config) {
+				cfg.Cookies = cookies
+			})
+			// Persist refreshed cookies right away if they're kept in the encrypted store.
+			// Plain configs get them saved on exit instead.
+			if err = cfg.SaveSecrets(); err != nil {
+				s.errLog <- err
+			}
 
+			s.disconnect()
 			s.reconnect(ctx)
-		default:
-			s.infoLog <- ms
-		}
+		case PolicyRetry:
+			s.infoLog <- fmt.Sprintf("%s Retrying in 30 seconds.", serr)
+			s.disconnect()
+			s.setState(StateDisconnected, serr)
+			s.hooks.fire(ctx, HookEvent{Event: HookDisconnect, Room: s.room(), Error: serr.Error()})
 
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(30 * time.Second):
+			}
+			s.reconnect(ctx)
+		case PolicyStop:
+			s.infoLog <- fmt.Sprintf("%s Giving up.", serr)
+			s.disconnect()
+			s.setState(StateStopped, serr)
+			s.hooks.fire(ctx, HookEvent{Event: HookDisconnect, Room: s.room(), Error: serr.Error()})
+			<-ctx.Done()
+			return
+		case PolicyNotify:
+			s.infoLog <- serr.Error()
+		}
 	}
 }
 
+// Join msg regex.
+var joinRE = regexp.MustCompile(`^/join \d+`)
+
 func (s *sock) router(ctx context.Context) {
-	// Join msg regex.
-	joinRE := regexp.MustCompile(`^/join \d+`)
-	greenRE := regexp.MustCompile(`^>\w`)
+	go s.sender(ctx)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case msg := <-s.Out:
+			msg = strings.TrimSpace(msg)
 			isJoin := joinRE.MatchString(msg)
-			if msg == "" || (s.Cfg.ReadOnly && !isJoin) {
+			if msg == "" {
+				continue
+			}
+			cfg := s.Config()
+			if cfg.ReadOnly && !isJoin {
+				s.sends.routed(msg, cfg.Room, routeReadOnly, false)
 				continue
 			}
 
-			switch {
-			case isJoin:
+			if isJoin {
 				room, err := strconv.Atoi(strings.Split(msg, " ")[1])
 				if err != nil {
 					s.errLog <- err
 					continue
 				}
-				s.Cfg.Room = uint(room)
-			case greenRE.MatchString(msg):
-				msg = fmt.Sprintf("[color=%s]%s", GREEN, msg)
+				s.updateConfig(func(cfg *config.Config) {
+					cfg.Room = uint(room)
+				})
+
+				// Joins go first so queued msgs end up in the right room.
+				s.Queue.pushFront(msg)
+				continue
 			}
 
-			err := s.write(msg)
-			if err != nil {
-				s.infoLog <- fmt.Sprintf("Failed to send: %s\n", msg)
-				s.errLog <- err
+			if s.isClosed() {
+				if err := s.Outbox.add(msg); err != nil {
+					s.errLog <- err
+					continue
+				}
+				s.sends.routed(msg, cfg.Room, routeOutbox, false)
+				s.infoLog <- "Not connected. Msg saved to outbox."
+				continue
+			}
 
-				continue // To help prevent future fuckups.
+			if !s.Queue.push(msg) {
+				s.sends.routed(msg, cfg.Room, routeCoalesced, false)
+				s.debug <- fmt.Sprintf("Dropped duplicate msg: %s", msg)
+				continue
+			}
+			s.sends.routed(msg, cfg.Room, routeQueued, s.Users.ClientKnown())
+			s.metrics.outgoing.Add(1)
+		}
+	}
+}
+
+var greenRE = regexp.MustCompile(`^>\w`)
+
+// Apply client-side formatting to an outgoing msg, such as greentext.
+// The server echoes the formatted text back, so it's also used for matching sent msgs.
+func formatOutgoing(msg string) string {
+	msg = strings.TrimSpace(msg)
+	if greenRE.MatchString(msg) {
+		msg = fmt.Sprintf("[color=%s]%s", GREEN, msg)
+	}
+
+	return msg
+}
+
+// Bounds of the wait between attempts at writing a msg after a failed write.
+const (
+	_WRITE_BACKOFF_MIN = time.Second
+	_WRITE_BACKOFF_MAX = 30 * time.Second
+)
+
+// Write queued msgs to the socket, pacing them with the rate limiter.
+// Msgs stay queued until they're written, so they go out after reconnecting.
+func (s *sock) sender(ctx context.Context) {
+	backoff := _WRITE_BACKOFF_MIN
+	wait := func() bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, _WRITE_BACKOFF_MAX)
+		return true
+	}
+
+	for {
+		msg, ok := s.Queue.peek()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.Queue.ready:
+			}
+			continue
+		}
+
+		// Would never be written.
+		if strings.TrimSpace(msg) == "" {
+			s.Queue.pop()
+			s.outboxSent(msg)
+			continue
+		}
+
+		if err := s.limiter.wait(ctx); err != nil {
+			return
+		}
+
+		// Queued msgs are kept as typed, so they can be saved to the outbox as they are.
+		err := s.write(formatOutgoing(msg))
+		var sc *errSocketClosed
+		switch {
+		case err == nil:
+			backoff = _WRITE_BACKOFF_MIN
+			s.Queue.pop()
+			s.outboxSent(msg)
+			if joinRE.MatchString(msg) {
+				s.joining.Store(true)
+			}
+		case errors.As(err, &sc):
+			// Wait for reconnect without dropping the msg.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		default:
+			// The conn is no good after a failed write. Mark it closed so the reader reconnects,
+			// and keep the msg for the new one.
+			s.errLog <- err
+			s.disconnect()
+			if !wait() {
+				return
 			}
 		}
 	}
@@ -383,7 +819,17 @@ func (s *sock) start(ctx context.Context) {
 
 func (s *sock) stop() {
 	s.disconnect()
+
+	// Keep anything that didn't make it out for the next session.
+	rest := slices.DeleteFunc(s.Queue.drain(), joinRE.MatchString)
+	if err := s.Outbox.requeue(rest); err != nil {
+		s.errLog <- err
+	}
+
+	s.transportMx.Lock()
 	if s.proxy != nil {
 		s.proxy.stopTor()
 	}
+	s.transportMx.Unlock()
+	s.setState(StateClosed, nil)
 }
@@ -0,0 +1,66 @@
+package chat
+
+import "sync"
+
+// Where the connection to the chat server is at.
+type ConnState uint8
+
+const (
+	// Not connected yet, or waiting to reconnect.
+	StateDisconnected ConnState = iota
+	StateConnecting
+	StateConnected
+	// Gave up after an error that retrying won't fix, like a ban.
+	StateStopped
+	// Shut down for good.
+	StateClosed
+)
+
+func (cs ConnState) String() string {
+	switch cs {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateStopped:
+		return "stopped"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Tracks the ConnState and the error that caused it, if any.
+type connState struct {
+	mx    sync.Mutex
+	state ConnState
+	err   error
+	// Closed and replaced on every change.
+	changed chan struct{}
+}
+
+func newConnState() *connState {
+	return &connState{
+		changed: make(chan struct{}),
+	}
+}
+
+func (cs *connState) set(state ConnState, err error) {
+	cs.mx.Lock()
+	defer cs.mx.Unlock()
+
+	cs.state, cs.err = state, err
+	close(cs.changed)
+	cs.changed = make(chan struct{})
+}
+
+// Get the current state, along with a chan that's closed when it changes.
+func (cs *connState) get() (ConnState, error, <-chan struct{}) {
+	cs.mx.Lock()
+	defer cs.mx.Unlock()
+
+	return cs.state, cs.err, cs.changed
+}
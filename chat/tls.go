@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"y-a-t-s/sockchat/config"
+)
+
+// Build the TLS config for connections to host, from the tls section of cfg.
+func newTLSConfig(cfg config.Config, host string) (*tls.Config, error) {
+	minVer, err := cfg.TLSMinVersion()
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{MinVersion: minVer}
+
+	if cfg.TLS.CAFile != "" {
+		b, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("No certs found in %s.", cfg.TLS.CAFile)
+		}
+	}
+
+	if len(cfg.TLS.Pins) == 0 {
+		return tc, nil
+	}
+
+	pins := make([][]byte, 0, len(cfg.TLS.Pins))
+	for _, p := range cfg.TLS.Pins {
+		b, err := config.ParsePin(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid TLS pin %q: %w", p, err)
+		}
+		pins = append(pins, b)
+	}
+
+	// Runs after the usual chain verification, so only verified chains are checked.
+	tc.VerifyConnection = func(cs tls.ConnectionState) error {
+		// Pins only cover the chat host, not anything it redirects to.
+		// There's no server name when connecting to an IP, so those are always checked.
+		if cs.ServerName != "" && !strings.EqualFold(cs.ServerName, host) {
+			return nil
+		}
+
+		seen := make([]string, 0, 3)
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, p := range pins {
+					if slices.Equal(sum[:], p) {
+						return nil
+					}
+				}
+
+				if h := base64.StdEncoding.EncodeToString(sum[:]); !slices.Contains(seen, h) {
+					seen = append(seen, h)
+				}
+			}
+		}
+
+		return &ErrPinMismatch{host, seen}
+	}
+
+	return tc, nil
+}
@@ -0,0 +1,209 @@
+package chat
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"y-a-t-s/sockchat/config"
+)
+
+// Start a TLS websocket server. maxVer limits its TLS version if set.
+func startTLSServer(t *testing.T, maxVer uint16) *httptest.Server {
+	t.Helper()
+
+	up := websocket.Upgrader{}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := up.Upgrade(w, r, nil); err == nil {
+			conn.Close()
+		}
+	}))
+	srv.TLS = &tls.Config{MaxVersion: maxVer}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// Write certs to a PEM file in a temp dir.
+func writePEM(t *testing.T, certs ...*x509.Certificate) string {
+	t.Helper()
+
+	var sb strings.Builder
+	for _, c := range certs {
+		pem.Encode(&sb, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, []byte(sb.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// Make a self-signed CA that didn't sign anything the test server uses.
+func otherCA(t *testing.T) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Other CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestTLSConfigDial(t *testing.T) {
+	srv := startTLSServer(t, 0)
+	tls12 := startTLSServer(t, tls.VersionTLS12)
+	serverCA := writePEM(t, srv.Certificate())
+	wrongPin := "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	tests := []struct {
+		name string
+		srv  *httptest.Server
+		// Host the pins are for. Defaults to the server's.
+		host string
+		// Name to verify the server's cert against, like a host the chat redirects to.
+		// Left empty, the IP is used.
+		serverName string
+		ca         string
+		pins       []string
+		minVer     string
+		// Set if the dial must fail.
+		wantErr bool
+		// Set if the error must stop reconnecting until the user acts.
+		wantUntrusted bool
+	}{
+		{name: "custom CA", srv: srv, ca: serverCA},
+		{name: "matching pin", srv: srv, ca: serverCA, pins: []string{spkiPin(srv.Certificate())}},
+		{name: "one of several pins", srv: srv, ca: serverCA, pins: []string{wrongPin, spkiPin(srv.Certificate())}},
+		{name: "wrong pin", srv: srv, ca: serverCA, pins: []string{wrongPin}, wantErr: true, wantUntrusted: true},
+		{name: "wrong pin by name", srv: srv, host: "example.com", serverName: "example.com", ca: serverCA,
+			pins: []string{wrongPin}, wantErr: true, wantUntrusted: true},
+		{name: "pins only cover the chat host", srv: srv, host: "chat.example.com", serverName: "example.com", ca: serverCA,
+			pins: []string{wrongPin}},
+		{name: "CA bundle without the server's", srv: srv, ca: writePEM(t, otherCA(t)), wantErr: true},
+		{name: "CA bundle without the server's and a pin", srv: srv, ca: writePEM(t, otherCA(t)),
+			pins: []string{spkiPin(srv.Certificate())}, wantErr: true},
+		{name: "TLS 1.2 allowed", srv: tls12, ca: writePEM(t, tls12.Certificate()), minVer: "1.2"},
+		{name: "TLS 1.2 below minimum", srv: tls12, ca: writePEM(t, tls12.Certificate()), minVer: "1.3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.TLS.CAFile = tt.ca
+			cfg.TLS.Pins = tt.pins
+			if tt.minVer != "" {
+				cfg.TLS.MinVersion = tt.minVer
+			}
+
+			host := tt.host
+			if host == "" {
+				host = "127.0.0.1"
+			}
+			tc, err := newTLSConfig(cfg, host)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tc.ServerName = tt.serverName
+
+			d := websocket.Dialer{TLSClientConfig: tc, HandshakeTimeout: 5 * time.Second}
+			conn, _, err := d.Dial("wss"+strings.TrimPrefix(tt.srv.URL, "https"), nil)
+			if err == nil {
+				conn.Close()
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error: %v", err, tt.wantErr)
+			}
+			var pm *ErrPinMismatch
+			if errors.As(err, &pm) != tt.wantUntrusted {
+				t.Fatalf("got %v, want ErrPinMismatch: %v", err, tt.wantUntrusted)
+			}
+			// Untrusted errors make the reconnect loop wait for the user, and show up as alerts.
+			if untrusted(err) != tt.wantUntrusted {
+				t.Fatalf("untrusted(%v) = %v, want %v", err, !tt.wantUntrusted, tt.wantUntrusted)
+			}
+			s := &sock{alerts: make(chan error, 1)}
+			s.alert(err)
+			if (len(s.alerts) == 1) != tt.wantUntrusted {
+				t.Fatalf("got %d alerts, want alert: %v", len(s.alerts), tt.wantUntrusted)
+			}
+			if pm != nil && (pm.Host != host || len(pm.Got) == 0 || !strings.Contains(pm.Error(), strings.TrimPrefix(spkiPin(srv.Certificate()), "sha256/"))) {
+				t.Fatalf("mismatch doesn't list the server's key: %v", pm)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(cfg *config.Config)
+		wantErr string
+	}{
+		{"missing CA file", func(cfg *config.Config) { cfg.TLS.CAFile = filepath.Join(t.TempDir(), "nope.pem") }, "no such file"},
+		{"CA file without certs", func(cfg *config.Config) { cfg.TLS.CAFile = notPEM }, "No certs found"},
+		{"bad pin", func(cfg *config.Config) { cfg.TLS.Pins = []string{"sha256/short"} }, "Invalid TLS pin"},
+		{"bad min version", func(cfg *config.Config) { cfg.TLS.MinVersion = "1.0" }, "must be 1.2 or 1.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			tt.edit(&cfg)
+
+			_, err := newTLSConfig(cfg, "127.0.0.1")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
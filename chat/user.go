@@ -2,9 +2,11 @@ package chat
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type User struct {
@@ -13,7 +15,7 @@ type User struct {
 	AvatarURL string `json:"avatar_url"`
 
 	color string
-	pool  *ChatPool
+	pool  *chatPool
 }
 
 func (u *User) Color() string {
@@ -59,13 +61,15 @@ func (u *User) String(fl string) string {
 }
 
 type clientUser struct {
-	ID       uint32
+	ID uint32
+	// Only set once found is closed.
 	Username string
 	found    chan struct{}
+	once     sync.Once
 }
 
-func newClientUser(id uint32) clientUser {
-	return clientUser{
+func newClientUser(id uint32) *clientUser {
+	return &clientUser{
 		ID:    id,
 		found: make(chan struct{}),
 	}
@@ -73,33 +77,57 @@ func newClientUser(id uint32) clientUser {
 
 type userTable struct {
 	sync.Map
-	Client clientUser
+	// Replaced when user_id changes, so it's read through client().
+	cu atomic.Pointer[clientUser]
 }
 
-// TODO: Make this value passing less stupid.
 func NewUserTable(clientID uint32) *userTable {
-	return &userTable{
-		Client: newClientUser(clientID),
-	}
+	ut := &userTable{}
+	ut.cu.Store(newClientUser(clientID))
+
+	return ut
+}
+
+func (ut *userTable) client() *clientUser {
+	return ut.cu.Load()
+}
+
+// Start looking for a different user's record, such as after user_id changes.
+func (ut *userTable) setClient(id uint32) {
+	ut.cu.Store(newClientUser(id))
+}
+
+// ID of the client's own user.
+func (ut *userTable) ClientID() uint32 {
+	return ut.client().ID
+}
+
+// Check if the client's user ID is known. An unset user_id of -1 wraps around to the max ID.
+func (ut *userTable) ClientKnown() bool {
+	return ut.ClientID() != math.MaxUint32
 }
 
 func (ut *userTable) ClientName() string {
+	cu := ut.client()
 	select {
-	case <-ut.Client.found:
-		return ut.Client.Username
+	case <-cu.found:
+		return cu.Username
 	default:
 		return ""
 	}
 }
 
+// Closed once the client's own user record is received.
+func (ut *userTable) ClientFound() <-chan struct{} {
+	return ut.client().found
+}
+
 func (ut *userTable) AddUser(u *User) *User {
-	select {
-	case <-ut.Client.found:
-	default:
-		if ut.Client.ID == u.ID {
-			ut.Client.Username = u.Username
-			close(ut.Client.found)
-		}
+	if cu := ut.client(); cu.ID == u.ID {
+		cu.once.Do(func() {
+			cu.Username = u.Username
+			close(cu.found)
+		})
 	}
 
 	// Ensure we don't store User sourced from pool.
@@ -3,26 +3,78 @@ package config
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 )
 
+// Apply overrides from SOCKCHAT_* env vars, then args, on top of the values from the file.
+// See options for the full list and order of precedence.
 func (cfg *Config) ParseArgs() error {
+	return cfg.parseArgs(nil)
+}
+
+// Like ParseArgs, for a profile re-read from config.json during a reload.
+// Cookies prev imported with --cookies-from are reused instead of reading the browser's store again.
+func (cfg *Config) ReparseArgs(prev Config) error {
+	return cfg.parseArgs(&prev)
+}
+
+func (cfg *Config) parseArgs(prev *Config) error {
+	if err := cfg.applyEnv(); err != nil {
+		return err
+	}
+
 	flags := flag.NewFlagSet("SockChat", flag.ContinueOnError)
-	flags.StringVar(&cfg.Cookies, "cookies", cfg.Cookies, "Set cookies used to connect.")
-	flags.StringVar(&cfg.Host, "host", cfg.Host, "Specify hostname to connect to.")
-	flags.BoolVar(&cfg.Logger, "log", cfg.Logger, "Enable chat logger.")
-	flags.UintVar(&cfg.Port, "port", cfg.Port, "Specify outgoing socket port.")
-	flags.UintVar(&cfg.Room, "room", cfg.Room, "Room to join by default.")
-	flags.BoolVar(&cfg.Tor.Enabled, "tor", cfg.Tor.Enabled, "Connect through Tor network.")
-	flags.BoolVar(&cfg.ReadOnly, "ro", cfg.ReadOnly, "Read-only (lurker) mode.")
+	cfg.registerFlags(flags)
+	cookiesFrom := flags.String("cookies-from", "", "Import cookies from a cookies.txt file or a Firefox/Chromium profile.")
+	// Already applied by LoadConfig. Defined so the flag parser accepts it.
+	flags.String("profile", cfg.Profile, "Config profile to use. (env: SOCKCHAT_PROFILE)")
 	// flags.BoolVar(&cfg.ApiMode, "api", cfg.ApiMode, "Start in API mode. See the documentation.")
-	flags.Parse(os.Args[1:])
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		return err
+	}
 
-	switch {
-	case cfg.Cookies == "":
+	byFlag := make(map[string]string, len(options))
+	for _, o := range options {
+		byFlag[o.flagName()] = o.key
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if key, ok := byFlag[f.Name]; ok {
+			cfg.setOverridden(key)
+		}
+	})
+
+	if *cookiesFrom != "" {
+		if prev != nil && prev.imported {
+			cfg.Cookies = prev.Cookies
+		} else if err := cfg.ImportCookies(*cookiesFrom); err != nil {
+			return err
+		}
+		cfg.setOverridden("cookies")
+		cfg.imported = true
+	}
+
+	// Each account needs its own credentials, so they can't come from the shared env and args.
+	if len(ProfileArgs()) > 1 {
+		var shared []string
+		for _, o := range options {
+			if o.credential && cfg.Overridden(o.key) {
+				shared = append(shared, o.key)
+			}
+		}
+		if len(shared) > 0 {
+			return fmt.Errorf("%s can't be given by env var or flag when running several profiles. Set them in each profile instead.", strings.Join(shared, ", "))
+		}
+	}
+
+	if cfg.Cookies == "" {
 		return errors.New("No cookies found. Set them in the config file or pass them as an argument.")
-	case cfg.Host == "":
-		return errors.New("Hostname not defined.")
+	}
+
+	// Checked after parsing so args can override bad values from the file.
+	if err := cfg.Validate(); err != nil {
+		return &ErrProfile{cfg.Profile, err}
 	}
 
 	return nil
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseArgsSharedCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "one profile", args: []string{"--profile", "main", "--cookies", "a=1"}},
+		{name: "several profiles", args: []string{"--profile", "main,alt", "--room", "2"}},
+		{name: "cookies flag", args: []string{"--profile", "main,alt", "--cookies", "a=1"}, wantErr: "cookies"},
+		{name: "proxy password flag", args: []string{"--profile=main,alt", "--proxy-password", "x"}, wantErr: "proxy.password"},
+		{name: "cookies env", args: []string{"--profile", "main,alt"},
+			env: map[string]string{"SOCKCHAT_COOKIES": "a=1"}, wantErr: "cookies"},
+		{name: "profile env", env: map[string]string{"SOCKCHAT_PROFILE": "main,alt", "SOCKCHAT_IRC_PASSWORD": "x"},
+			wantErr: "irc.password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setArgs(t, tt.args...)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			cfg := NewConfig()
+			cfg.Cookies = "file=1"
+			err := cfg.ParseArgs()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want an error naming %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReparseArgsKeepsImport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	txt := "kiwifarms.st\tFALSE\t/\tTRUE\t0\txf_user\tu1\nkiwifarms.st\tFALSE\t/\tTRUE\t0\txf_session\ts1\n"
+	if err := os.WriteFile(path, []byte(txt), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setArgs(t, "--cookies-from", path)
+
+	cfg := NewConfig()
+	cfg.Host = "kiwifarms.st"
+	if err := cfg.ParseArgs(); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Overridden("cookies") {
+		t.Fatal("imported cookies weren't marked as overridden")
+	}
+
+	// A reload mustn't read the store again.
+	os.Remove(path)
+	fresh := NewConfig()
+	fresh.Host = "kiwifarms.st"
+	if err := fresh.ReparseArgs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Cookies != cfg.Cookies {
+		t.Fatalf("got cookies %q, want %q", fresh.Cookies, cfg.Cookies)
+	}
+}
+
+func setArgs(t *testing.T, args ...string) {
+	t.Helper()
+
+	old := os.Args
+	os.Args = append([]string{"sockchat"}, args...)
+	t.Cleanup(func() { os.Args = old })
+}
@@ -3,7 +3,10 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
 	"sync"
@@ -20,19 +23,65 @@ type Config struct {
 	ReadOnly bool   `json:"read_only"`
 	Room     uint   `json:"room"`
 	UserID   int    `json:"user_id"`
-
-	Proxy proxyConfig `json:"proxy"`
-	Tor   torConfig   `json:"tor"`
+	// Keep cookies and proxy credentials in a passphrase-encrypted file instead.
+	EncryptSecrets bool `json:"encrypt_secrets"`
+
+	Hooks     hooksConfig     `json:"hooks"`
+	HTTP      httpConfig      `json:"http"`
+	IRC       ircConfig       `json:"irc"`
+	Metrics   metricsConfig   `json:"metrics"`
+	Notify    notifyConfig    `json:"notify"`
+	Proxy     proxyConfig     `json:"proxy"`
+	RateLimit rateLimitConfig `json:"rate_limit"`
+	Scripts   scriptsConfig   `json:"scripts"`
+	TLS       tlsConfig       `json:"tls"`
+	Tor       torConfig       `json:"tor"`
 
 	// Used for collecting remaining args.
 	Args []string `json:",omitempty"`
 	mx   *sync.Mutex
+
+	// Name of the loaded profile.
+	Profile string `json:"-"`
+	// Keys of options set by env vars or flags instead of the file.
+	overrides []string
+	// Set if Cookies were imported with --cookies-from.
+	imported bool
+
+	// Set if EncryptSecrets is enabled.
+	secrets *secretStore
+	// Contents of config.json, including other profiles.
+	file *profileFile
 }
 
 type torConfig struct {
 	Enabled  bool   `json:"enabled"`
 	Onion    string `json:"onion_host"`
 	Clearnet bool   `json:"clearnet_over_tor"`
+
+	// SOCKS port of a Tor daemon that's already running, like 127.0.0.1:9050.
+	// An embedded Tor is started if this and ControlAddr are empty, or if it can't be reached.
+	SocksAddr string `json:"socks_address"`
+	// Control port of a running Tor daemon, like 127.0.0.1:9051 or unix:/run/tor/control.
+	// Used for NEWNYM, and to find the SOCKS port if SocksAddr isn't set.
+	ControlAddr string `json:"control_address"`
+	// Only needed if the control port uses HashedControlPassword.
+	// Cookie authentication is used automatically when available.
+	ControlPass string `json:"control_password"`
+
+	// The rest only apply to the embedded Tor.
+
+	// Keep Tor's state between runs so it doesn't have to bootstrap from scratch.
+	Persist bool `json:"persist_data"`
+	// Defaults to a tor dir in ConfigDir if empty.
+	DataDir string `json:"data_directory"`
+	// Bridge lines, like "obfs4 1.2.3.4:443 FINGERPRINT cert=... iat-mode=0".
+	Bridges []string `json:"bridges"`
+	// Path to a pluggable transport binary, like obfs4proxy or lyrebird.
+	// Used for the transports named in Bridges.
+	TransportPlugin string `json:"transport_plugin"`
+	// Extra torrc lines, like "ExitNodes {us}".
+	Torrc []string `json:"torrc"`
 }
 
 func newTorConfig() torConfig {
@@ -40,6 +89,16 @@ func newTorConfig() torConfig {
 		Enabled:  false,
 		Onion:    "kiwifarmsaaf4t2h7gc3dfc5ojhmqruw2nit3uejrpiagrxeuxiyxcyd.onion",
 		Clearnet: false,
+
+		SocksAddr:   "",
+		ControlAddr: "",
+		ControlPass: "",
+
+		Persist:         true,
+		DataDir:         "",
+		Bridges:         []string{},
+		TransportPlugin: "",
+		Torrc:           []string{},
 	}
 }
 
@@ -50,46 +109,309 @@ type proxyConfig struct {
 	Pass    string `json:"password"`
 }
 
-func openConfig() (*os.File, error) {
+// Commands run on chat events, with the event as JSON on stdin.
+// Empty commands are skipped.
+type hooksConfig struct {
+	OnMention string `json:"on_mention"`
+	// Run for msgs from the users in Users.
+	OnUserMessage string `json:"on_user_message"`
+	// Usernames or numeric user IDs watched by OnUserMessage.
+	Users        []string `json:"users"`
+	OnConnect    string   `json:"on_connect"`
+	OnDisconnect string   `json:"on_disconnect"`
+	OnRefresh    string   `json:"on_refresh"`
+	// Seconds a hook may run before it's killed.
+	Timeout float64 `json:"timeout"`
+	// Most hooks running at once. Events past that are skipped.
+	MaxConcurrent uint `json:"max_concurrent"`
+}
+
+func newHooksConfig() hooksConfig {
+	return hooksConfig{
+		OnMention:     "",
+		OnUserMessage: "",
+		Users:         []string{},
+		OnConnect:     "",
+		OnDisconnect:  "",
+		OnRefresh:     "",
+		Timeout:       10,
+		MaxConcurrent: 4,
+	}
+}
+
+// Headers sent with the socket handshake and every other request to the site.
+type httpConfig struct {
+	// Name of a preset in BrowserPresets, for the User-Agent and matching headers.
+	Browser string `json:"browser"`
+	// Overrides the preset's User-Agent if set.
+	UserAgent string `json:"user_agent"`
+	// Extra headers, like "Accept-Language: en-US". Override the preset's.
+	Headers []string `json:"headers"`
+}
+
+func newHTTPConfig() httpConfig {
+	return httpConfig{
+		Browser:   "firefox",
+		UserAgent: "",
+		Headers:   []string{},
+	}
+}
+
+// Notifications for mentions.
+type notifyConfig struct {
+	Enabled bool `json:"enabled"`
+	// Ways to notify: beeep, bell, osc9, osc777, exec and webhook.
+	Backends []string `json:"backends"`
+	// Command run by the exec backend, with the title and body added as args.
+	Command string `json:"command"`
+	// URL the webhook backend POSTs JSON to, like http://127.0.0.1:8080/notify.
+	Webhook string `json:"webhook"`
+	// Most notifications per minute. 0 for no limit.
+	PerMinute uint `json:"per_minute"`
+	// Local time range to stay quiet in, like "23:00-07:00". Empty to disable.
+	QuietHours string `json:"quiet_hours"`
+	// Rooms to notify for. Empty for every room.
+	Rooms []uint `json:"rooms"`
+	// Skip notifying while the room is on screen in a focused terminal.
+	SuppressFocused bool `json:"suppress_when_focused"`
+}
+
+func newNotifyConfig() notifyConfig {
+	return notifyConfig{
+		Enabled:         true,
+		Backends:        []string{"beeep"},
+		Command:         "",
+		Webhook:         "",
+		PerMinute:       6,
+		QuietHours:      "",
+		Rooms:           []uint{},
+		SuppressFocused: true,
+	}
+}
+
+// IRC server for using the chat from an IRC client.
+type ircConfig struct {
+	Enabled bool `json:"enabled"`
+	// Must be on a loopback interface.
+	Addr string `json:"address"`
+	// Required from clients with PASS.
+	Pass string `json:"password"`
+}
+
+func newIRCConfig() ircConfig {
+	return ircConfig{
+		Enabled: false,
+		Addr:    "127.0.0.1:6667",
+		Pass:    "",
+	}
+}
+
+// Prometheus endpoint for monitoring the client.
+type metricsConfig struct {
+	Enabled bool `json:"enabled"`
+	// Must be on a loopback interface, since there's no auth.
+	Addr string `json:"address"`
+}
+
+func newMetricsConfig() metricsConfig {
+	return metricsConfig{
+		Enabled: false,
+		Addr:    "127.0.0.1:9464",
+	}
+}
+
+// Lua scripts for bots and custom commands.
+type scriptsConfig struct {
+	Enabled bool `json:"enabled"`
+	// Defaults to a scripts dir in ConfigDir if empty.
+	Dir string `json:"directory"`
+	// File names of scripts allowed to use the io and os libs. The rest are sandboxed.
+	Trusted []string `json:"trusted"`
+	// Seconds a script may run at a time before it's stopped.
+	Timeout float64 `json:"timeout"`
+}
+
+func newScriptsConfig() scriptsConfig {
+	return scriptsConfig{
+		Enabled: false,
+		Dir:     "",
+		Trusted: []string{},
+		Timeout: 5,
+	}
+}
+
+// TLS settings for connections to the chat host.
+type tlsConfig struct {
+	// PEM file with the CAs to trust instead of the system ones.
+	CAFile string `json:"ca_file"`
+	// Base64 SHA-256 hashes of trusted public keys (SPKI), like HPKP pin-sha256 values.
+	// If any are set, the cert chain must include one of them.
+	Pins []string `json:"pins"`
+	// Lowest TLS version to accept. Either "1.2" or "1.3".
+	MinVersion string `json:"min_version"`
+}
+
+func newTLSConfig() tlsConfig {
+	return tlsConfig{
+		CAFile:     "",
+		Pins:       []string{},
+		MinVersion: "1.2",
+	}
+}
+
+// Outgoing msg rate limiting. Helps avoid getting kicked for flooding.
+type rateLimitConfig struct {
+	Enabled bool `json:"enabled"`
+	// Max msgs sent back-to-back before limiting kicks in.
+	Burst uint `json:"burst"`
+	// Sustained msgs per second.
+	PerSecond float64 `json:"per_second"`
+	// Identical msgs queued within this many seconds are sent once.
+	CoalesceWindow float64 `json:"coalesce_window"`
+}
+
+func newRateLimitConfig() rateLimitConfig {
+	return rateLimitConfig{
+		Enabled:        true,
+		Burst:          4,
+		PerSecond:      1,
+		CoalesceWindow: 3,
+	}
+}
+
+func configPath() (string, error) {
 	cfgDir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cfgDir, "config.json"), nil
+}
+
+func openConfig() (*os.File, error) {
+	cfgPath, err := configPath()
 	if err != nil {
 		return nil, err
 	}
-	cfgPath := filepath.Join(cfgDir, "config.json")
 
-	return os.OpenFile(cfgPath, os.O_CREATE|os.O_RDWR, 0644)
+	// May contain cookies, so keep it readable by the user only.
+	f, err := os.OpenFile(cfgPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// The mode above only applies to new files. Older versions wrote it readable by everyone.
+	if err = f.Chmod(0600); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
 }
 
 // Load user config from config.json file in the UserConfigDir provided by the os package.
+// Uses the first profile given with --profile, or the default profile if there isn't one.
+func LoadConfig() (Config, error) {
+	cfgs, err := LoadProfiles(ProfileArgs()...)
+	if err != nil {
+		return NewConfig(), err
+	}
+
+	return cfgs[0], nil
+}
+
+// Load the named profile from config.json. An empty name loads the default profile.
+func LoadProfile(name string) (Config, error) {
+	cfgs, err := LoadProfiles(name)
+	if err != nil {
+		return NewConfig(), err
+	}
+
+	return cfgs[0], nil
+}
+
+// Load the named profiles from config.json. With no names, the default profile is loaded.
 // Creates the file and fills it with defaults from newConfig if it isn't found.
 // Adds missing option keys, if any, with defaults to file.
-func LoadConfig() (Config, error) {
-	// Generate Config from template.
-	// JSON decode will set any existing values.
-	// New keys get set to defaults and saved.
-	cfg := NewConfig()
+// The returned Configs share the underlying file, so saving one keeps the others intact.
+func LoadProfiles(names ...string) ([]Config, error) {
+	if len(names) == 0 {
+		names = []string{""}
+	}
 
-	cfg.mx.Lock()
-	defer cfg.mx.Unlock()
+	mx := &sync.Mutex{}
+	mx.Lock()
+	defer mx.Unlock()
 
 	f, err := openConfig()
 	if err != nil {
-		return cfg, err
+		return nil, err
 	}
 	defer f.Close()
 
-	// Decode JSON from file descriptor.
-	jd := json.NewDecoder(f)
-	for jd.More() {
-		if err = jd.Decode(&cfg); err != nil {
-			return cfg, err
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	pf, from, err := parseProfileFile(b)
+	if err != nil {
+		return nil, err
+	}
+	// Keep a copy of the old file in case the migration goes wrong.
+	if from < SCHEMA_VERSION && len(b) > 0 {
+		if _, err = backupConfig(b, from); err != nil {
+			return nil, err
 		}
 	}
 
-	// Truncate and write loaded config with any potential new keys.
-	go cfg.writeConfig(f)
+	cfgs := make([]Config, len(names))
+	for i, name := range names {
+		if name == "" {
+			name = pf.DefaultProfile
+		}
+
+		// Generate Config from template.
+		// JSON decode will set any existing values.
+		// New keys get set to defaults and saved.
+		cfg := NewConfig()
+		cfg.mx = mx
+
+		vals, err := pf.resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		if err = cfg.unmarshalMap(vals); err != nil {
+			return nil, &ErrProfile{name, err}
+		}
+		cfg.Profile = name
+		cfg.file = pf
+
+		cfgs[i] = cfg
+	}
+
+	// Resolve every profile before writing, since writing may strip secrets from the base section.
+	var ss *secretStore
+	for i := range cfgs {
+		cfg := &cfgs[i]
+		if cfg.EncryptSecrets {
+			// Only ask for the passphrase once.
+			if ss == nil {
+				if ss, err = openSecretStore(); err != nil {
+					return nil, err
+				}
+			}
+			if err = cfg.loadSecrets(ss); err != nil {
+				return nil, err
+			}
+		}
+
+		// Truncate and write loaded config with any potential new keys.
+		if err = cfg.write(f); err != nil {
+			return nil, err
+		}
+	}
 
-	return cfg, nil
+	return cfgs, nil
 }
 
 // Get path string of user config dir.
@@ -110,6 +432,49 @@ func ConfigDir() (string, error) {
 	return cfgDir, nil
 }
 
+// Get the data dir for the embedded Tor, creating it if needed.
+// Returns an empty string if Tor's data shouldn't persist.
+func (cfg *Config) TorDataDir() (string, error) {
+	if !cfg.Tor.Persist {
+		return "", nil
+	}
+
+	dir := cfg.Tor.DataDir
+	if dir == "" {
+		cfgDir, err := ConfigDir()
+		if err != nil {
+			return "", err
+		}
+		// Tor locks its data dir, so concurrent profiles each need their own.
+		dir = filepath.Join(cfgDir, "tor"+cfg.ProfileSuffix())
+	}
+
+	// Tor refuses to use a data dir readable by others.
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// Get the dir scripts are loaded from, creating it if needed.
+func (cfg *Config) ScriptsDir() (string, error) {
+	dir := cfg.Scripts.Dir
+	if dir == "" {
+		cfgDir, err := ConfigDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(cfgDir, "scripts")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
 // Generate new Config from template.
 func NewConfig() Config {
 	return Config{
@@ -121,15 +486,123 @@ func NewConfig() Config {
 		Room:     1,
 		UserID:   -1,
 
+		Hooks:   newHooksConfig(),
+		HTTP:    newHTTPConfig(),
+		IRC:     newIRCConfig(),
+		Metrics: newMetricsConfig(),
+		Notify:  newNotifyConfig(),
 		Proxy: proxyConfig{
 			Enabled: false,
 			Addr:    "",
 			User:    "",
 			Pass:    "",
 		},
-		Tor: newTorConfig(),
-		mx:  &sync.Mutex{},
+		RateLimit: newRateLimitConfig(),
+		Scripts:   newScriptsConfig(),
+		TLS:       newTLSConfig(),
+		Tor:       newTorConfig(),
+		mx:        &sync.Mutex{},
+	}
+}
+
+// Collects type errors while decoding config values, instead of panicking on bad assertions.
+type cfgDecoder struct {
+	errs []error
+}
+
+func (d *cfgDecoder) typeErr(key string, expected string, v any) {
+	d.errs = append(d.errs, &ErrConfigType{key, expected, v})
+}
+
+func (d *cfgDecoder) str(key string, v any, dst *string) {
+	s, ok := v.(string)
+	if !ok {
+		d.typeErr(key, "a string", v)
+		return
+	}
+	*dst = s
+}
+
+func (d *cfgDecoder) boolean(key string, v any, dst *bool) {
+	b, ok := v.(bool)
+	if !ok {
+		d.typeErr(key, "a boolean (true or false)", v)
+		return
+	}
+	*dst = b
+}
+
+func (d *cfgDecoder) float(key string, v any, dst *float64) {
+	f, ok := v.(float64)
+	if !ok {
+		d.typeErr(key, "a number", v)
+		return
+	}
+	*dst = f
+}
+
+func (d *cfgDecoder) int(key string, v any, dst *int) {
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) {
+		d.typeErr(key, "a whole number", v)
+		return
 	}
+	*dst = int(f)
+}
+
+func (d *cfgDecoder) uint(key string, v any, dst *uint) {
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) || f < 0 {
+		d.typeErr(key, "a non-negative whole number", v)
+		return
+	}
+	*dst = uint(f)
+}
+
+func (d *cfgDecoder) strs(key string, v any, dst *[]string) {
+	arr, ok := v.([]any)
+	if !ok {
+		d.typeErr(key, "an array of strings", v)
+		return
+	}
+
+	out := make([]string, 0, len(arr))
+	for i, e := range arr {
+		s, ok := e.(string)
+		if !ok {
+			d.typeErr(fmt.Sprintf("%s[%d]", key, i), "a string", e)
+			return
+		}
+		out = append(out, s)
+	}
+	*dst = out
+}
+
+func (d *cfgDecoder) uints(key string, v any, dst *[]uint) {
+	arr, ok := v.([]any)
+	if !ok {
+		d.typeErr(key, "an array of non-negative whole numbers", v)
+		return
+	}
+
+	out := make([]uint, 0, len(arr))
+	for i, e := range arr {
+		f, ok := e.(float64)
+		if !ok || f != math.Trunc(f) || f < 0 {
+			d.typeErr(fmt.Sprintf("%s[%d]", key, i), "a non-negative whole number", e)
+			return
+		}
+		out = append(out, uint(f))
+	}
+	*dst = out
+}
+
+func (d *cfgDecoder) object(key string, v any) (map[string]any, bool) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		d.typeErr(key, "an object", v)
+	}
+	return m, ok
 }
 
 func (cfg *Config) UnmarshalJSON(b []byte) error {
@@ -140,17 +613,145 @@ func (cfg *Config) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
+	var d cfgDecoder
+
+	parseHooksCfg := func(m map[string]any) {
+		for k, v := range m {
+			switch k {
+			case "on_mention":
+				d.str("hooks.on_mention", v, &cfg.Hooks.OnMention)
+			case "on_user_message":
+				d.str("hooks.on_user_message", v, &cfg.Hooks.OnUserMessage)
+			case "users":
+				d.strs("hooks.users", v, &cfg.Hooks.Users)
+			case "on_connect":
+				d.str("hooks.on_connect", v, &cfg.Hooks.OnConnect)
+			case "on_disconnect":
+				d.str("hooks.on_disconnect", v, &cfg.Hooks.OnDisconnect)
+			case "on_refresh":
+				d.str("hooks.on_refresh", v, &cfg.Hooks.OnRefresh)
+			case "timeout":
+				d.float("hooks.timeout", v, &cfg.Hooks.Timeout)
+			case "max_concurrent":
+				d.uint("hooks.max_concurrent", v, &cfg.Hooks.MaxConcurrent)
+			}
+		}
+	}
+
+	parseHTTPCfg := func(m map[string]any) {
+		for k, v := range m {
+			switch k {
+			case "browser":
+				d.str("http.browser", v, &cfg.HTTP.Browser)
+			case "user_agent":
+				d.str("http.user_agent", v, &cfg.HTTP.UserAgent)
+			case "headers":
+				d.strs("http.headers", v, &cfg.HTTP.Headers)
+			}
+		}
+	}
+
+	parseNotifyCfg := func(m map[string]any) {
+		for k, v := range m {
+			switch k {
+			case "enabled":
+				d.boolean("notify.enabled", v, &cfg.Notify.Enabled)
+			case "backends":
+				d.strs("notify.backends", v, &cfg.Notify.Backends)
+			case "command":
+				d.str("notify.command", v, &cfg.Notify.Command)
+			case "webhook":
+				d.str("notify.webhook", v, &cfg.Notify.Webhook)
+			case "per_minute":
+				d.uint("notify.per_minute", v, &cfg.Notify.PerMinute)
+			case "quiet_hours":
+				d.str("notify.quiet_hours", v, &cfg.Notify.QuietHours)
+			case "rooms":
+				d.uints("notify.rooms", v, &cfg.Notify.Rooms)
+			case "suppress_when_focused":
+				d.boolean("notify.suppress_when_focused", v, &cfg.Notify.SuppressFocused)
+			}
+		}
+	}
+
 	parseProxyCfg := func(m map[string]any) {
 		for k, v := range m {
 			switch k {
 			case "enabled":
-				cfg.Proxy.Enabled = v.(bool)
+				d.boolean("proxy.enabled", v, &cfg.Proxy.Enabled)
 			case "address":
-				cfg.Proxy.Addr = v.(string)
+				d.str("proxy.address", v, &cfg.Proxy.Addr)
 			case "username":
-				cfg.Proxy.User = v.(string)
+				d.str("proxy.username", v, &cfg.Proxy.User)
 			case "password":
-				cfg.Proxy.Pass = v.(string)
+				d.str("proxy.password", v, &cfg.Proxy.Pass)
+			}
+		}
+	}
+
+	parseRateLimitCfg := func(m map[string]any) {
+		for k, v := range m {
+			switch k {
+			case "enabled":
+				d.boolean("rate_limit.enabled", v, &cfg.RateLimit.Enabled)
+			case "burst":
+				d.uint("rate_limit.burst", v, &cfg.RateLimit.Burst)
+			case "per_second":
+				d.float("rate_limit.per_second", v, &cfg.RateLimit.PerSecond)
+			case "coalesce_window":
+				d.float("rate_limit.coalesce_window", v, &cfg.RateLimit.CoalesceWindow)
+			}
+		}
+	}
+
+	parseIRCCfg := func(m map[string]any) {
+		for k, v := range m {
+			switch k {
+			case "enabled":
+				d.boolean("irc.enabled", v, &cfg.IRC.Enabled)
+			case "address":
+				d.str("irc.address", v, &cfg.IRC.Addr)
+			case "password":
+				d.str("irc.password", v, &cfg.IRC.Pass)
+			}
+		}
+	}
+
+	parseMetricsCfg := func(m map[string]any) {
+		for k, v := range m {
+			switch k {
+			case "enabled":
+				d.boolean("metrics.enabled", v, &cfg.Metrics.Enabled)
+			case "address":
+				d.str("metrics.address", v, &cfg.Metrics.Addr)
+			}
+		}
+	}
+
+	parseScriptsCfg := func(m map[string]any) {
+		for k, v := range m {
+			switch k {
+			case "enabled":
+				d.boolean("scripts.enabled", v, &cfg.Scripts.Enabled)
+			case "directory":
+				d.str("scripts.directory", v, &cfg.Scripts.Dir)
+			case "trusted":
+				d.strs("scripts.trusted", v, &cfg.Scripts.Trusted)
+			case "timeout":
+				d.float("scripts.timeout", v, &cfg.Scripts.Timeout)
+			}
+		}
+	}
+
+	parseTLSCfg := func(m map[string]any) {
+		for k, v := range m {
+			switch k {
+			case "ca_file":
+				d.str("tls.ca_file", v, &cfg.TLS.CAFile)
+			case "pins":
+				d.strs("tls.pins", v, &cfg.TLS.Pins)
+			case "min_version":
+				d.str("tls.min_version", v, &cfg.TLS.MinVersion)
 			}
 		}
 	}
@@ -159,33 +760,85 @@ func (cfg *Config) UnmarshalJSON(b []byte) error {
 		for k, v := range m {
 			switch k {
 			case "enabled":
-				cfg.Tor.Enabled = v.(bool)
+				d.boolean("tor.enabled", v, &cfg.Tor.Enabled)
 			case "onion_host":
-				cfg.Tor.Onion = v.(string)
+				d.str("tor.onion_host", v, &cfg.Tor.Onion)
 			case "clearnet_over_tor":
-				cfg.Tor.Clearnet = v.(bool)
+				d.boolean("tor.clearnet_over_tor", v, &cfg.Tor.Clearnet)
+			case "socks_address":
+				d.str("tor.socks_address", v, &cfg.Tor.SocksAddr)
+			case "control_address":
+				d.str("tor.control_address", v, &cfg.Tor.ControlAddr)
+			case "control_password":
+				d.str("tor.control_password", v, &cfg.Tor.ControlPass)
+			case "persist_data":
+				d.boolean("tor.persist_data", v, &cfg.Tor.Persist)
+			case "data_directory":
+				d.str("tor.data_directory", v, &cfg.Tor.DataDir)
+			case "bridges":
+				d.strs("tor.bridges", v, &cfg.Tor.Bridges)
+			case "transport_plugin":
+				d.str("tor.transport_plugin", v, &cfg.Tor.TransportPlugin)
+			case "torrc":
+				d.strs("tor.torrc", v, &cfg.Tor.Torrc)
 			}
 		}
 	}
 
-	setCfgValue := func(k string, v any) {
+	for k, v := range cm {
 		switch k {
 		case "cookies":
-			cfg.Cookies = v.(string)
+			d.str(k, v, &cfg.Cookies)
 		case "host":
-			cfg.Host = v.(string)
+			d.str(k, v, &cfg.Host)
 		case "port":
-			cfg.Port = uint(v.(float64))
+			d.uint(k, v, &cfg.Port)
 		case "logger":
-			cfg.Logger = v.(bool)
+			d.boolean(k, v, &cfg.Logger)
 		case "read_only":
-			cfg.ReadOnly = v.(bool)
+			d.boolean(k, v, &cfg.ReadOnly)
 		case "room":
-			cfg.Room = uint(v.(float64))
+			d.uint(k, v, &cfg.Room)
 		case "user_id":
-			cfg.UserID = int(v.(float64))
+			d.int(k, v, &cfg.UserID)
+		case "encrypt_secrets":
+			d.boolean(k, v, &cfg.EncryptSecrets)
+		case "hooks":
+			if m, ok := d.object(k, v); ok {
+				parseHooksCfg(m)
+			}
+		case "http":
+			if m, ok := d.object(k, v); ok {
+				parseHTTPCfg(m)
+			}
+		case "irc":
+			if m, ok := d.object(k, v); ok {
+				parseIRCCfg(m)
+			}
+		case "metrics":
+			if m, ok := d.object(k, v); ok {
+				parseMetricsCfg(m)
+			}
+		case "notify":
+			if m, ok := d.object(k, v); ok {
+				parseNotifyCfg(m)
+			}
 		case "proxy":
-			parseProxyCfg(v.(map[string]any))
+			if m, ok := d.object(k, v); ok {
+				parseProxyCfg(m)
+			}
+		case "rate_limit":
+			if m, ok := d.object(k, v); ok {
+				parseRateLimitCfg(m)
+			}
+		case "scripts":
+			if m, ok := d.object(k, v); ok {
+				parseScriptsCfg(m)
+			}
+		case "tls":
+			if m, ok := d.object(k, v); ok {
+				parseTLSCfg(m)
+			}
 		case "tor":
 			switch v := v.(type) {
 			// Migrate deprecated config value.
@@ -195,21 +848,13 @@ func (cfg *Config) UnmarshalJSON(b []byte) error {
 				cfg.Tor.Enabled = v
 			case map[string]any:
 				parseTorCfg(v)
+			default:
+				d.typeErr(k, "an object", v)
 			}
 		}
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(len(cm))
-	for k, v := range cm {
-		go func() {
-			defer wg.Done()
-			setCfgValue(k, v)
-		}()
-	}
-	wg.Wait()
-
-	return nil
+	return errors.Join(d.errs...)
 }
 
 // Write loaded config to config.json file.
@@ -222,6 +867,11 @@ func (cfg *Config) writeConfig(f *os.File) error {
 	cfg.mx.Lock()
 	defer cfg.mx.Unlock()
 
+	return cfg.write(f)
+}
+
+// Must be called with cfg.mx held.
+func (cfg *Config) write(f *os.File) error {
 	var err error // Used to prevent shadowing f in the if block.
 	if f == nil {
 		f, err = openConfig()
@@ -231,7 +881,49 @@ func (cfg *Config) writeConfig(f *os.File) error {
 		defer f.Close()
 	}
 
-	b, err := json.MarshalIndent(cfg, "", "\t")
+	if cfg.file == nil {
+		// Config wasn't loaded from a file, so start a new one.
+		if cfg.file, _, err = parseProfileFile(nil); err != nil {
+			return err
+		}
+		if cfg.Profile == "" {
+			cfg.Profile = cfg.file.DefaultProfile
+		}
+	}
+
+	// Don't leak secrets into config.json if they're meant to be encrypted.
+	out := cfg
+	if cfg.secrets != nil {
+		if err = cfg.saveSecrets(); err != nil {
+			return err
+		}
+
+		blank := *cfg
+		blank.Cookies = ""
+		blank.Proxy.User = ""
+		blank.Proxy.Pass = ""
+		blank.Tor.ControlPass = ""
+		blank.IRC.Pass = ""
+		out = &blank
+
+		cfg.file.Base["cookies"] = ""
+		if pm, ok := cfg.file.Base["proxy"].(map[string]any); ok {
+			pm["username"] = ""
+			pm["password"] = ""
+		}
+		if tm, ok := cfg.file.Base["tor"].(map[string]any); ok {
+			tm["control_password"] = ""
+		}
+		if im, ok := cfg.file.Base["irc"].(map[string]any); ok {
+			im["password"] = ""
+		}
+	}
+
+	if err = cfg.file.update(out); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(cfg.file, "", "\t")
 	if err != nil {
 		return err
 	}
@@ -252,5 +944,9 @@ func (cfg *Config) writeConfig(f *os.File) error {
 		return err
 	}
 
+	if fi, err := f.Stat(); err == nil {
+		noteOwnWrite(fi)
+	}
+
 	return nil
 }
@@ -0,0 +1,57 @@
+//go:build unix
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// config.json may hold cookies, so loading it tightens the mode of files written by older versions.
+func TestLoadConfigMode(t *testing.T) {
+	tests := []struct {
+		name string
+		// Mode of an existing file. 0 if there isn't one.
+		mode os.FileMode
+	}{
+		{"new file", 0},
+		{"world readable", 0644},
+		{"world writable", 0666},
+		{"already private", 0600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("HOME", t.TempDir())
+			cfgDir, err := ConfigDir()
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(cfgDir, "config.json")
+
+			if tt.mode != 0 {
+				if err = os.WriteFile(path, []byte(`{"cookies": "xf_user=1"}`), tt.mode); err != nil {
+					t.Fatal(err)
+				}
+				// Not limited by the umask, unlike WriteFile.
+				if err = os.Chmod(path, tt.mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err = LoadProfiles(); err != nil {
+				t.Fatal(err)
+			}
+
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fi.Mode().Perm(); got != 0600 {
+				t.Fatalf("got mode %o, want 600", got)
+			}
+		})
+	}
+}
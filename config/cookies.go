@@ -0,0 +1,352 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	_ "modernc.org/sqlite"
+)
+
+// Cookies the site needs to log in. Anything else is optional.
+var requiredCookies = []string{"xf_user", "xf_session"}
+
+type ErrMissingCookies struct {
+	hosts   []string
+	missing []string
+}
+
+func (e *ErrMissingCookies) Error() string {
+	return fmt.Sprintf("Missing required cookies for %s: %s. Make sure you're logged in with that browser profile.",
+		strings.Join(e.hosts, ", "), strings.Join(e.missing, ", "))
+}
+
+// Read cookies for the configured host from a Netscape cookies.txt file,
+// or a Firefox or Chromium cookie database, and set them as cfg.Cookies.
+// path may also be a browser profile dir containing the database.
+func (cfg *Config) ImportCookies(path string) error {
+	hosts := []string{cfg.Host}
+	if cfg.Tor.Enabled && !cfg.Tor.Clearnet {
+		hosts = append(hosts, cfg.Tor.Onion)
+	}
+	for i, h := range hosts {
+		// Hosts in the config may include a protocol and path.
+		if _, rest, ok := strings.Cut(h, "://"); ok {
+			h = rest
+		}
+		h, _, _ = strings.Cut(h, "/")
+		hosts[i] = strings.ToLower(h)
+	}
+
+	path, err := findCookieStore(path)
+	if err != nil {
+		return err
+	}
+
+	cs, err := readCookieStore(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read cookies from %s: %w", path, err)
+	}
+
+	// Later entries override earlier ones with the same name.
+	found := make(map[string]string, 8)
+	order := make([]string, 0, 8)
+	for _, c := range cs {
+		if !cookieMatchesHost(c.Domain, hosts) {
+			continue
+		}
+		// libkiwi can't parse values containing these.
+		if strings.ContainsAny(c.Value, "=;") {
+			continue
+		}
+
+		if _, ok := found[c.Name]; !ok {
+			order = append(order, c.Name)
+		}
+		found[c.Name] = c.Value
+	}
+
+	missing := make([]string, 0, len(requiredCookies))
+	for _, name := range requiredCookies {
+		if found[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return &ErrMissingCookies{hosts, missing}
+	}
+
+	pairs := make([]string, len(order))
+	for i, name := range order {
+		pairs[i] = fmt.Sprintf("%s=%s", name, found[name])
+	}
+	cfg.Cookies = strings.Join(pairs, "; ")
+
+	return nil
+}
+
+// Cookie domains may have a leading dot to include subdomains.
+func cookieMatchesHost(domain string, hosts []string) bool {
+	domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+	for _, h := range hosts {
+		if h == domain || strings.HasSuffix(h, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolve browser profile dirs to the cookie database inside.
+func findCookieStore(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return path, nil
+	}
+
+	candidates := []string{
+		"cookies.sqlite",          // Firefox
+		"Cookies",                 // Older Chromium
+		"Network/Cookies",         // Chromium
+		"Default/Network/Cookies", // Chromium user data dir
+		"Default/Cookies",
+	}
+	for _, c := range candidates {
+		p := filepath.Join(path, c)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("No cookie database found in %s.", path)
+}
+
+func readCookieStore(path string) ([]*http.Cookie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if bytes.Equal(header[:n], []byte("SQLite format 3\x00")) {
+		return readCookieDB(path)
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return readCookiesTxt(f)
+}
+
+// Parse the Netscape cookies.txt format used by curl, wget and browser extensions.
+// Each line holds tab-separated fields: domain, subdomains flag, path, secure, expiry, name, value.
+func readCookiesTxt(r io.Reader) ([]*http.Cookie, error) {
+	cs := make([]*http.Cookie, 0, 16)
+
+	sc := bufio.NewScanner(r)
+	for ln := 1; sc.Scan(); ln++ {
+		line := strings.TrimSpace(sc.Text())
+		// HttpOnly cookies are prefixed with what would otherwise be a comment.
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("Malformed cookies.txt line %d: expected 7 tab-separated fields, got %d.", ln, len(fields))
+		}
+
+		cs = append(cs, &http.Cookie{
+			Domain: fields[0],
+			Path:   fields[2],
+			Name:   fields[5],
+			Value:  fields[6],
+		})
+	}
+
+	return cs, sc.Err()
+}
+
+// Browsers lock their cookie databases while running, so read from a copy.
+func copyCookieDB(path string) (string, func(), error) {
+	tmp, err := os.MkdirTemp("", "sockchat-cookies")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+
+	// Recent writes may still be in the write-ahead log.
+	for _, suffix := range []string{"", "-wal"} {
+		b, err := os.ReadFile(path + suffix)
+		if err != nil {
+			if suffix != "" && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			cleanup()
+			return "", nil, err
+		}
+
+		if err = os.WriteFile(filepath.Join(tmp, "cookies.db"+suffix), b, 0600); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+
+	return filepath.Join(tmp, "cookies.db"), cleanup, nil
+}
+
+func readCookieDB(path string) ([]*http.Cookie, error) {
+	dbPath, cleanup, err := copyCookieDB(path)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var table string
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name IN ('moz_cookies', 'cookies')`).Scan(&table)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, errors.New("Database is not a Firefox or Chromium cookie store.")
+	case err != nil:
+		return nil, err
+	}
+
+	if table == "moz_cookies" {
+		return readFirefoxCookies(db)
+	}
+	return readChromiumCookies(db)
+}
+
+func readFirefoxCookies(db *sql.DB) ([]*http.Cookie, error) {
+	rows, err := db.Query(`SELECT host, path, name, value FROM moz_cookies`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cs := make([]*http.Cookie, 0, 64)
+	for rows.Next() {
+		c := &http.Cookie{}
+		if err = rows.Scan(&c.Domain, &c.Path, &c.Name, &c.Value); err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+
+	return cs, rows.Err()
+}
+
+func readChromiumCookies(db *sql.DB) ([]*http.Cookie, error) {
+	// Newer versions prefix decrypted values with a hash of the domain.
+	var version int
+	db.QueryRow(`SELECT value FROM meta WHERE key = 'version'`).Scan(&version)
+
+	rows, err := db.Query(`SELECT host_key, path, name, value, encrypted_value FROM cookies`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cs := make([]*http.Cookie, 0, 64)
+	for rows.Next() {
+		var enc []byte
+		c := &http.Cookie{}
+		if err = rows.Scan(&c.Domain, &c.Path, &c.Name, &c.Value, &enc); err != nil {
+			return nil, err
+		}
+
+		if c.Value == "" && len(enc) > 0 {
+			v, err := decryptChromiumValue(enc, version >= 24)
+			if err != nil {
+				// Only fail on cookies we actually care about.
+				if isRequiredCookie(c.Name) {
+					return nil, fmt.Errorf("%s: %w", c.Name, err)
+				}
+				continue
+			}
+			c.Value = v
+		}
+
+		cs = append(cs, c)
+	}
+
+	return cs, rows.Err()
+}
+
+func isRequiredCookie(name string) bool {
+	for _, rc := range requiredCookies {
+		if name == rc {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Decrypt a Chromium cookie value encrypted with the hard-coded Linux key (v10 prefix).
+// Values encrypted with a key from the OS keyring (v11, or any value on Windows and macOS) aren't supported.
+func decryptChromiumValue(enc []byte, hashPrefix bool) (string, error) {
+	if runtime.GOOS != "linux" || !bytes.HasPrefix(enc, []byte("v10")) {
+		return "", errors.New("Cookie is encrypted with a key from the OS keyring, which isn't supported. Export a cookies.txt file instead.")
+	}
+	enc = enc[3:]
+	if len(enc) == 0 || len(enc)%aes.BlockSize != 0 {
+		return "", errors.New("Encrypted cookie has an invalid length.")
+	}
+
+	key := pbkdf2.Key([]byte("peanuts"), []byte("saltysalt"), 1, 16, sha1.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	iv := bytes.Repeat([]byte{' '}, aes.BlockSize)
+	out := make([]byte, len(enc))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, enc)
+
+	// Strip PKCS#7 padding.
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return "", errors.New("Failed to decrypt cookie.")
+	}
+	out = out[:len(out)-pad]
+
+	if hashPrefix {
+		// SHA-256 of the host key.
+		if len(out) < sha256.Size {
+			return "", errors.New("Failed to decrypt cookie.")
+		}
+		out = out[sha256.Size:]
+	}
+
+	return string(out), nil
+}
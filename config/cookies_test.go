@@ -0,0 +1,140 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Encrypt a value the way Chromium does on Linux without a keyring.
+func encryptChromiumValue(t *testing.T, plain []byte) []byte {
+	t.Helper()
+
+	key := pbkdf2.Key([]byte("peanuts"), []byte("saltysalt"), 1, 16, sha1.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	plain = append(plain, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, bytes.Repeat([]byte{' '}, aes.BlockSize)).CryptBlocks(out, plain)
+
+	return append([]byte("v10"), out...)
+}
+
+func TestDecryptChromiumValue(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Only the Linux key is supported.")
+	}
+
+	hash := sha256.Sum256([]byte(".example.com"))
+
+	tests := []struct {
+		name       string
+		enc        []byte
+		hashPrefix bool
+		want       string
+		wantErr    string
+	}{
+		{name: "plain", enc: encryptChromiumValue(t, []byte("abc123")), want: "abc123"},
+		{name: "full block", enc: encryptChromiumValue(t, []byte("0123456789abcdef")), want: "0123456789abcdef"},
+		{name: "hash prefix", enc: encryptChromiumValue(t, append(hash[:], "abc123"...)), hashPrefix: true, want: "abc123"},
+		{name: "hash prefix too short", enc: encryptChromiumValue(t, []byte("abc")), hashPrefix: true, wantErr: "Failed to decrypt"},
+		{name: "keyring", enc: []byte("v11whatever"), wantErr: "OS keyring"},
+		{name: "no prefix", enc: []byte("plaintext"), wantErr: "OS keyring"},
+		{name: "empty", enc: []byte("v10"), wantErr: "invalid length"},
+		{name: "bad length", enc: []byte("v10short"), wantErr: "invalid length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decryptChromiumValue(tt.enc, tt.hashPrefix)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %q, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCookiesTxt(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", in: ""},
+		{name: "comments", in: "# Netscape HTTP Cookie File\n\n# another\n"},
+		{
+			name: "entries",
+			in: ".example.com\tTRUE\t/\tTRUE\t0\txf_user\tu1\n" +
+				"#HttpOnly_.example.com\tTRUE\t/\tTRUE\t0\txf_session\ts1\n",
+			want: []string{".example.com xf_user=u1", ".example.com xf_session=s1"},
+		},
+		{name: "malformed", in: "example.com\tTRUE\t/\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs, err := readCookiesTxt(strings.NewReader(tt.in))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, c := range cs {
+				got = append(got, c.Domain+" "+c.Name+"="+c.Value)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCookieMatchesHost(t *testing.T) {
+	hosts := []string{"kiwifarms.st", "chat.example.onion"}
+
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"kiwifarms.st", true},
+		{".kiwifarms.st", true},
+		{"KiwiFarms.st", true},
+		{".example.onion", true},
+		{"farms.st", false},
+		{"evilkiwifarms.st", false},
+		{"sub.kiwifarms.st", false},
+	}
+
+	for _, tt := range tests {
+		if got := cookieMatchesHost(tt.domain, hosts); got != tt.want {
+			t.Errorf("cookieMatchesHost(%q) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Describe the JSON type of a decoded value for error msgs.
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []any:
+		return "an array"
+	case map[string]any:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+type ErrConfigType struct {
+	Key      string
+	Expected string
+	Got      any
+}
+
+func (e *ErrConfigType) Error() string {
+	return fmt.Sprintf("Config key %q must be %s, but got %s (%v).", e.Key, e.Expected, jsonTypeName(e.Got), e.Got)
+}
+
+type ErrConfigValue struct {
+	Key    string
+	Value  any
+	Reason string
+}
+
+func (e *ErrConfigValue) Error() string {
+	return fmt.Sprintf("Invalid value for config key %q (%v): %s", e.Key, e.Value, e.Reason)
+}
+
+type ErrConfigVersion struct {
+	Version int
+}
+
+func (e *ErrConfigVersion) Error() string {
+	return fmt.Sprintf("config.json uses schema version %d, but this version of sockchat only supports up to %d. Update sockchat or restore a backup.",
+		e.Version, SCHEMA_VERSION)
+}
+
+// Wraps errors from a specific profile.
+type ErrProfile struct {
+	Profile string
+	Err     error
+}
+
+func (e *ErrProfile) Error() string {
+	return fmt.Sprintf("Profile %q: %s", e.Profile, e.Err)
+}
+
+func (e *ErrProfile) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"os/exec"
+	"strings"
+)
+
+func validateHooks(cfg *Config, bad func(key string, v any, reason string)) {
+	cmds := []struct{ key, cmd string }{
+		{"hooks.on_mention", cfg.Hooks.OnMention},
+		{"hooks.on_user_message", cfg.Hooks.OnUserMessage},
+		{"hooks.on_connect", cfg.Hooks.OnConnect},
+		{"hooks.on_disconnect", cfg.Hooks.OnDisconnect},
+		{"hooks.on_refresh", cfg.Hooks.OnRefresh},
+	}
+	for _, c := range cmds {
+		f := strings.Fields(c.cmd)
+		if len(f) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(f[0]); err != nil {
+			bad(c.key, c.cmd, "command not found.")
+		}
+	}
+
+	if cfg.Hooks.OnUserMessage != "" && len(cfg.Hooks.Users) == 0 {
+		bad("hooks.users", cfg.Hooks.Users, "hooks.on_user_message is set, but no users are listed.")
+	}
+	if cfg.Hooks.Timeout <= 0 {
+		bad("hooks.timeout", cfg.Hooks.Timeout, "must be greater than 0.")
+	}
+	if cfg.Hooks.MaxConcurrent == 0 {
+		bad("hooks.max_concurrent", cfg.Hooks.MaxConcurrent, "must be at least 1.")
+	}
+}
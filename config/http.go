@@ -0,0 +1,112 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// User-Agent and headers sent by a browser.
+type browserPreset struct {
+	UserAgent string
+	Headers   map[string]string
+}
+
+// User-Agent of the default browser preset.
+const DEFAULT_USER_AGENT = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:144.0) Gecko/20100101 Firefox/144.0"
+
+// Presets for http.browser. Bump these when new browser versions come out.
+var browserPresets = map[string]browserPreset{
+	"firefox": {
+		UserAgent: DEFAULT_USER_AGENT,
+		Headers:   map[string]string{"Accept-Language": "en-US,en;q=0.5"},
+	},
+	"chrome": {
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/141.0.0.0 Safari/537.36",
+		Headers:   map[string]string{"Accept-Language": "en-US,en;q=0.9"},
+	},
+	// Same as every other Tor Browser user, which is the point.
+	"tor-browser": {
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; rv:140.0) Gecko/20100101 Firefox/140.0",
+		Headers:   map[string]string{"Accept-Language": "en-US,en;q=0.5"},
+	},
+}
+
+// Headers the client sets itself. Setting them again breaks requests or sends them twice.
+var reservedHeaders = []string{
+	// Socket handshake.
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Extensions",
+	// Hop-by-hop, handled by the transport.
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	// Set from the host and cookies options.
+	"Host",
+	"Cookie",
+}
+
+// Names of the browser presets, sorted.
+func BrowserNames() []string {
+	names := make([]string, 0, len(browserPresets))
+	for n := range browserPresets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Split a header line like "Accept-Language: en-US" into its name and value.
+func parseHeader(line string) (string, string, error) {
+	name, value, ok := strings.Cut(line, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", errors.New(`must look like "Name: value".`)
+	}
+
+	name = http.CanonicalHeaderKey(name)
+	for _, r := range reservedHeaders {
+		if name == r {
+			return "", "", fmt.Errorf("%s is set by the client and can't be changed.", name)
+		}
+	}
+
+	return name, strings.TrimSpace(value), nil
+}
+
+// Build the headers to send from the browser preset, user_agent and headers.
+func (cfg *Config) HTTPHeaders() (http.Header, error) {
+	preset, ok := browserPresets[cfg.HTTP.Browser]
+	if !ok {
+		return nil, fmt.Errorf("Unknown browser preset %q. Must be one of: %s.", cfg.HTTP.Browser, strings.Join(BrowserNames(), ", "))
+	}
+
+	h := make(http.Header, len(preset.Headers)+len(cfg.HTTP.Headers)+1)
+	h.Set("User-Agent", preset.UserAgent)
+	for k, v := range preset.Headers {
+		h.Set(k, v)
+	}
+
+	if cfg.HTTP.UserAgent != "" {
+		h.Set("User-Agent", cfg.HTTP.UserAgent)
+	}
+	for _, line := range cfg.HTTP.Headers {
+		name, value, err := parseHeader(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid header %q: %w", line, err)
+		}
+		h.Set(name, value)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		line      string
+		wantName  string
+		wantValue string
+		wantErr   string
+	}{
+		{line: "Accept-Language: en-US", wantName: "Accept-Language", wantValue: "en-US"},
+		{line: "  origin :https://example.com  ", wantName: "Origin", wantValue: "https://example.com"},
+		{line: "X-Empty:", wantName: "X-Empty", wantValue: ""},
+		// Only the first colon splits.
+		{line: "Referer: https://example.com:8443/", wantName: "Referer", wantValue: "https://example.com:8443/"},
+		// Malformed.
+		{line: "Accept-Language en-US", wantErr: "must look like"},
+		{line: ": en-US", wantErr: "must look like"},
+		{line: "", wantErr: "must look like"},
+		{line: "Accept Language: en-US", wantErr: "must look like"},
+		{line: "Accept\tLanguage: en-US", wantErr: "must look like"},
+		// Socket handshake.
+		{line: "Sec-WebSocket-Key: abc", wantErr: "Sec-Websocket-Key is set by the client"},
+		{line: "sec-websocket-version: 13", wantErr: "Sec-Websocket-Version is set by the client"},
+		// Hop-by-hop.
+		{line: "Connection: close", wantErr: "Connection is set by the client"},
+		{line: "upgrade: h2c", wantErr: "Upgrade is set by the client"},
+		{line: "Keep-Alive: timeout=5", wantErr: "Keep-Alive is set by the client"},
+		{line: "Transfer-Encoding: chunked", wantErr: "Transfer-Encoding is set by the client"},
+		{line: "TE: trailers", wantErr: "Te is set by the client"},
+		{line: "Proxy-Authorization: Basic Zm9vOmJhcg==", wantErr: "Proxy-Authorization is set by the client"},
+		// Overrides of other options.
+		{line: "Host: evil.example", wantErr: "Host is set by the client"},
+		{line: "cookie: xf_session=abc", wantErr: "Cookie is set by the client"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			name, value, err := parseHeader(tt.line)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %q, %q, %v, want error %q", name, value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.wantName || value != tt.wantValue {
+				t.Fatalf("got %q: %q, want %q: %q", name, value, tt.wantName, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestHTTPHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		http httpConfig
+		// Headers that must be set, and their values.
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "firefox",
+			http: httpConfig{Browser: "firefox"},
+			want: map[string]string{"User-Agent": DEFAULT_USER_AGENT, "Accept-Language": "en-US,en;q=0.5"},
+		},
+		{
+			name: "chrome",
+			http: httpConfig{Browser: "chrome"},
+			want: map[string]string{"User-Agent": browserPresets["chrome"].UserAgent, "Accept-Language": "en-US,en;q=0.9"},
+		},
+		{
+			name: "tor browser",
+			http: httpConfig{Browser: "tor-browser"},
+			want: map[string]string{"User-Agent": browserPresets["tor-browser"].UserAgent},
+		},
+		{
+			name: "user agent override",
+			http: httpConfig{Browser: "chrome", UserAgent: "test/1.0"},
+			want: map[string]string{"User-Agent": "test/1.0", "Accept-Language": "en-US,en;q=0.9"},
+		},
+		{
+			name: "header overrides preset",
+			http: httpConfig{Browser: "firefox", Headers: []string{"accept-language: en-GB", "Origin: https://example.com"}},
+			want: map[string]string{"User-Agent": DEFAULT_USER_AGENT, "Accept-Language": "en-GB", "Origin": "https://example.com"},
+		},
+		{
+			name: "header overrides user agent",
+			http: httpConfig{Browser: "firefox", UserAgent: "test/1.0", Headers: []string{"User-Agent: test/2.0"}},
+			want: map[string]string{"User-Agent": "test/2.0"},
+		},
+		{name: "unknown preset", http: httpConfig{Browser: "netscape"}, wantErr: `Unknown browser preset "netscape"`},
+		{name: "empty preset", http: httpConfig{}, wantErr: "Must be one of: chrome, firefox, tor-browser."},
+		{name: "bad header", http: httpConfig{Browser: "firefox", Headers: []string{"nope"}}, wantErr: `Invalid header "nope"`},
+		{name: "reserved header", http: httpConfig{Browser: "firefox", Headers: []string{"Cookie: a=b"}}, wantErr: "Cookie is set by the client"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{HTTP: tt.http}
+			h, err := cfg.HTTPHeaders()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want error %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.want {
+				if got := h.Values(k); len(got) != 1 || got[0] != v {
+					t.Errorf("%s: got %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
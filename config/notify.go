@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Values allowed in notify.backends.
+var NotifyBackends = []string{"beeep", "bell", "osc9", "osc777", "exec", "webhook"}
+
+// Parse a quiet hours range like "23:00-07:00" into its start and end, as times since midnight.
+// The range may wrap past midnight.
+func ParseQuietHours(s string) (time.Duration, time.Duration, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, errors.New(`must look like "23:00-07:00".`)
+	}
+
+	clock := func(v string) (time.Duration, error) {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("%q isn't a 24-hour time like 07:00.", strings.TrimSpace(v))
+		}
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+	}
+
+	start, err := clock(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := clock(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, errors.New("start and end can't be the same.")
+	}
+
+	return start, end, nil
+}
+
+func validateNotify(cfg *Config, bad func(key string, v any, reason string)) {
+	n := cfg.Notify
+	for i, b := range n.Backends {
+		switch b {
+		case "exec":
+			if strings.TrimSpace(n.Command) == "" {
+				bad("notify.command", n.Command, "the exec backend is enabled, but no command is set.")
+			}
+		case "webhook":
+			u, err := url.Parse(n.Webhook)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				bad("notify.webhook", n.Webhook, "the webhook backend is enabled, but this isn't an http:// or https:// URL.")
+			}
+		case "beeep", "bell", "osc9", "osc777":
+		default:
+			bad(fmt.Sprintf("notify.backends[%d]", i), b, fmt.Sprintf("must be one of: %s.", strings.Join(NotifyBackends, ", ")))
+		}
+	}
+
+	if n.QuietHours != "" {
+		if _, _, err := ParseQuietHours(n.QuietHours); err != nil {
+			bad("notify.quiet_hours", n.QuietHours, err.Error())
+		}
+	}
+	for i, r := range n.Rooms {
+		if r == 0 || r > math.MaxUint16 {
+			bad(fmt.Sprintf("notify.rooms[%d]", i), r, "must be between 1 and 65535.")
+		}
+	}
+}
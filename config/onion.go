@@ -0,0 +1,60 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// Length of a v3 onion address without the .onion suffix.
+	_ONION_V3_LEN = 56
+	_ONION_V3_VER = 0x03
+)
+
+// Check that addr is a valid v3 onion address, including its checksum and version.
+// Catches typos and hand-edited addresses, but not a valid address for the wrong site.
+func ValidateOnion(addr string) error {
+	host := strings.ToLower(addr)
+	// Hosts may include a protocol, like in parseHost.
+	if _, h, ok := strings.Cut(host, "://"); ok {
+		host = h
+	}
+	host = strings.TrimSuffix(host, "/")
+
+	name, ok := strings.CutSuffix(host, ".onion")
+	if !ok {
+		return errors.New("must be a .onion address.")
+	}
+	// Subdomains of onion services resolve to the same service.
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	if len(name) != _ONION_V3_LEN {
+		return errors.New("not a v3 onion address. It should be 56 characters before .onion.")
+	}
+
+	// Layout is: pubkey (32 bytes) | checksum (2 bytes) | version (1 byte).
+	raw, err := base32.StdEncoding.DecodeString(strings.ToUpper(name))
+	if err != nil {
+		return errors.New("contains characters that can't appear in an onion address.")
+	}
+	pubkey, checksum, version := raw[:32], raw[32:34], raw[34]
+
+	if version != _ONION_V3_VER {
+		return errors.New("has an unknown onion address version.")
+	}
+
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubkey)
+	h.Write([]byte{version})
+	if !bytes.Equal(h.Sum(nil)[:2], checksum) {
+		return errors.New("checksum doesn't match. The address has a typo or was altered.")
+	}
+
+	return nil
+}
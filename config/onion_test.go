@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Build a v3 onion address for pubkey, with the given version and a checksum computed for it.
+func makeOnion(pubkey []byte, version byte) string {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubkey)
+	h.Write([]byte{version})
+
+	raw := append(append(append([]byte{}, pubkey...), h.Sum(nil)[:2]...), version)
+	return strings.ToLower(base32.StdEncoding.EncodeToString(raw)) + ".onion"
+}
+
+func TestValidateOnion(t *testing.T) {
+	def := NewConfig().Tor.Onion
+	pubkey := make([]byte, 32)
+	for i := range pubkey {
+		pubkey[i] = byte(i)
+	}
+	valid := makeOnion(pubkey, _ONION_V3_VER)
+
+	// Swap one char of the pubkey part for another valid one, leaving the checksum as is.
+	typo := []byte(def)
+	if typo[10] == 'a' {
+		typo[10] = 'b'
+	} else {
+		typo[10] = 'a'
+	}
+
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr string
+	}{
+		{"default", def, ""},
+		{"generated", valid, ""},
+		{"upper case", strings.ToUpper(valid), ""},
+		{"with scheme", "http://" + valid + "/", ""},
+		{"subdomain", "www." + valid, ""},
+		{"typo", string(typo), "checksum doesn't match"},
+		{"wrong version", makeOnion(pubkey, 0x02), "unknown onion address version"},
+		{"too short", def[:20] + ".onion", "not a v3 onion address"},
+		{"v2 length", "expyuzz4wqqyqhjn.onion", "not a v3 onion address"},
+		{"bad chars", strings.Repeat("1", 56) + ".onion", "characters that can't appear"},
+		{"clearnet", "kiwifarms.net", "must be a .onion address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOnion(tt.addr)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("got %q, want no error", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
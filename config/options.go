@@ -0,0 +1,345 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Prefix for env vars that override config values.
+const ENV_PREFIX = "SOCKCHAT_"
+
+// A config value that can be overridden by env vars and flags.
+// Values are applied in order of precedence, lowest first:
+// defaults, the base section, the profile, SOCKCHAT_* env vars, then flags.
+type option struct {
+	// Key in config.json, with nested keys separated by dots.
+	key string
+	// Flag name. Derived from key if empty.
+	flag  string
+	usage string
+	// Pointer to the value in cfg. Must be *string, *bool, *uint, *int, *float64, *[]string or *[]uint.
+	// Lists are given one item per line in env vars, or by repeating the flag.
+	ptr func(cfg *Config) any
+	// Set for account credentials, which can't be shared by several profiles.
+	credential bool
+}
+
+// Env var name for the option, like SOCKCHAT_PROXY_ADDRESS for proxy.address.
+func (o option) env() string {
+	return ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(o.key, ".", "_"))
+}
+
+func (o option) flagName() string {
+	if o.flag != "" {
+		return o.flag
+	}
+
+	return strings.NewReplacer(".", "-", "_", "-").Replace(o.key)
+}
+
+// Every config value that can be overridden.
+// encrypt_secrets is left out since it decides how the file itself is loaded.
+var options = []option{
+	{key: "cookies", usage: "Set cookies used to connect.",
+		ptr: func(cfg *Config) any { return &cfg.Cookies }, credential: true},
+	{key: "host", usage: "Specify hostname to connect to.",
+		ptr: func(cfg *Config) any { return &cfg.Host }},
+	{key: "port", usage: "Specify outgoing socket port.",
+		ptr: func(cfg *Config) any { return &cfg.Port }},
+	{key: "logger", flag: "log", usage: "Enable chat logger.",
+		ptr: func(cfg *Config) any { return &cfg.Logger }},
+	{key: "read_only", flag: "ro", usage: "Read-only (lurker) mode.",
+		ptr: func(cfg *Config) any { return &cfg.ReadOnly }},
+	{key: "room", usage: "Room to join by default.",
+		ptr: func(cfg *Config) any { return &cfg.Room }},
+	{key: "user_id", usage: "Your forum user ID. Used to detect mentions and confirm sent msgs.",
+		ptr: func(cfg *Config) any { return &cfg.UserID }},
+
+	{key: "hooks.on_mention", usage: "Command run when you're mentioned.",
+		ptr: func(cfg *Config) any { return &cfg.Hooks.OnMention }},
+	{key: "hooks.on_user_message", usage: "Command run for msgs from the users in hooks.users.",
+		ptr: func(cfg *Config) any { return &cfg.Hooks.OnUserMessage }},
+	{key: "hooks.users", flag: "hooks-user", usage: "Username or user ID watched by hooks.on_user_message. Repeat for more.",
+		ptr: func(cfg *Config) any { return &cfg.Hooks.Users }},
+	{key: "hooks.on_connect", usage: "Command run after connecting.",
+		ptr: func(cfg *Config) any { return &cfg.Hooks.OnConnect }},
+	{key: "hooks.on_disconnect", usage: "Command run when the connection drops.",
+		ptr: func(cfg *Config) any { return &cfg.Hooks.OnDisconnect }},
+	{key: "hooks.on_refresh", usage: "Command run after refreshing the session.",
+		ptr: func(cfg *Config) any { return &cfg.Hooks.OnRefresh }},
+	{key: "hooks.timeout", usage: "Seconds a hook may run before it's killed.",
+		ptr: func(cfg *Config) any { return &cfg.Hooks.Timeout }},
+	{key: "hooks.max_concurrent", usage: "Most hooks running at once.",
+		ptr: func(cfg *Config) any { return &cfg.Hooks.MaxConcurrent }},
+
+	{key: "http.browser", usage: "Browser preset for the User-Agent and headers: firefox, chrome or tor-browser.",
+		ptr: func(cfg *Config) any { return &cfg.HTTP.Browser }},
+	{key: "http.user_agent", usage: "User-Agent to send instead of the preset's.",
+		ptr: func(cfg *Config) any { return &cfg.HTTP.UserAgent }},
+	{key: "http.headers", flag: "http-header", usage: "Extra header, like \"Accept-Language: en-US\". Repeat for more.",
+		ptr: func(cfg *Config) any { return &cfg.HTTP.Headers }},
+
+	{key: "irc.enabled", flag: "irc", usage: "Serve the chat to IRC clients.",
+		ptr: func(cfg *Config) any { return &cfg.IRC.Enabled }},
+	{key: "irc.address", usage: "Loopback address for the IRC server, like 127.0.0.1:6667.",
+		ptr: func(cfg *Config) any { return &cfg.IRC.Addr }},
+	{key: "irc.password", usage: "Password IRC clients must send to connect.",
+		ptr: func(cfg *Config) any { return &cfg.IRC.Pass }, credential: true},
+
+	{key: "metrics.enabled", flag: "metrics", usage: "Serve Prometheus metrics.",
+		ptr: func(cfg *Config) any { return &cfg.Metrics.Enabled }},
+	{key: "metrics.address", flag: "metrics-address", usage: "Loopback address to serve metrics on, like 127.0.0.1:9464.",
+		ptr: func(cfg *Config) any { return &cfg.Metrics.Addr }},
+
+	{key: "notify.enabled", flag: "notify", usage: "Notify about mentions.",
+		ptr: func(cfg *Config) any { return &cfg.Notify.Enabled }},
+	{key: "notify.backends", flag: "notify-backend", usage: "Way to notify: beeep, bell, osc9, osc777, exec or webhook. Repeat for more.",
+		ptr: func(cfg *Config) any { return &cfg.Notify.Backends }},
+	{key: "notify.command", usage: "Command run by the exec notify backend, with the title and body as args.",
+		ptr: func(cfg *Config) any { return &cfg.Notify.Command }},
+	{key: "notify.webhook", usage: "URL the webhook notify backend POSTs JSON to.",
+		ptr: func(cfg *Config) any { return &cfg.Notify.Webhook }},
+	{key: "notify.per_minute", usage: "Most notifications per minute. 0 for no limit.",
+		ptr: func(cfg *Config) any { return &cfg.Notify.PerMinute }},
+	{key: "notify.quiet_hours", usage: "Local time range without notifications, like 23:00-07:00.",
+		ptr: func(cfg *Config) any { return &cfg.Notify.QuietHours }},
+	{key: "notify.rooms", flag: "notify-room", usage: "Room to notify for. Repeat for more. Every room if unset.",
+		ptr: func(cfg *Config) any { return &cfg.Notify.Rooms }},
+	{key: "notify.suppress_when_focused", usage: "Don't notify while the room is on screen in a focused terminal.",
+		ptr: func(cfg *Config) any { return &cfg.Notify.SuppressFocused }},
+
+	{key: "proxy.enabled", flag: "proxy", usage: "Connect through a proxy.",
+		ptr: func(cfg *Config) any { return &cfg.Proxy.Enabled }},
+	{key: "proxy.address", usage: "Proxy URL, like socks5://127.0.0.1:1080 or http://proxy:3128.",
+		ptr: func(cfg *Config) any { return &cfg.Proxy.Addr }},
+	{key: "proxy.username", usage: "Proxy username.",
+		ptr: func(cfg *Config) any { return &cfg.Proxy.User }, credential: true},
+	{key: "proxy.password", usage: "Proxy password.",
+		ptr: func(cfg *Config) any { return &cfg.Proxy.Pass }, credential: true},
+
+	{key: "rate_limit.enabled", flag: "rate-limit", usage: "Rate limit outgoing msgs.",
+		ptr: func(cfg *Config) any { return &cfg.RateLimit.Enabled }},
+	{key: "rate_limit.burst", usage: "Msgs sent back-to-back before rate limiting kicks in.",
+		ptr: func(cfg *Config) any { return &cfg.RateLimit.Burst }},
+	{key: "rate_limit.per_second", usage: "Sustained outgoing msgs per second.",
+		ptr: func(cfg *Config) any { return &cfg.RateLimit.PerSecond }},
+	{key: "rate_limit.coalesce_window", usage: "Seconds in which identical outgoing msgs are only sent once.",
+		ptr: func(cfg *Config) any { return &cfg.RateLimit.CoalesceWindow }},
+
+	{key: "scripts.enabled", flag: "scripts", usage: "Load Lua scripts.",
+		ptr: func(cfg *Config) any { return &cfg.Scripts.Enabled }},
+	{key: "scripts.directory", usage: "Dir to load scripts from. Defaults to one in the config dir.",
+		ptr: func(cfg *Config) any { return &cfg.Scripts.Dir }},
+	{key: "scripts.trusted", flag: "scripts-trusted", usage: "File name of a script allowed to use the io and os libs. Repeat for more.",
+		ptr: func(cfg *Config) any { return &cfg.Scripts.Trusted }},
+	{key: "scripts.timeout", usage: "Seconds a script may run at a time before it's stopped.",
+		ptr: func(cfg *Config) any { return &cfg.Scripts.Timeout }},
+
+	{key: "tls.ca_file", usage: "PEM file with the CAs to trust instead of the system ones.",
+		ptr: func(cfg *Config) any { return &cfg.TLS.CAFile }},
+	{key: "tls.pins", flag: "tls-pin", usage: "Base64 SHA-256 hash of a trusted public key (SPKI). Repeat for more.",
+		ptr: func(cfg *Config) any { return &cfg.TLS.Pins }},
+	{key: "tls.min_version", usage: "Lowest TLS version to accept, 1.2 or 1.3.",
+		ptr: func(cfg *Config) any { return &cfg.TLS.MinVersion }},
+
+	{key: "tor.enabled", flag: "tor", usage: "Connect through Tor network.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.Enabled }},
+	{key: "tor.onion_host", usage: "Onion address of the site.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.Onion }},
+	{key: "tor.clearnet_over_tor", usage: "Connect to the clearnet host through Tor instead of the onion.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.Clearnet }},
+	{key: "tor.socks_address", usage: "SOCKS port of a running Tor daemon, like 127.0.0.1:9050.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.SocksAddr }},
+	{key: "tor.control_address", usage: "Control port of a running Tor daemon, like 127.0.0.1:9051.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.ControlAddr }},
+	{key: "tor.control_password", usage: "Password for the Tor control port, if it uses one.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.ControlPass }, credential: true},
+	{key: "tor.persist_data", usage: "Keep embedded Tor's state between runs for faster startup.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.Persist }},
+	{key: "tor.data_directory", usage: "Data dir for embedded Tor. Defaults to one in the config dir.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.DataDir }},
+	{key: "tor.bridges", flag: "tor-bridge", usage: "Bridge line for embedded Tor. Repeat for more.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.Bridges }},
+	{key: "tor.transport_plugin", usage: "Path to a pluggable transport binary for bridges, like obfs4proxy.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.TransportPlugin }},
+	{key: "tor.torrc", usage: "Extra torrc line for embedded Tor. Repeat for more.",
+		ptr: func(cfg *Config) any { return &cfg.Tor.Torrc }},
+}
+
+// Set value pointed to by ptr from its string form.
+func setOption(ptr any, s string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = s
+	case *bool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("expected a boolean (true or false)")
+		}
+		*p = v
+	case *uint:
+		v, err := strconv.ParseUint(s, 10, 0)
+		if err != nil {
+			return fmt.Errorf("expected a non-negative whole number")
+		}
+		*p = uint(v)
+	case *int:
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("expected a whole number")
+		}
+		*p = v
+	case *float64:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number")
+		}
+		*p = v
+	case *[]string:
+		lines := make([]string, 0, 2)
+		for _, l := range strings.Split(s, "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				lines = append(lines, l)
+			}
+		}
+		*p = lines
+	case *[]uint:
+		nums := make([]uint, 0, 2)
+		for _, l := range strings.Split(s, "\n") {
+			if l = strings.TrimSpace(l); l == "" {
+				continue
+			}
+			v, err := strconv.ParseUint(l, 10, 0)
+			if err != nil {
+				return fmt.Errorf("expected non-negative whole numbers, one per line")
+			}
+			nums = append(nums, uint(v))
+		}
+		*p = nums
+	default:
+		return fmt.Errorf("unsupported option type %T", ptr)
+	}
+
+	return nil
+}
+
+// Get the value pointed to by ptr in a comparable form.
+func optionValue(ptr any) any {
+	switch p := ptr.(type) {
+	case *string:
+		return *p
+	case *bool:
+		return *p
+	case *uint:
+		return *p
+	case *int:
+		return *p
+	case *float64:
+		return *p
+	case *[]string:
+		return strings.Join(*p, "\n")
+	case *[]uint:
+		return fmt.Sprint(*p)
+	default:
+		return nil
+	}
+}
+
+// Copy the value pointed to by src to dst. Both must point to the same type.
+func copyOption(dst any, src any) {
+	switch p := dst.(type) {
+	case *string:
+		*p = *src.(*string)
+	case *bool:
+		*p = *src.(*bool)
+	case *uint:
+		*p = *src.(*uint)
+	case *int:
+		*p = *src.(*int)
+	case *float64:
+		*p = *src.(*float64)
+	case *[]string:
+		*p = slices.Clone(*src.(*[]string))
+	case *[]uint:
+		*p = slices.Clone(*src.(*[]uint))
+	}
+}
+
+// Apply SOCKCHAT_* env var overrides to cfg.
+func (cfg *Config) applyEnv() error {
+	for _, o := range options {
+		s, ok := os.LookupEnv(o.env())
+		if !ok {
+			continue
+		}
+
+		if err := setOption(o.ptr(cfg), s); err != nil {
+			return fmt.Errorf("Invalid value for %s (%q): %s.", o.env(), s, err)
+		}
+		cfg.setOverridden(o.key)
+	}
+
+	return nil
+}
+
+// Record that the option named by key didn't come from the file.
+func (cfg *Config) setOverridden(key string) {
+	if !slices.Contains(cfg.overrides, key) {
+		// Copies of cfg must not share the list.
+		cfg.overrides = append(slices.Clip(cfg.overrides), key)
+	}
+}
+
+// Check if the option named by key was set by an env var or flag.
+// Overridden values are meant for this run only and aren't saved.
+func (cfg *Config) Overridden(key string) bool {
+	return slices.Contains(cfg.overrides, key)
+}
+
+// Register a flag for every option, defaulting to the current value in cfg.
+func (cfg *Config) registerFlags(flags *flag.FlagSet) {
+	for _, o := range options {
+		name := o.flagName()
+		usage := fmt.Sprintf("%s (env: %s)", o.usage, o.env())
+
+		switch p := o.ptr(cfg).(type) {
+		case *string:
+			flags.StringVar(p, name, *p, usage)
+		case *bool:
+			flags.BoolVar(p, name, *p, usage)
+		case *uint:
+			flags.UintVar(p, name, *p, usage)
+		case *int:
+			flags.IntVar(p, name, *p, usage)
+		case *float64:
+			flags.Float64Var(p, name, *p, usage)
+		case *[]string:
+			// The first use replaces values from the config instead of adding to them.
+			set := false
+			flags.Func(name, usage, func(v string) error {
+				if !set {
+					*p, set = nil, true
+				}
+				*p = append(*p, v)
+				return nil
+			})
+		case *[]uint:
+			set := false
+			flags.Func(name, usage, func(v string) error {
+				n, err := strconv.ParseUint(v, 10, 0)
+				if err != nil {
+					return fmt.Errorf("expected a non-negative whole number")
+				}
+				if !set {
+					*p, set = nil, true
+				}
+				*p = append(*p, uint(n))
+				return nil
+			})
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Name of the profile created when migrating a flat config.
+const DEFAULT_PROFILE = "default"
+
+// Layout of config.json.
+// Profiles only hold the values that differ from the base section.
+type profileFile struct {
+	Version        int                       `json:"version"`
+	DefaultProfile string                    `json:"default_profile"`
+	Base           map[string]any            `json:"base"`
+	Profiles       map[string]map[string]any `json:"profiles"`
+}
+
+// Get the profile names passed with --profile, if any.
+// Several can be given as a comma-separated list to run them at the same time.
+func ProfileArgs() []string {
+	arg := ProfileArg()
+	if arg == "" {
+		return nil
+	}
+
+	names := make([]string, 0, 2)
+	for _, n := range strings.Split(arg, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+
+	return names
+}
+
+// Get the raw value passed with --profile, or SOCKCHAT_PROFILE if the flag isn't given.
+// Needed before the rest of the args are parsed, since it decides which values get loaded.
+func ProfileArg() string {
+	args := os.Args[1:]
+	for i, a := range args {
+		name, val, hasVal := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != "profile" {
+			continue
+		}
+
+		if hasVal {
+			return val
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	return os.Getenv(ENV_PREFIX + "PROFILE")
+}
+
+// Convert v to a generic JSON map.
+func toMap(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]any
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// Copy of dst with keys from src applied over it. Nested objects are merged too.
+func mergeMaps(dst map[string]any, src map[string]any) map[string]any {
+	out := make(map[string]any, len(dst))
+	for k, v := range dst {
+		out[k] = v
+	}
+
+	for k, v := range src {
+		sm, sok := v.(map[string]any)
+		dm, dok := out[k].(map[string]any)
+		if sok && dok {
+			out[k] = mergeMaps(dm, sm)
+			continue
+		}
+		out[k] = v
+	}
+
+	return out
+}
+
+// Values in cur that a profile needs to store on top of base.
+// Keys already present in prev (the profile as it was loaded) are always kept.
+func profileOverrides(cur map[string]any, base map[string]any, prev map[string]any) map[string]any {
+	out := make(map[string]any, len(prev))
+	for k, v := range cur {
+		bv, inBase := base[k]
+		pv, inPrev := prev[k]
+
+		if vm, ok := v.(map[string]any); ok {
+			bm, _ := bv.(map[string]any)
+			pm, _ := pv.(map[string]any)
+
+			if sub := profileOverrides(vm, bm, pm); len(sub) > 0 {
+				out[k] = sub
+			}
+			continue
+		}
+
+		if inPrev || !inBase || !reflect.DeepEqual(v, bv) {
+			out[k] = v
+		}
+	}
+
+	return out
+}
+
+// Parse config.json contents into a profileFile, migrating older schema versions.
+// Also returns the schema version b was written with.
+func parseProfileFile(b []byte) (*profileFile, int, error) {
+	var raw map[string]any
+	if len(strings.TrimSpace(string(b))) > 0 {
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	raw, from, err := migrate(raw)
+	if err != nil {
+		return nil, from, err
+	}
+
+	pf := &profileFile{
+		Version:        SCHEMA_VERSION,
+		DefaultProfile: DEFAULT_PROFILE,
+		Base:           make(map[string]any),
+		Profiles:       make(map[string]map[string]any),
+	}
+
+	var d cfgDecoder
+	for k, v := range raw {
+		switch k {
+		case "default_profile":
+			d.str(k, v, &pf.DefaultProfile)
+		case "base":
+			if m, ok := d.object(k, v); ok {
+				pf.Base = m
+			}
+		case "profiles":
+			profiles, ok := d.object(k, v)
+			if !ok {
+				continue
+			}
+			for name, p := range profiles {
+				if pm, ok := d.object("profiles."+name, p); ok {
+					pf.Profiles[name] = pm
+				}
+			}
+		}
+	}
+	if err = errors.Join(d.errs...); err != nil {
+		return nil, from, err
+	}
+
+	if pf.DefaultProfile == "" {
+		pf.DefaultProfile = DEFAULT_PROFILE
+	}
+	if len(pf.Profiles) == 0 {
+		pf.Profiles[pf.DefaultProfile] = make(map[string]any)
+	}
+
+	// Normalize base through Config to add new keys.
+	base := NewConfig()
+	if err = base.unmarshalMap(pf.Base); err != nil {
+		return nil, from, &ErrProfile{"base", err}
+	}
+	if pf.Base, err = toMap(&base); err != nil {
+		return nil, from, err
+	}
+
+	return pf, from, nil
+}
+
+// Get the merged values of the named profile.
+func (pf *profileFile) resolve(name string) (map[string]any, error) {
+	p, ok := pf.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(pf.Profiles))
+		for n := range pf.Profiles {
+			names = append(names, n)
+		}
+
+		return nil, fmt.Errorf("Profile %q not found. Available profiles: %s", name, strings.Join(names, ", "))
+	}
+
+	return mergeMaps(pf.Base, p), nil
+}
+
+// Store the values of cfg as the overrides for its profile.
+func (pf *profileFile) update(cfg *Config) error {
+	cur, err := toMap(cfg)
+	if err != nil {
+		return err
+	}
+
+	pf.Profiles[cfg.Profile] = profileOverrides(cur, pf.Base, pf.Profiles[cfg.Profile])
+	return nil
+}
+
+// Suffix for files that must be kept separate for each profile, like logs.
+// Empty for the default profile, which keeps the names used before profiles existed.
+func (cfg *Config) ProfileSuffix() string {
+	if cfg.Profile == "" || cfg.Profile == DEFAULT_PROFILE {
+		return ""
+	}
+
+	return "_" + cfg.Profile
+}
+
+func (cfg *Config) unmarshalMap(m map[string]any) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, cfg)
+}
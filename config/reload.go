@@ -0,0 +1,176 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often config.json is checked for changes.
+const _WATCH_INTERVAL = 2 * time.Second
+
+// Modification time and size of config.json after the client last wrote it.
+// Lets WatchConfig tell the client's own saves apart from edits.
+var ownWrite struct {
+	mx   sync.Mutex
+	mod  time.Time
+	size int64
+}
+
+func noteOwnWrite(fi os.FileInfo) {
+	ownWrite.mx.Lock()
+	defer ownWrite.mx.Unlock()
+
+	ownWrite.mod, ownWrite.size = fi.ModTime(), fi.Size()
+}
+
+func isOwnWrite(mod time.Time, size int64) bool {
+	ownWrite.mx.Lock()
+	defer ownWrite.mx.Unlock()
+
+	return mod.Equal(ownWrite.mod) && size == ownWrite.size
+}
+
+// Watch config.json for changes.
+// The returned chan receives whenever the file is modified, or when a signal arrives on hup.
+// Changes made by Save are ignored.
+// Signals are useful for editors that don't change the modification time, or to force a reload.
+func WatchConfig(ctx context.Context, hup <-chan os.Signal) (<-chan struct{}, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			// Likely mid-save. Treat as unchanged and check again later.
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		t := time.NewTicker(_WATCH_INTERVAL)
+		defer t.Stop()
+
+		mod, size := stat()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				notify()
+			case <-t.C:
+				m, sz := stat()
+				if sz < 0 || (m.Equal(mod) && sz == size) {
+					continue
+				}
+
+				mod, size = m, sz
+				if !isOwnWrite(m, sz) {
+					notify()
+				}
+			}
+		}
+	}()
+
+	return changed, nil
+}
+
+// Re-read config.json for the profiles in cfgs, which must have been loaded together by LoadProfiles.
+// The file shared by cfgs is updated in place, so saving any of them keeps the new contents.
+// Nothing is written, and encrypted secrets come from the store that was already unlocked.
+// cfgs are left untouched if the file has errors.
+func ReloadProfiles(cfgs []Config) ([]Config, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+
+	mx := cfgs[0].mx
+	mx.Lock()
+	defer mx.Unlock()
+
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pf, _, err := parseProfileFile(b)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Config, len(cfgs))
+	for i, old := range cfgs {
+		cfg := NewConfig()
+		cfg.mx = mx
+
+		vals, err := pf.resolve(old.Profile)
+		if err == nil {
+			err = cfg.unmarshalMap(vals)
+		}
+		if err == nil && cfg.EncryptSecrets != old.EncryptSecrets {
+			err = errors.New("encrypt_secrets can't be changed while running. Restart to apply it.")
+		}
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			return nil, &ErrProfile{old.Profile, err}
+		}
+
+		cfg.Profile = old.Profile
+		cfg.file = old.file
+		if old.secrets != nil {
+			cfg.fillSecrets(old.secrets)
+		}
+
+		out[i] = cfg
+	}
+
+	if f := cfgs[0].file; f != nil {
+		*f = *pf
+	}
+
+	return out, nil
+}
+
+// Get the keys of options with different values in cfg and other.
+func (cfg *Config) Changed(other Config) []string {
+	keys := make([]string, 0, 4)
+	for _, o := range options {
+		if optionValue(o.ptr(cfg)) != optionValue(o.ptr(&other)) {
+			keys = append(keys, o.key)
+		}
+	}
+
+	return keys
+}
+
+// Copy the values of the options named by keys from src to cfg.
+func (cfg *Config) CopyOptions(src Config, keys ...string) {
+	for _, k := range keys {
+		for _, o := range options {
+			if o.key != k {
+				continue
+			}
+
+			copyOption(o.ptr(cfg), o.ptr(&src))
+		}
+	}
+}
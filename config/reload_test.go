@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestOwnWrite(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	cfg := NewConfig()
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := configPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isOwnWrite(fi.ModTime(), fi.Size()) {
+		t.Fatal("Save wasn't recognized as the client's own write")
+	}
+
+	// An edit by someone else.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("\n")
+	f.Close()
+
+	if fi, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if isOwnWrite(fi.ModTime(), fi.Size()) {
+		t.Fatal("an outside edit was taken for the client's own write")
+	}
+}
@@ -0,0 +1,407 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Version of the config.json layout.
+// Bump it and add a migration whenever the layout changes in a way older versions can't read.
+const SCHEMA_VERSION = 2
+
+// Migrations between schema versions. migrations[n] upgrades version n to n+1.
+var migrations = []func(raw map[string]any) (map[string]any, error){
+	// 0 -> 1: Flat config becomes the base of a single default profile.
+	func(raw map[string]any) (map[string]any, error) {
+		return map[string]any{
+			"default_profile": DEFAULT_PROFILE,
+			"base":            raw,
+			"profiles": map[string]any{
+				DEFAULT_PROFILE: map[string]any{},
+			},
+		}, nil
+	},
+	// 1 -> 2: Adds version key. Deprecated tor bools become objects.
+	func(raw map[string]any) (map[string]any, error) {
+		migrateTor := func(m map[string]any) {
+			if enabled, ok := m["tor"].(bool); ok {
+				m["tor"] = map[string]any{"enabled": enabled}
+			}
+		}
+
+		if base, ok := raw["base"].(map[string]any); ok {
+			migrateTor(base)
+		}
+		if profiles, ok := raw["profiles"].(map[string]any); ok {
+			for _, p := range profiles {
+				if pm, ok := p.(map[string]any); ok {
+					migrateTor(pm)
+				}
+			}
+		}
+
+		raw["version"] = 2
+		return raw, nil
+	},
+}
+
+// Figure out which schema version raw was written with.
+func schemaVersion(raw map[string]any) (int, error) {
+	if v, ok := raw["version"]; ok {
+		f, ok := v.(float64)
+		if !ok || f != math.Trunc(f) || f < 0 {
+			return 0, &ErrConfigType{"version", "a non-negative whole number", v}
+		}
+		if int(f) > SCHEMA_VERSION {
+			return 0, &ErrConfigVersion{int(f)}
+		}
+		return int(f), nil
+	}
+
+	switch {
+	case len(raw) == 0:
+		// New file. Nothing to migrate.
+		return SCHEMA_VERSION, nil
+	case raw["profiles"] != nil:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Apply migrations to bring raw up to SCHEMA_VERSION.
+// Returns the version raw was originally at.
+func migrate(raw map[string]any) (map[string]any, int, error) {
+	from, err := schemaVersion(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for v := from; v < SCHEMA_VERSION; v++ {
+		if raw, err = migrations[v](raw); err != nil {
+			return nil, from, fmt.Errorf("Failed to migrate config from schema version %d: %w", v, err)
+		}
+	}
+
+	return raw, from, nil
+}
+
+// Copy the pre-migration config to a backup file next to it.
+func backupConfig(b []byte, version int) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+
+	bak := fmt.Sprintf("%s.v%d.bak", path, version)
+	return bak, os.WriteFile(bak, b, 0600)
+}
+
+// Check values for anything that can't work, like out-of-range ports.
+// Type errors are caught earlier, when decoding.
+func (cfg *Config) Validate() error {
+	var errs []error
+	bad := func(key string, v any, reason string) {
+		errs = append(errs, &ErrConfigValue{key, v, reason})
+	}
+
+	if err := validateHost(cfg.Host); err != nil {
+		bad("host", cfg.Host, err.Error())
+	}
+	if cfg.Port == 0 || cfg.Port > math.MaxUint16 {
+		bad("port", cfg.Port, "must be between 1 and 65535.")
+	}
+	if cfg.Room == 0 || cfg.Room > math.MaxUint16 {
+		bad("room", cfg.Room, "must be between 1 and 65535.")
+	}
+	if cfg.UserID < -1 || int64(cfg.UserID) > math.MaxUint32 {
+		bad("user_id", cfg.UserID, "must be your numeric forum user ID, or -1 if unset.")
+	}
+
+	validateHooks(cfg, bad)
+
+	if _, ok := browserPresets[cfg.HTTP.Browser]; !ok {
+		bad("http.browser", cfg.HTTP.Browser, fmt.Sprintf("must be one of: %s.", strings.Join(BrowserNames(), ", ")))
+	}
+	for i, h := range cfg.HTTP.Headers {
+		if _, _, err := parseHeader(h); err != nil {
+			bad(fmt.Sprintf("http.headers[%d]", i), h, err.Error())
+		}
+	}
+
+	if cfg.IRC.Enabled {
+		if err := validateLoopback(cfg.IRC.Addr); err != nil {
+			bad("irc.address", cfg.IRC.Addr, err.Error())
+		}
+		if cfg.IRC.Pass == "" {
+			bad("irc.password", cfg.IRC.Pass, "must be set, so other local users can't connect.")
+		}
+	}
+
+	if cfg.Metrics.Enabled {
+		if err := validateLoopback(cfg.Metrics.Addr); err != nil {
+			bad("metrics.address", cfg.Metrics.Addr, err.Error())
+		}
+	}
+
+	if cfg.Notify.Enabled {
+		validateNotify(cfg, bad)
+	}
+
+	if cfg.Proxy.Enabled {
+		if err := validateProxyAddr(cfg.Proxy.Addr); err != nil {
+			bad("proxy.address", cfg.Proxy.Addr, err.Error())
+		}
+	}
+
+	if cfg.RateLimit.Enabled {
+		if cfg.RateLimit.Burst == 0 {
+			bad("rate_limit.burst", cfg.RateLimit.Burst, "must be at least 1.")
+		}
+		if cfg.RateLimit.PerSecond <= 0 {
+			bad("rate_limit.per_second", cfg.RateLimit.PerSecond, "must be greater than 0.")
+		}
+		if cfg.RateLimit.CoalesceWindow < 0 {
+			bad("rate_limit.coalesce_window", cfg.RateLimit.CoalesceWindow, "can't be negative.")
+		}
+	}
+
+	if cfg.Scripts.Enabled && cfg.Scripts.Timeout <= 0 {
+		bad("scripts.timeout", cfg.Scripts.Timeout, "must be greater than 0.")
+	}
+
+	if ca := cfg.TLS.CAFile; ca != "" {
+		if _, err := os.Stat(ca); err != nil {
+			bad("tls.ca_file", ca, "file not found.")
+		}
+	}
+	for i, p := range cfg.TLS.Pins {
+		if _, err := ParsePin(p); err != nil {
+			bad(fmt.Sprintf("tls.pins[%d]", i), p, err.Error())
+		}
+	}
+	if _, err := cfg.TLSMinVersion(); err != nil {
+		bad("tls.min_version", cfg.TLS.MinVersion, err.Error())
+	}
+
+	if cfg.Tor.Enabled && !cfg.Tor.Clearnet {
+		if err := ValidateOnion(cfg.Tor.Onion); err != nil {
+			bad("tor.onion_host", cfg.Tor.Onion, err.Error())
+		}
+	}
+	if cfg.Tor.SocksAddr != "" {
+		if err := validateHostPort(cfg.Tor.SocksAddr); err != nil {
+			bad("tor.socks_address", cfg.Tor.SocksAddr, err.Error())
+		}
+	}
+	if tp := cfg.Tor.TransportPlugin; tp != "" {
+		if _, err := os.Stat(tp); err != nil {
+			bad("tor.transport_plugin", tp, "file not found.")
+		}
+	}
+	for i, b := range cfg.Tor.Bridges {
+		if t := BridgeTransport(b); t != "" && cfg.Tor.TransportPlugin == "" {
+			bad(fmt.Sprintf("tor.bridges[%d]", i), b, fmt.Sprintf("uses the %s transport, but tor.transport_plugin isn't set.", t))
+		}
+	}
+	// Control ports may also be a unix socket.
+	if ca := cfg.Tor.ControlAddr; ca != "" && !strings.HasPrefix(ca, "unix:") {
+		if err := validateHostPort(ca); err != nil {
+			bad("tor.control_address", ca, err.Error())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Get the pluggable transport named in a bridge line.
+// Returns an empty string for plain bridges, which start with the address.
+func BridgeTransport(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.ContainsAny(fields[0], ":.[") {
+		return ""
+	}
+
+	return fields[0]
+}
+
+func validateHost(host string) error {
+	if host == "" {
+		return errors.New("hostname not defined.")
+	}
+
+	// Hosts may include a protocol, like in parseHost.
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return err
+	}
+	if u.Hostname() == "" || strings.ContainsAny(u.Hostname(), " \t") {
+		return errors.New("not a valid hostname.")
+	}
+
+	return nil
+}
+
+func validateProxyAddr(addr string) error {
+	if addr == "" {
+		return errors.New("proxy is enabled, but no address is set.")
+	}
+
+	// Same fallback as the proxy dialer.
+	if !strings.Contains(addr, "://") {
+		addr = "socks5://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q.", u.Scheme)
+	}
+
+	return validateHostPort(u.Host)
+}
+
+func validateHostPort(addr string) error {
+	if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+		return errors.New("must include a port, like 127.0.0.1:9050.")
+	}
+
+	return nil
+}
+
+// Check that addr is a host:port on a loopback interface, for servers meant for local use only.
+func validateLoopback(addr string) error {
+	if err := validateHostPort(addr); err != nil {
+		return err
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return errors.New("must be a loopback address, like 127.0.0.1 or localhost.")
+	}
+
+	return nil
+}
+
+// Find keys in m that don't exist in known, like typos.
+func unknownKeys(prefix string, m map[string]any, known map[string]any) []string {
+	unknown := make([]string, 0)
+	for k, v := range m {
+		kv, ok := known[k]
+		if !ok {
+			unknown = append(unknown, prefix+k)
+			continue
+		}
+
+		vm, vok := v.(map[string]any)
+		km, kok := kv.(map[string]any)
+		if vok && kok {
+			unknown = append(unknown, unknownKeys(prefix+k+".", vm, km)...)
+		}
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
+
+// Check config.json for errors without modifying it or decrypting secrets.
+// A report is written to w. Returns an error if any problems were found.
+func CheckConfig(w io.Writer) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Checking %s\n", path)
+
+	var raw map[string]any
+	if len(strings.TrimSpace(string(b))) > 0 {
+		if err = json.Unmarshal(b, &raw); err != nil {
+			return fmt.Errorf("config.json is not valid JSON: %w", err)
+		}
+	}
+
+	raw, from, err := migrate(raw)
+	if err != nil {
+		return err
+	}
+	if from < SCHEMA_VERSION {
+		fmt.Fprintf(w, "Schema version %d will be migrated to %d on next start. A backup will be kept.\n", from, SCHEMA_VERSION)
+	}
+
+	// Look for typos before parsing, which drops unknown keys from base.
+	known, err := toMap(NewConfig())
+	if err != nil {
+		return err
+	}
+	warnUnknown := func(prefix string, v any) {
+		m, _ := v.(map[string]any)
+		for _, k := range unknownKeys(prefix, m, known) {
+			fmt.Fprintf(w, "Warning: unknown key %q will be ignored.\n", k)
+		}
+	}
+	warnUnknown("base.", raw["base"])
+	if profiles, ok := raw["profiles"].(map[string]any); ok {
+		for name, p := range profiles {
+			warnUnknown(fmt.Sprintf("profiles.%s.", name), p)
+		}
+	}
+
+	pf, _, err := parseProfileFile(b)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(pf.Profiles))
+	for n := range pf.Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		cfg := NewConfig()
+		vals, err := pf.resolve(name)
+		if err == nil {
+			err = cfg.unmarshalMap(vals)
+		}
+		if err == nil {
+			err = cfg.Validate()
+		}
+
+		if err != nil {
+			errs = append(errs, &ErrProfile{name, err})
+			fmt.Fprintf(w, "Profile %q:\n", name)
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Fprintf(w, "  %s\n", line)
+			}
+			continue
+		}
+
+		fmt.Fprintf(w, "Profile %q: OK\n", name)
+	}
+
+	return errors.Join(errs...)
+}
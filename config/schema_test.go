@@ -0,0 +1,153 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// Decode a JSON literal like config.json would be.
+func rawJSON(t *testing.T, s string) map[string]any {
+	t.Helper()
+
+	var raw map[string]any
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    int
+		wantErr any
+	}{
+		{name: "new file", raw: `{}`, want: SCHEMA_VERSION},
+		{name: "flat", raw: `{"host": "kiwifarms.net", "tor": true}`, want: 0},
+		{name: "profiles", raw: `{"base": {}, "profiles": {"default": {}}}`, want: 1},
+		{name: "versioned", raw: `{"version": 2, "profiles": {}}`, want: 2},
+		{name: "newer", raw: `{"version": 99}`, wantErr: &ErrConfigVersion{}},
+		{name: "fraction", raw: `{"version": 1.5}`, wantErr: &ErrConfigType{}},
+		{name: "negative", raw: `{"version": -1}`, wantErr: &ErrConfigType{}},
+		{name: "string", raw: `{"version": "2"}`, wantErr: &ErrConfigType{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schemaVersion(rawJSON(t, tt.raw))
+			switch want := tt.wantErr.(type) {
+			case *ErrConfigVersion:
+				if !errors.As(err, &want) {
+					t.Fatalf("got %v, want ErrConfigVersion", err)
+				}
+			case *ErrConfigType:
+				if !errors.As(err, &want) {
+					t.Fatalf("got %v, want ErrConfigType", err)
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Fatalf("got version %d, want %d", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		want     string
+		wantFrom int
+	}{
+		{
+			name:     "flat",
+			raw:      `{"host": "kiwifarms.net", "tor": true}`,
+			want:     `{"version": 2, "default_profile": "default", "base": {"host": "kiwifarms.net", "tor": {"enabled": true}}, "profiles": {"default": {}}}`,
+			wantFrom: 0,
+		},
+		{
+			name:     "profiles with tor bools",
+			raw:      `{"default_profile": "alt", "base": {"tor": false}, "profiles": {"alt": {"tor": true}, "plain": {"room": 2}}}`,
+			want:     `{"version": 2, "default_profile": "alt", "base": {"tor": {"enabled": false}}, "profiles": {"alt": {"tor": {"enabled": true}}, "plain": {"room": 2}}}`,
+			wantFrom: 1,
+		},
+		{
+			name:     "tor objects left alone",
+			raw:      `{"base": {"tor": {"enabled": true, "onion_host": "x.onion"}}, "profiles": {}}`,
+			want:     `{"version": 2, "base": {"tor": {"enabled": true, "onion_host": "x.onion"}}, "profiles": {}}`,
+			wantFrom: 1,
+		},
+		{
+			name:     "current",
+			raw:      `{"version": 2, "base": {"tor": true}, "profiles": {}}`,
+			want:     `{"version": 2, "base": {"tor": true}, "profiles": {}}`,
+			wantFrom: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, from, err := migrate(rawJSON(t, tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if from != tt.wantFrom {
+				t.Fatalf("got from version %d, want %d", from, tt.wantFrom)
+			}
+
+			// Compared as JSON, since ints and floats differ in the maps.
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(rawJSON(t, tt.want))
+			if string(gotJSON) != string(wantJSON) {
+				t.Fatalf("got %s\nwant %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	_, _, err := migrate(rawJSON(t, `{"version": 3}`))
+	var ve *ErrConfigVersion
+	if !errors.As(err, &ve) || ve.Version != 3 {
+		t.Fatalf("got %v, want ErrConfigVersion for 3", err)
+	}
+}
+
+func TestValidateScriptsTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		timeout float64
+		wantErr bool
+	}{
+		{"disabled with stale value", false, 0, false},
+		{"disabled negative", false, -1, false},
+		{"enabled", true, 2, false},
+		{"enabled zero", true, 0, true},
+		{"enabled negative", true, -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Scripts.Enabled = tt.enabled
+			cfg.Scripts.Timeout = tt.timeout
+
+			// The defaults are valid otherwise, so any error is about scripts.timeout.
+			var cv *ErrConfigValue
+			err := cfg.Validate()
+			switch {
+			case !tt.wantErr && err != nil:
+				t.Fatalf("got %v, want no error", err)
+			case tt.wantErr && (!errors.As(err, &cv) || cv.Key != "scripts.timeout"):
+				t.Fatalf("got %v, want a scripts.timeout error", err)
+			}
+		})
+	}
+}
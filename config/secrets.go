@@ -0,0 +1,323 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	// Env var holding the passphrase for the secrets file.
+	PASSPHRASE_ENV = "SOCKCHAT_PASSPHRASE"
+	// Env var holding a file descriptor to read the passphrase from.
+	PASSPHRASE_FD_ENV = "SOCKCHAT_PASSPHRASE_FD"
+
+	_SECRETS_MAGIC = "SOCKSEC1"
+	_SALT_LEN      = 16
+)
+
+type ErrBadPassphrase struct{}
+
+func (e *ErrBadPassphrase) Error() string {
+	return "Failed to decrypt secrets file. Wrong passphrase?"
+}
+
+// Values kept out of config.json when secrets encryption is enabled.
+// Stored separately for each profile.
+type secrets struct {
+	Cookies   string `json:"cookies"`
+	ProxyUser string `json:"proxy_username"`
+	ProxyPass string `json:"proxy_password"`
+	TorPass   string `json:"tor_control_password,omitempty"`
+	IRCPass   string `json:"irc_password,omitempty"`
+}
+
+// Passphrase-encrypted file holding secrets for each profile.
+// File layout is: magic | scrypt salt | AES-GCM nonce | ciphertext.
+type secretStore struct {
+	mx   sync.Mutex
+	path string
+	salt []byte
+	aead cipher.AEAD
+
+	// Secrets keyed by profile name.
+	profiles map[string]secrets
+}
+
+func secretsPath() (string, error) {
+	cfgDir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cfgDir, "secrets.enc"), nil
+}
+
+func deriveAEAD(pass []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(pass, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Get the passphrase from the env, a file descriptor, or by prompting on the terminal.
+// confirm asks for it twice when prompting, for when a new store is being created.
+func readPassphrase(confirm bool) ([]byte, error) {
+	if p, ok := os.LookupEnv(PASSPHRASE_ENV); ok {
+		if p == "" {
+			return nil, fmt.Errorf("%s is set but empty.", PASSPHRASE_ENV)
+		}
+		return []byte(p), nil
+	}
+
+	if fdStr, ok := os.LookupEnv(PASSPHRASE_FD_ENV); ok {
+		fd, err := strconv.Atoi(fdStr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %s", PASSPHRASE_FD_ENV, fdStr)
+		}
+
+		f := os.NewFile(uintptr(fd), "passphrase")
+		if f == nil {
+			return nil, fmt.Errorf("Invalid %s: %s", PASSPHRASE_FD_ENV, fdStr)
+		}
+		defer f.Close()
+
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if err != nil && line == "" {
+			return nil, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line == "" {
+			return nil, fmt.Errorf("Passphrase read from %s can't be empty.", PASSPHRASE_FD_ENV)
+		}
+		return []byte(line), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("Secrets are encrypted, but no passphrase was provided. Set %s or %s.", PASSPHRASE_ENV, PASSPHRASE_FD_ENV)
+	}
+
+	fmt.Fprint(os.Stderr, "Secrets passphrase: ")
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("Passphrase can't be empty.")
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, errors.New("Passphrases don't match.")
+		}
+	}
+
+	return pass, nil
+}
+
+// Open the secrets file, creating a new store if it doesn't exist yet.
+func openSecretStore() (*secretStore, error) {
+	path, err := secretsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		salt := make([]byte, _SALT_LEN)
+		if _, err = rand.Read(salt); err != nil {
+			return nil, err
+		}
+
+		pass, err := readPassphrase(true)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := deriveAEAD(pass, salt)
+		if err != nil {
+			return nil, err
+		}
+
+		return &secretStore{
+			path:     path,
+			salt:     salt,
+			aead:     aead,
+			profiles: make(map[string]secrets, 1),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(b, []byte(_SECRETS_MAGIC)) {
+		return nil, fmt.Errorf("%s is not a sockchat secrets file.", path)
+	}
+	b = b[len(_SECRETS_MAGIC):]
+	if len(b) < _SALT_LEN {
+		return nil, fmt.Errorf("%s is truncated.", path)
+	}
+	salt, b := b[:_SALT_LEN], b[_SALT_LEN:]
+
+	pass, err := readPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := deriveAEAD(pass, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	ns := aead.NonceSize()
+	if len(b) < ns {
+		return nil, fmt.Errorf("%s is truncated.", path)
+	}
+	plain, err := aead.Open(nil, b[:ns], b[ns:], []byte(_SECRETS_MAGIC))
+	if err != nil {
+		return nil, &ErrBadPassphrase{}
+	}
+
+	profiles := make(map[string]secrets, 1)
+	if err = json.Unmarshal(plain, &profiles); err != nil {
+		// Migrate stores written before profiles were added.
+		var sec secrets
+		if json.Unmarshal(plain, &sec) != nil {
+			return nil, err
+		}
+		profiles[DEFAULT_PROFILE] = sec
+	}
+
+	return &secretStore{
+		path:     path,
+		salt:     salt,
+		aead:     aead,
+		profiles: profiles,
+	}, nil
+}
+
+func (ss *secretStore) save(profile string, sec secrets) error {
+	ss.mx.Lock()
+	defer ss.mx.Unlock()
+
+	ss.profiles[profile] = sec
+	plain, err := json.Marshal(ss.profiles)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, ss.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+
+	var bb bytes.Buffer
+	bb.WriteString(_SECRETS_MAGIC)
+	bb.Write(ss.salt)
+	bb.Write(nonce)
+	bb.Write(ss.aead.Seal(nil, nonce, plain, []byte(_SECRETS_MAGIC)))
+
+	// Write to a temp file first so a crash can't leave a half-written store.
+	tmp := ss.path + ".tmp"
+	if err = os.WriteFile(tmp, bb.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ss.path)
+}
+
+// Load secrets from the encrypted store into cfg.
+// Plaintext values still in config.json are migrated into the store.
+func (cfg *Config) loadSecrets(ss *secretStore) error {
+	cfg.fillSecrets(ss)
+
+	return cfg.saveSecrets()
+}
+
+// Fill in secrets from ss that aren't set in cfg, without writing the store.
+func (cfg *Config) fillSecrets(ss *secretStore) {
+	cfg.secrets = ss
+
+	if sec, ok := ss.profiles[cfg.Profile]; ok {
+		// Plaintext values left in config.json take precedence, since they were likely just added.
+		if cfg.Cookies == "" {
+			cfg.Cookies = sec.Cookies
+		}
+		if cfg.Proxy.User == "" {
+			cfg.Proxy.User = sec.ProxyUser
+		}
+		if cfg.Proxy.Pass == "" {
+			cfg.Proxy.Pass = sec.ProxyPass
+		}
+		if cfg.Tor.ControlPass == "" {
+			cfg.Tor.ControlPass = sec.TorPass
+		}
+		if cfg.IRC.Pass == "" {
+			cfg.IRC.Pass = sec.IRCPass
+		}
+	}
+}
+
+// Must be called with cfg.mx held, or on a Config not shared with other routines.
+// Values overridden by env vars or flags keep what's already in the store.
+func (cfg *Config) saveSecrets() error {
+	if cfg.secrets == nil {
+		return nil
+	}
+
+	cfg.secrets.mx.Lock()
+	sec := cfg.secrets.profiles[cfg.Profile]
+	cfg.secrets.mx.Unlock()
+
+	for _, f := range []struct {
+		key string
+		dst *string
+		val string
+	}{
+		{"cookies", &sec.Cookies, cfg.Cookies},
+		{"proxy.username", &sec.ProxyUser, cfg.Proxy.User},
+		{"proxy.password", &sec.ProxyPass, cfg.Proxy.Pass},
+		{"tor.control_password", &sec.TorPass, cfg.Tor.ControlPass},
+		{"irc.password", &sec.IRCPass, cfg.IRC.Pass},
+	} {
+		if !cfg.Overridden(f.key) {
+			*f.dst = f.val
+		}
+	}
+
+	return cfg.secrets.save(cfg.Profile, sec)
+}
+
+// Write cookies and other credentials to the encrypted store.
+// Does nothing if secrets encryption isn't enabled.
+func (cfg *Config) SaveSecrets() error {
+	cfg.mx.Lock()
+	defer cfg.mx.Unlock()
+
+	return cfg.saveSecrets()
+}
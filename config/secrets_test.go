@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadPassphraseEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{name: "set", env: "hunter2", want: "hunter2"},
+		{name: "spaces kept", env: " pass ", want: " pass "},
+		{name: "empty", env: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(PASSPHRASE_ENV, tt.env)
+
+			got, err := readPassphrase(false)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretStoreRoundTrip(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(PASSPHRASE_ENV, "correct horse")
+
+	ss, err := openSecretStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := secrets{Cookies: "a=1", ProxyUser: "bob", ProxyPass: "s3cret"}
+	if err = ss.save(DEFAULT_PROFILE, want); err != nil {
+		t.Fatal(err)
+	}
+
+	ss, err = openSecretStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ss.profiles[DEFAULT_PROFILE]; got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	t.Setenv(PASSPHRASE_ENV, "wrong")
+	if _, err = openSecretStore(); !errors.As(err, new(*ErrBadPassphrase)) {
+		t.Fatalf("got %v with the wrong passphrase, want ErrBadPassphrase", err)
+	}
+}
+
+// Values given by env var or flag are for one run, so they mustn't end up in the store.
+func TestSaveSecretsSkipsOverrides(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(PASSPHRASE_ENV, "correct horse")
+
+	ss, err := openSecretStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := secrets{Cookies: "stored=1", ProxyUser: "alice", ProxyPass: "stored-pass"}
+	if err = ss.save(DEFAULT_PROFILE, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		overrides []string
+		want      secrets
+	}{
+		{name: "none", want: secrets{Cookies: "refreshed=1", ProxyUser: "bob", ProxyPass: "one-off"}},
+		{name: "proxy password", overrides: []string{"proxy.password"},
+			want: secrets{Cookies: "refreshed=1", ProxyUser: "bob", ProxyPass: "stored-pass"}},
+		{name: "cookies and user", overrides: []string{"cookies", "proxy.username"},
+			want: secrets{Cookies: "stored=1", ProxyUser: "alice", ProxyPass: "one-off"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss.profiles[DEFAULT_PROFILE] = stored
+
+			cfg := NewConfig()
+			cfg.Profile = DEFAULT_PROFILE
+			cfg.secrets = ss
+			cfg.Cookies = "refreshed=1"
+			cfg.Proxy.User = "bob"
+			cfg.Proxy.Pass = "one-off"
+			for _, k := range tt.overrides {
+				cfg.setOverridden(k)
+			}
+
+			if err := cfg.SaveSecrets(); err != nil {
+				t.Fatal(err)
+			}
+			if got := ss.profiles[DEFAULT_PROFILE]; got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
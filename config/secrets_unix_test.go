@@ -0,0 +1,60 @@
+//go:build unix
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestReadPassphraseFD(t *testing.T) {
+	t.Setenv(PASSPHRASE_ENV, "")
+	os.Unsetenv(PASSPHRASE_ENV)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "line", input: "hunter2\n", want: "hunter2"},
+		{name: "crlf", input: "hunter2\r\n", want: "hunter2"},
+		{name: "no newline", input: "hunter2", want: "hunter2"},
+		{name: "empty line", input: "\n", wantErr: true},
+		{name: "nothing", input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.WriteString(tt.input)
+			w.Close()
+			// readPassphrase closes the fd it's given, so hand it a copy.
+			fd, err := syscall.Dup(int(r.Fd()))
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv(PASSPHRASE_FD_ENV, strconv.Itoa(fd))
+
+			got, err := readPassphrase(false)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Values allowed for tls.min_version.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Get the version number for tls.min_version.
+func (cfg *Config) TLSMinVersion() (uint16, error) {
+	v, ok := tlsVersions[cfg.TLS.MinVersion]
+	if !ok {
+		return 0, errors.New("must be 1.2 or 1.3.")
+	}
+
+	return v, nil
+}
+
+// Decode an SPKI pin, with or without the "sha256/" prefix used by curl and HPKP.
+func ParsePin(pin string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(pin), "sha256/"))
+	if err != nil || len(b) != sha256.Size {
+		return nil, errors.New("must be a base64 SHA-256 hash of the public key.")
+	}
+
+	return b, nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rivo/tview"
+)
+
+// Name of the page used for security alerts.
+const _ALERT_PAGE = "alert"
+
+// Pop up errors from v that the user needs to act on, like a TLS pin mismatch.
+// These would otherwise be easy to miss among the client msgs.
+func (ui *TUI) alertHandler(ctx context.Context, v *chatView) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-v.Chat.Alerts():
+			text := err.Error()
+			if v.identity != "" {
+				text = fmt.Sprintf("[%s] %s", v.identity, text)
+			}
+
+			ui.QueueUpdateDraw(func() {
+				ui.showAlert(text)
+			})
+		}
+	}
+}
+
+// Must be called from the UI routine.
+func (ui *TUI) showAlert(text string) {
+	modal := tview.NewModal().
+		SetText(text + "\n\nNot reconnecting until this is resolved.").
+		AddButtons([]string{"OK"}).
+		SetDoneFunc(func(int, string) {
+			ui.pages.RemovePage(_ALERT_PAGE)
+			ui.SetFocus(ui.views[ui.active].flex)
+		})
+
+	// Replaces any alert that's already open.
+	ui.pages.AddPage(_ALERT_PAGE, modal, true, true)
+	ui.SetFocus(modal)
+}
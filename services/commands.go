@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Client-side slash command. Handled locally instead of being sent to the server.
+type command struct {
+	usage string
+	// args is the raw text following the command name.
+	run func(ctx context.Context, args string) error
+}
+
+func (ui *chatView) registerCommands() {
+	ui.cmds = map[string]command{
+		"outbox": {
+			usage: "/outbox [list | edit ID TEXT | cancel ID | clear]",
+			run:   ui.outboxCmd,
+		},
+		"apply": {
+			usage: "/apply",
+			run:   ui.applyCmd,
+		},
+		"trustonion": {
+			usage: "/trustonion",
+			run:   ui.trustOnionCmd,
+		},
+		"newnym": {
+			usage: "/newnym",
+			run:   ui.newnymCmd,
+		},
+		"feeds": {
+			usage: "/feeds",
+			run:   ui.feedsCmd,
+		},
+	}
+}
+
+// Run msg if it's a client command.
+// Returns false if it isn't one, in which case it should be sent as usual.
+func (ui *chatView) runCommand(ctx context.Context, msg string) bool {
+	if !strings.HasPrefix(msg, "/") {
+		return false
+	}
+
+	name, args, _ := strings.Cut(msg[1:], " ")
+	args = strings.TrimSpace(args)
+	cmd, ok := ui.cmds[name]
+	if !ok {
+		return ui.runChatCommand(ctx, name, args)
+	}
+
+	if err := cmd.run(ctx, args); err != nil {
+		ui.Chat.ClientMsg(fmt.Sprintf("%s\nUsage: %s", err, cmd.usage), false)
+	}
+
+	return true
+}
+
+// Run a command added by a script, if there's one named name.
+// Scripts may take a while, so they're run in the background.
+func (ui *chatView) runChatCommand(ctx context.Context, name, args string) bool {
+	cmd, ok := ui.Chat.Commands.Lookup(name)
+	if !ok {
+		// Probably meant for the server, like /join.
+		return false
+	}
+
+	go func() {
+		if err := cmd.Run(ctx, args); err != nil {
+			ui.Chat.ClientMsg(fmt.Sprintf("%s\nUsage: %s", err, cmd.Usage), false)
+		}
+	}()
+
+	return true
+}
+
+func (ui *chatView) outboxCmd(ctx context.Context, args string) error {
+	sub, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+
+	parseID := func() (uint, string, error) {
+		idStr, text, _ := strings.Cut(rest, " ")
+		id, err := strconv.ParseUint(idStr, 10, 0)
+		if err != nil {
+			return 0, "", fmt.Errorf("Invalid outbox ID: %s", idStr)
+		}
+
+		return uint(id), strings.TrimSpace(text), nil
+	}
+
+	ob := ui.Chat.Outbox
+	switch sub {
+	case "", "list":
+		msgs := ob.List()
+		if len(msgs) == 0 {
+			ui.Chat.ClientMsg("Outbox is empty.", false)
+			return nil
+		}
+
+		var sb strings.Builder
+		sb.WriteString("Outbox:")
+		for _, m := range msgs {
+			fmt.Fprintf(&sb, "\n#%d [%s] %s", m.ID, m.Queued.Format("15:04:05"), m.Text)
+		}
+		ui.Chat.ClientMsg(sb.String(), false)
+	case "edit":
+		id, text, err := parseID()
+		if err != nil {
+			return err
+		}
+		if text == "" {
+			return fmt.Errorf("No replacement text given for #%d.", id)
+		}
+
+		if err = ob.Edit(id, text); err != nil {
+			return err
+		}
+		ui.Chat.ClientMsg(fmt.Sprintf("Edited outbox msg #%d.", id), false)
+	case "cancel":
+		id, _, err := parseID()
+		if err != nil {
+			return err
+		}
+
+		if err = ob.Cancel(id); err != nil {
+			return err
+		}
+		ui.Chat.ClientMsg(fmt.Sprintf("Cancelled outbox msg #%d.", id), false)
+	case "clear":
+		if err := ob.Clear(); err != nil {
+			return err
+		}
+		ui.Chat.ClientMsg("Outbox cleared.", false)
+	default:
+		return fmt.Errorf("Unknown outbox command: %s", sub)
+	}
+
+	return nil
+}
+
+// Reconnect to apply config changes that were put off.
+func (ui *chatView) applyCmd(ctx context.Context, args string) error {
+	if len(ui.Chat.Pending()) == 0 {
+		ui.Chat.ClientMsg("No config changes waiting for a reconnect.", false)
+		return nil
+	}
+
+	go ui.applyPending(ctx)
+	return nil
+}
+
+// Get a new Tor identity and reconnect with it.
+func (ui *chatView) newnymCmd(ctx context.Context, args string) error {
+	go func() {
+		if err := ui.Chat.NewIdentity(ctx); err != nil {
+			ui.Chat.ClientMsg(fmt.Sprintf("Failed to get a new Tor identity: %s", err), false)
+		}
+	}()
+
+	return nil
+}
+
+// Accept a changed onion address after being warned about it.
+func (ui *chatView) trustOnionCmd(ctx context.Context, args string) error {
+	return ui.Chat.TrustOnion()
+}
+
+// List msg subscribers and how far behind they are.
+// Run in the background, since the feeder may be waiting on a blocked subscriber.
+func (ui *chatView) feedsCmd(ctx context.Context, args string) error {
+	go func() {
+		var sb strings.Builder
+		sb.WriteString("Feeds:")
+		for _, st := range ui.Chat.Feeder.Stats() {
+			name := st.Name
+			if name == "" {
+				name = "(unnamed)"
+			}
+			fmt.Fprintf(&sb, "\n%s: %s, %d/%d queued, %d dropped", name, st.Overflow, st.Queued, st.Cap, st.Drops)
+		}
+		ui.Chat.ClientMsg(sb.String(), false)
+	}()
+
+	return nil
+}
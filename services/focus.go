@@ -0,0 +1,67 @@
+package services
+
+import (
+	"os"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Passes terminal focus events to onFocus, since tview drops them.
+type focusScreen struct {
+	tcell.Screen
+	onFocus func(focused bool)
+}
+
+func (s *focusScreen) PollEvent() tcell.Event {
+	for {
+		ev := s.Screen.PollEvent()
+		if fe, ok := ev.(*tcell.EventFocus); ok {
+			s.onFocus(fe.Focused)
+			continue
+		}
+
+		return ev
+	}
+}
+
+// Set up the screen to report focus changes, if the terminal supports it.
+func (ui *TUI) setupScreen() error {
+	scr, err := tcell.NewScreen()
+	if err != nil {
+		return err
+	}
+
+	ui.screen = &focusScreen{scr, func(focused bool) {
+		ui.QueueUpdate(func() {
+			ui.termFocused = focused
+			ui.updateFocus()
+		})
+	}}
+	ui.SetScreen(ui.screen)
+	ui.screen.EnableFocus()
+
+	return nil
+}
+
+// Tell each session whether it's on screen in a focused terminal, for notifications.
+// Must be called from the UI routine.
+func (ui *TUI) updateFocus() {
+	for i, v := range ui.views {
+		v.Chat.SetFocused(ui.termFocused && i == ui.active)
+	}
+}
+
+// Write an escape sequence to the terminal, in between screen updates so it doesn't garble them.
+func (ui *TUI) writeTerm(seq string) error {
+	ui.QueueUpdate(func() {
+		if ui.screen != nil {
+			if tty, ok := ui.screen.Tty(); ok {
+				tty.Write([]byte(seq))
+				return
+			}
+		}
+		os.Stdout.WriteString(seq)
+	})
+
+	return nil
+}
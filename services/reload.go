@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+// Name of the page used for the reconnect prompt.
+const _RELOAD_PAGE = "reload"
+
+// React to config reloads for v.
+// Changes that need a reconnect are confirmed with a prompt first.
+func (ui *TUI) configHandler(ctx context.Context, v *chatView) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u := <-v.Chat.ConfigUpdates():
+			if slices.Contains(u.Applied, "read_only") {
+				ro := v.Chat.Config().ReadOnly
+				ui.QueueUpdateDraw(func() {
+					v.setReadOnly(ctx, ro)
+				})
+			}
+
+			if len(u.Pending) > 0 {
+				ui.QueueUpdateDraw(func() {
+					ui.confirmReconnect(ctx, v, u.Pending)
+				})
+			}
+		}
+	}
+}
+
+// Ask whether to reconnect now to apply pending config changes to v.
+// Must be called from the UI routine.
+func (ui *TUI) confirmReconnect(ctx context.Context, v *chatView, keys []string) {
+	text := fmt.Sprintf("config.json changed %s, which needs a reconnect to apply.", strings.Join(keys, ", "))
+	if v.identity != "" {
+		text = fmt.Sprintf("[%s] %s", v.identity, text)
+	}
+
+	modal := tview.NewModal().
+		SetText(text + "\n\nReconnect now? Use /apply to do it later.").
+		AddButtons([]string{"Reconnect", "Later"}).
+		SetDoneFunc(func(_ int, label string) {
+			ui.pages.RemovePage(_RELOAD_PAGE)
+			ui.SetFocus(ui.views[ui.active].flex)
+
+			if label == "Reconnect" {
+				go v.applyPending(ctx)
+			}
+		})
+
+	// Replaces any prompt that's already open.
+	ui.pages.AddPage(_RELOAD_PAGE, modal, true, true)
+	ui.SetFocus(modal)
+}
+
+func (ui *chatView) applyPending(ctx context.Context) {
+	if err := ui.Chat.ApplyPending(ctx); err != nil {
+		ui.Chat.ClientMsg(fmt.Sprintf("Failed to apply config changes: %s", err), false)
+	}
+}
+
+// Show or hide the input box for read-only mode.
+// Must be called from the UI routine.
+func (ui *chatView) setReadOnly(ctx context.Context, ro bool) {
+	switch {
+	case ro && ui.inputBox != nil:
+		if ui.inputBox.HasFocus() {
+			ui.SetFocus(ui.Console)
+		}
+
+		ui.flex.RemoveItem(ui.inputBox)
+		ui.inputBox = nil
+	case !ro && ui.inputBox == nil:
+		ui.inputBox = ui.newInputBox(ctx)
+		ui.flex.AddItem(ui.inputBox, 1, 1, true)
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"y-a-t-s/sockchat/chat"
+
+	"github.com/rivo/tview"
+)
+
+type sendEntry struct {
+	// Names the console region holding the entry's line.
+	id     int
+	text   string
+	status chat.SendStatus
+	// Set once the line should be removed from the console.
+	gone bool
+}
+
+// Outgoing msgs that are still pending or failed to send.
+// Each is shown as a line in the console until its echo arrives.
+type sendList struct {
+	mx      sync.Mutex
+	entries []*sendEntry
+	nextID  int
+}
+
+func (sl *sendList) add(text string) *sendEntry {
+	sl.mx.Lock()
+	defer sl.mx.Unlock()
+
+	sl.nextID++
+	se := &sendEntry{
+		id:     sl.nextID,
+		text:   text,
+		status: chat.SendPending,
+	}
+	sl.entries = append(sl.entries, se)
+
+	return se
+}
+
+func (sl *sendList) set(se *sendEntry, st chat.SendStatus) {
+	sl.mx.Lock()
+	defer sl.mx.Unlock()
+
+	se.status = st
+	if st != chat.SendSent && st != chat.SendQueued {
+		return
+	}
+
+	// Confirmed msgs show up in the console on their own, and queued ones in the outbox count.
+	se.gone = true
+	sl.remove(se)
+}
+
+// Must be called with sl.mx held.
+func (sl *sendList) remove(se *sendEntry) {
+	for i, e := range sl.entries {
+		if e == se {
+			sl.entries = append(sl.entries[:i], sl.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Pop all failed entries so they can be sent again.
+func (sl *sendList) takeFailed() []*sendEntry {
+	sl.mx.Lock()
+	defer sl.mx.Unlock()
+
+	failed := make([]*sendEntry, 0, len(sl.entries))
+	kept := sl.entries[:0]
+	for _, e := range sl.entries {
+		if e.status == chat.SendFailed {
+			e.gone = true
+			failed = append(failed, e)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	sl.entries = kept
+
+	return failed
+}
+
+// Entries that still have a line in the console, oldest first.
+func (sl *sendList) live() []*sendEntry {
+	sl.mx.Lock()
+	defer sl.mx.Unlock()
+
+	return append([]*sendEntry(nil), sl.entries...)
+}
+
+func (se *sendEntry) region() string {
+	return fmt.Sprintf(`["s%d"]`, se.id)
+}
+
+// Console line for se in its current state. Empty if it shouldn't be shown.
+func (sl *sendList) line(se *sendEntry) string {
+	sl.mx.Lock()
+	defer sl.mx.Unlock()
+
+	if se.gone {
+		return ""
+	}
+
+	text := tview.Escape(se.text)
+	switch se.status {
+	case chat.SendPending:
+		return fmt.Sprintf("%s[::d]… %s[::D][\"\"]\n", se.region(), text)
+	case chat.SendFailed:
+		return fmt.Sprintf("%s[red]✗ %s (F6 to retry)[-][\"\"]\n", se.region(), text)
+	default:
+		return ""
+	}
+}
+
+// Add, update or remove the console line of se.
+// Must be called from incomingHandler, which owns the console's contents.
+func (ui *chatView) drawSend(se *sendEntry) {
+	line := ui.sends.line(se)
+
+	text := ui.Console.GetText(false)
+	start := strings.Index(text, se.region())
+	if start < 0 {
+		// Not shown yet, or already scrolled out of the history.
+		if line != "" {
+			io.WriteString(ui.Console, line)
+		}
+		return
+	}
+
+	end := strings.Index(text[start:], "[\"\"]\n")
+	if end < 0 {
+		return
+	}
+	end += start + len("[\"\"]\n")
+
+	ui.Console.SetText(text[:start] + line + text[end:])
+}
+
+// Redraw the console line of se once incomingHandler gets to it.
+func (ui *chatView) updateSend(ctx context.Context, se *sendEntry) {
+	// Lines are drawn from se's state at the time, so the order updates arrive in doesn't matter.
+	go func() {
+		select {
+		case <-ctx.Done():
+		case ui.sendUpdates <- se:
+		}
+	}()
+}
+
+// Queue msg and track its delivery state on its line in the console.
+// Queueing happens before returning so msgs keep their order.
+func (ui *chatView) send(ctx context.Context, msg string) {
+	se := ui.sends.add(msg)
+	reply := ui.Chat.SendAsync(ctx, msg)
+	ui.updateSend(ctx, se)
+
+	go func() {
+		r := <-reply
+		var (
+			qe *chat.ErrQueuedOffline
+			ue *chat.ErrUnconfirmed
+		)
+		switch {
+		case errors.As(r.Err, &qe):
+			// Shown in the outbox count instead.
+			ui.sends.set(se, chat.SendQueued)
+		case errors.As(r.Err, &ue):
+			// Without user_id, the echo is all there is to go on.
+			ui.sends.set(se, chat.SendSent)
+		case r.Err != nil:
+			ui.Chat.Errs <- r.Err
+			ui.sends.set(se, chat.SendFailed)
+		default:
+			ui.sends.set(se, chat.SendSent)
+		}
+
+		ui.updateSend(ctx, se)
+	}()
+}
+
+func (ui *chatView) retryFailed(ctx context.Context) {
+	for _, se := range ui.sends.takeFailed() {
+		ui.updateSend(ctx, se)
+		ui.send(ctx, se.text)
+	}
+}
@@ -18,21 +18,156 @@ import (
 
 const _HISTORY_LEN uint8 = 4
 
+// Holds a tab for each chat session.
 type TUI struct {
 	*tview.Application
 
+	root  *tview.Flex
+	tabs  *tview.TextView
+	pages *tview.Pages
+
+	views  []*chatView
+	active int
+
+	screen tcell.Screen
+	// Only known if the terminal reports focus changes. Assumed unfocused until then.
+	termFocused bool
+}
+
+// Console and input for a single chat session.
+type chatView struct {
+	*tview.Application
+
 	flex     *tview.Flex
 	Console  *tview.TextView
 	inputBox *tview.InputField
+	// Outgoing msgs shown in the console until they're confirmed.
+	sends       sendList
+	sendUpdates chan *sendEntry
+
+	cmds map[string]command
+	// Shown in the input label to tell which account is sending.
+	// Empty when there's only 1 session.
+	identity string
 
 	Chat *chat.Chat
 }
 
-func StartTUI(ctx context.Context, c *chat.Chat) {
-	ui := TUI{}
+func StartTUI(ctx context.Context, chats ...*chat.Chat) {
+	ui := TUI{
+		Application: tview.NewApplication(),
+		root:        tview.NewFlex().SetDirection(tview.FlexRow),
+		pages:       tview.NewPages(),
+		views:       make([]*chatView, len(chats)),
+	}
+
+	// Without focus reporting, notifications just aren't suppressed.
+	if err := ui.setupScreen(); err != nil {
+		chats[0].Errs <- err
+	}
+
+	for i, c := range chats {
+		c.SetTerminal(ui.writeTerm)
+		ui.views[i] = newChatView(ctx, ui.Application, c, len(chats) > 1)
+		ui.pages.AddPage(strconv.Itoa(i), ui.views[i].flex, true, i == 0)
+	}
+
+	// Only bother with tabs if there's more than 1 session.
+	if len(chats) > 1 {
+		ui.tabs = tview.NewTextView().
+			SetDynamicColors(true).
+			SetRegions(true).
+			SetWrap(false)
+		ui.root.AddItem(ui.tabs, 1, 0, false)
+		ui.drawTabs()
+
+		for _, v := range ui.views {
+			go ui.tabHandler(ctx, v)
+		}
+	}
+	ui.root.AddItem(ui.pages, 0, 1, true)
+
+	ui.SetInputCapture(func(key *tcell.EventKey) *tcell.EventKey {
+		switch key.Name() {
+		case "Ctrl+N":
+			ui.switchTab(ui.active + 1)
+			return nil
+		case "Ctrl+P":
+			ui.switchTab(ui.active - 1)
+			return nil
+		}
+
+		return key
+	})
+
+	ui.SetRoot(ui.root, true).SetFocus(ui.views[0].flex)
+
+	for _, v := range ui.views {
+		go v.incomingHandler(ctx)
+		go v.pendingHandler(ctx)
+		go ui.configHandler(ctx, v)
+		go ui.alertHandler(ctx, v)
+	}
+	ui.Run()
+}
+
+// Label for the tab of v. Uses the username once it's known.
+func (v *chatView) tabName() string {
+	if name := v.Chat.Users.ClientName(); name != "" {
+		return fmt.Sprintf("%s (%s)", name, v.Chat.Config().Profile)
+	}
+
+	return v.Chat.Config().Profile
+}
+
+func (ui *TUI) drawTabs() {
+	if ui.tabs == nil {
+		return
+	}
+
+	var sb strings.Builder
+	for i, v := range ui.views {
+		fmt.Fprintf(&sb, `["%d"] %d: %s [""]`, i, i+1, tview.Escape(v.tabName()))
+	}
+
+	ui.tabs.SetText(sb.String())
+	ui.tabs.Highlight(strconv.Itoa(ui.active))
+}
+
+func (ui *TUI) switchTab(i int) {
+	n := len(ui.views)
+	if n < 2 {
+		return
+	}
+
+	// Wrap around at either end.
+	ui.active = (i%n + n) % n
+	ui.pages.SwitchToPage(strconv.Itoa(ui.active))
+	ui.SetFocus(ui.views[ui.active].flex)
+	ui.drawTabs()
+	ui.updateFocus()
+}
+
+// Update the tab label once the session's username is known.
+func (ui *TUI) tabHandler(ctx context.Context, v *chatView) {
+	select {
+	case <-ctx.Done():
+	case <-v.Chat.Users.ClientFound():
+		ui.QueueUpdateDraw(ui.drawTabs)
+	}
+}
+
+func newChatView(ctx context.Context, app *tview.Application, c *chat.Chat, multi bool) *chatView {
+	ui := &chatView{
+		Application: app,
+		Chat:        c,
+		sendUpdates: make(chan *sendEntry, 8),
+	}
+	if multi {
+		ui.identity = c.Config().Profile
+	}
 
-	ui.Application = tview.NewApplication()
-	ui.Chat = c
+	ui.registerCommands()
 	ui.flex = tview.NewFlex().SetDirection(tview.FlexRow)
 
 	ui.Console = tview.NewTextView().
@@ -74,25 +209,71 @@ func StartTUI(ctx context.Context, c *chat.Chat) {
 
 	ui.flex.AddItem(ui.Console, 0, 1, false)
 	// Don't enable msg input box in RO mode.
-	if !c.Cfg.ReadOnly {
-		ui.inputBox = ui.newInputBox(ctx)
-		ui.flex.AddItem(ui.inputBox, 1, 1, true)
+	ui.setReadOnly(ctx, c.Config().ReadOnly)
+
+	return ui
+}
+
+// Label for the input box, showing the sending identity if there are several.
+func (ui *chatView) inputLabel(info string) string {
+	label := "> "
+	if info != "" {
+		label = fmt.Sprintf("(%s) > ", info)
+	}
+	if ui.identity == "" {
+		return label
 	}
 
-	ui.SetRoot(ui.flex, true).SetFocus(ui.flex)
+	ident := ui.identity
+	if name := ui.Chat.Users.ClientName(); name != "" {
+		ident = name
+	}
 
-	go ui.incomingHandler(ctx)
-	ui.Run()
+	return fmt.Sprintf("[%s] %s", tview.Escape(ident), label)
+}
+
+// Show the number of queued outgoing msgs in the input box label.
+func (ui *chatView) pendingHandler(ctx context.Context) {
+	found := ui.Chat.Users.ClientFound()
+	var pending, saved int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case pending = <-ui.Chat.Queue.Updates():
+		case saved = <-ui.Chat.Outbox.Updates():
+		case <-found:
+			// Refresh the label with the username, once.
+			found = nil
+		}
+
+		counts := make([]string, 0, 2)
+		if pending > 0 {
+			counts = append(counts, fmt.Sprintf("%d pending", pending))
+		}
+		if saved > 0 {
+			counts = append(counts, fmt.Sprintf("%d in outbox", saved))
+		}
+
+		label := ui.inputLabel(strings.Join(counts, ", "))
+
+		ui.QueueUpdateDraw(func() {
+			// Hidden in read-only mode, which may be toggled by a config reload.
+			if ui.inputBox != nil {
+				ui.inputBox.SetLabel(label)
+			}
+		})
+	}
 }
 
-func (ui *TUI) newInputBox(ctx context.Context) *tview.InputField {
+func (ui *chatView) newInputBox(ctx context.Context) *tview.InputField {
 	ib := tview.NewInputField().
 		// Idk what the site caps it at.
 		SetAcceptanceFunc(tview.InputFieldMaxLength(2048)).
 		// Use terminal background color for input box.
 		SetFieldBackgroundColor(tcell.PaletteColor(0)).
 		SetFieldWidth(0).
-		SetLabel("> ")
+		SetLabel(ui.inputLabel(""))
 
 	tabHandler := func(msg string) string {
 		return regexp.MustCompile(`@(\d+)`).ReplaceAllStringFunc(msg, func(m string) string {
@@ -140,6 +321,8 @@ func (ui *TUI) newInputBox(ctx context.Context) *tview.InputField {
 				ui.Chat.Errs <- err
 				return key
 			}
+		case "F6":
+			ui.retryFailed(ctx)
 		case "Up":
 			hl := len(History.hist) - 1
 			if histIdx > hl {
@@ -172,11 +355,16 @@ func (ui *TUI) newInputBox(ctx context.Context) *tview.InputField {
 				return
 			}
 
-			// Add outgoing message to queue.
-			ui.Chat.Out <- msg
 			ib.SetText("")
-
 			History.Add(msg)
+
+			// Client commands are handled locally instead of being sent.
+			if ui.runCommand(ctx, msg) {
+				return
+			}
+
+			// Add outgoing message to queue.
+			ui.send(ctx, msg)
 		case tcell.KeyBacktab:
 			if ui.Console == nil || ui.flex == nil {
 				return
@@ -194,7 +382,7 @@ func (ui *TUI) newInputBox(ctx context.Context) *tview.InputField {
 	return ib
 }
 
-func (ui *TUI) incomingHandler(ctx context.Context) {
+func (ui *chatView) incomingHandler(ctx context.Context) {
 	defer ui.Stop()
 
 	var (
@@ -240,6 +428,10 @@ func (ui *TUI) incomingHandler(ctx context.Context) {
 		if msg.MessageEditDate != 0 {
 			fl = "[::d]*[::D]"
 		}
+		// Own msgs in the console have been echoed by the server, so they're confirmed sent.
+		if msg.Author.ID == ui.Chat.Users.ClientID() {
+			fl += "[::d]✓[::D]"
+		}
 
 		// Print chat message, preceded by the sender's username and ID.
 		return fmt.Sprintf("[%s::u]%s[-::U] %s [\"%d\"]%s[\"\"][-:-:-:-]\n",
@@ -254,7 +446,7 @@ func (ui *TUI) incomingHandler(ctx context.Context) {
 	}
 
 	// Chat msg feed from socket.
-	feed := ui.Chat.Feeder.Feed()
+	feed := ui.Chat.Feeder.Feed(chat.Named("ui"))
 	defer feed.Close()
 
 	for {
@@ -279,11 +471,18 @@ func (ui *TUI) incomingHandler(ctx context.Context) {
 				}
 			}
 
+			// Lines of unconfirmed sends stay at the bottom.
+			for _, se := range ui.sends.live() {
+				bb.WriteString(ui.sends.line(se))
+			}
+
 			ui.Console.Clear()
 			ui.Console.ScrollToEnd()
 			bb.WriteTo(ui.Console)
 			bb.Reset()
 			highlight()
+		case se := <-ui.sendUpdates:
+			ui.drawSend(se)
 		case msg := <-feed.Feed:
 			if msg.IsEdited() && msg.MessageID <= prevID {
 				continue
@@ -25,6 +25,9 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -38,11 +41,26 @@ import (
 )
 
 func main() {
+	// Subcommands that run instead of the client.
+	if len(os.Args) > 2 && os.Args[1] == "config" {
+		switch os.Args[2] {
+		case "check":
+			if err := config.CheckConfig(os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown config command: %s\n", os.Args[2])
+			os.Exit(2)
+		}
+	}
+
 	// Catch terminating signals to try shutting down gracefully.
 	// Needed to ensure log buffer gets flushed.
 	sigs := []os.Signal{
-		syscall.SIGABRT, syscall.SIGHUP,
-		syscall.SIGINT, syscall.SIGKILL,
+		syscall.SIGABRT, syscall.SIGINT,
+		syscall.SIGKILL,
 		syscall.SIGPIPE, syscall.SIGQUIT,
 		syscall.SIGSEGV, syscall.SIGTERM,
 	}
@@ -59,20 +77,66 @@ func main() {
 	// Ensures all routines terminate before the program exits.
 	var wg sync.WaitGroup
 
-	cfg, err := config.LoadConfig()
+	// Several profiles may be given to run more than 1 account at once.
+	cfgs, err := config.LoadProfiles(config.ProfileArgs()...)
 	if err != nil {
-		log.Panic(err)
+		// Config errors are the user's to fix, so a stack trace won't help.
+		log.Fatal(err)
 	}
-	// Preserve original cfg before overwriting values during arg parsing.
-	// If we didn't, temporary args would be applied to the config on exit.
-	args := cfg
 
-	err = args.ParseArgs()
-	if err != nil {
-		log.Panic(err)
+	// Guards cfgs and argCfgs, which are replaced on config reload.
+	var cfgMx sync.Mutex
+	// cfgs with env and arg overrides applied.
+	argCfgs := make([]config.Config, len(cfgs))
+	// Why chats failed to start, shown once the TUI is gone. Also guarded by cfgMx.
+	var startErrs []error
+
+	chats := make([]*chat.Chat, len(cfgs))
+	for i, cfg := range cfgs {
+		// Preserve original cfg before overwriting values during arg parsing.
+		// If we didn't, temporary args would be applied to the config on exit.
+		args := cfg
+
+		err = args.ParseArgs()
+		if errors.Is(err, flag.ErrHelp) {
+			// Usage was already printed.
+			os.Exit(0)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		argCfgs[i] = args
+
+		c, err := chat.NewChat(ctx, args)
+		if err != nil {
+			log.Panic(err)
+		}
+		chats[i] = c
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			err := c.Start(ctx)
+
+			cfgMx.Lock()
+			defer cfgMx.Unlock()
+			if err != nil {
+				startErrs = append(startErrs, err)
+				return
+			}
+			// Cookies given by env var or flag are for this run only.
+			if !args.Overridden("cookies") {
+				cfgs[i].Cookies = c.Config().Cookies
+			}
+			cfgs[i].Save()
+		}()
 	}
 
-	c, err := chat.NewChat(ctx, args)
+	// SIGHUP reloads the config instead of quitting.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	reload, err := config.WatchConfig(ctx, hup)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -80,19 +144,52 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer cancel()
-		c.Start(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reload:
+			}
+
+			cfgMx.Lock()
+			fresh, err := config.ReloadProfiles(cfgs)
+			if err != nil {
+				cfgMx.Unlock()
+				for _, c := range chats {
+					c.ClientMsg(fmt.Sprintf("Config not reloaded: %s", err), false)
+				}
+				continue
+			}
 
-		cfg.Cookies = c.Cfg.Cookies
-		cfg.Save()
+			for i, c := range chats {
+				// Only apply what changed in the file, so values updated during the session are kept.
+				keys := cfgs[i].Changed(fresh[i])
+				args := fresh[i]
+				if err = args.ReparseArgs(argCfgs[i]); err != nil {
+					c.ClientMsg(fmt.Sprintf("Config not reloaded: %s", err), false)
+					// Keep the old values so the changes are picked up again once fixed.
+					fresh[i] = cfgs[i]
+					continue
+				}
+
+				argCfgs[i] = args
+				c.UpdateConfig(ctx, args, keys)
+			}
+			cfgs = fresh
+			cfgMx.Unlock()
+		}
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		defer cancel()
-		services.StartTUI(ctx, c)
+		services.StartTUI(ctx, chats...)
 	}()
 	wg.Wait()
 	cancel()
+
+	if err := errors.Join(startErrs...); err != nil {
+		log.Fatal(err)
+	}
 }